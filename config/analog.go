@@ -0,0 +1,113 @@
+package config
+
+import "fmt"
+
+// Pins that support hardware PWM on the Raspberry Pi.
+var pwmPins = map[int]bool{12: true, 13: true, 18: true, 19: true}
+
+// PWM frequency in Hz, unless configured in analog.frequency.
+const defaultPWMFrequency = 1000
+
+// Modes that map onto a configurable percentage, with their defaults.
+var defaultAnalogSpeeds = map[string]float64{"speed1": 30, "speed2": 60, "speed3": 100}
+
+// AnalogConfig describes continuous speed control through a PWM output, as configured in analog.
+type AnalogConfig struct {
+	Output    string             // Name of the PWM or DAC output in gpio.outputs
+	Frequency int                // PWM frequency in Hz
+	Min       float64            // Lowest percentage sent to the unit
+	Max       float64            // Highest percentage sent to the unit
+	Ramp      float64            // Largest change in percent per second, 0 for no limit
+	Speeds    map[string]float64 // Percentage for speed1, speed2 and speed3
+}
+
+// GetAnalog returns the analog speed control, and whether it is configured. Without it, the frequency
+// is the default.
+func GetAnalog() (AnalogConfig, bool) {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("analog.output") {
+		return AnalogConfig{Frequency: defaultPWMFrequency}, false
+	}
+	analog := AnalogConfig{
+		Output:    viperInst.GetString("analog.output"),
+		Frequency: defaultPWMFrequency,
+		Min:       0,
+		Max:       100,
+		Ramp:      viperInst.GetFloat64("analog.ramp"),
+		Speeds:    make(map[string]float64),
+	}
+	if viperInst.IsSet("analog.frequency") {
+		analog.Frequency = viperInst.GetInt("analog.frequency")
+	}
+	if viperInst.IsSet("analog.min") {
+		analog.Min = viperInst.GetFloat64("analog.min")
+	}
+	if viperInst.IsSet("analog.max") {
+		analog.Max = viperInst.GetFloat64("analog.max")
+	}
+	for mode, percent := range defaultAnalogSpeeds {
+		analog.Speeds[mode] = percent
+		if key := "analog.speeds." + mode; viperInst.IsSet(key) {
+			analog.Speeds[mode] = viperInst.GetFloat64(key)
+		}
+	}
+	return analog, true
+}
+
+// Clamp limits a percentage to the configured minimum and maximum.
+func (a AnalogConfig) Clamp(percent float64) float64 {
+	if percent < a.Min {
+		return a.Min
+	}
+	if percent > a.Max {
+		return a.Max
+	}
+	return percent
+}
+
+// Verifies the analog speed control.
+func verifyAnalog() error {
+	outputs := GetGPIOOutputs()
+	analog, ok := GetAnalog()
+	if err := verifyPWM(outputs, ok); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if output, ok := outputs[analog.Output]; !ok || !output.Analog() {
+		return fmt.Errorf("config: analog.output must refer to a pwm or dac output")
+	}
+	if analog.Frequency <= 0 {
+		return fmt.Errorf("config: analog.frequency must be a positive integer")
+	}
+	if analog.Min < 0 || analog.Max > 100 || analog.Min > analog.Max {
+		return fmt.Errorf("config: analog.min and analog.max must satisfy 0 <= min <= max <= 100")
+	}
+	if analog.Ramp < 0 {
+		return fmt.Errorf("config: analog.ramp must not be negative")
+	}
+	for mode, percent := range analog.Speeds {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("config: analog.speeds.%s must be a percentage", mode)
+		}
+	}
+	return nil
+}
+
+// Verifies the PWM outputs, which are driven at the frequency of the analog speed control.
+func verifyPWM(outputs map[string]OutputConfig, analog bool) error {
+	for _, name := range sortedNames(outputs) {
+		output := outputs[name]
+		if output.Type != OutputGPIO || !output.PWM {
+			continue
+		}
+		if !pwmPins[output.Pin] {
+			return fmt.Errorf("config: gpio.outputs.%s.pin must be a hardware PWM pin (12, 13, 18 or 19)", name)
+		}
+		if !analog {
+			return fmt.Errorf("config: gpio.outputs.%s is a pwm output, which requires analog.output", name)
+		}
+	}
+	return nil
+}
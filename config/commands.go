@@ -0,0 +1,288 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ways the outputs control the unit.
+const (
+	ControlPulse = "pulse" // ControlPulse presses buttons on the remote with short pulses
+	ControlLevel = "level" // ControlLevel holds the output of the selected switch position active
+)
+
+// Priority classes of commands, from lowest to highest.
+const (
+	PriorityLow      = "low"      // PriorityLow is the class of schedules and automation rules
+	PriorityNormal   = "normal"   // PriorityNormal is the class of commands received on MQTT
+	PriorityHigh     = "high"     // PriorityHigh is the class of manual commands through the REST api
+	PriorityCritical = "critical" // PriorityCritical is the class of safety commands, which cut the backoff short
+)
+
+// Priorities lists the priority classes, from lowest to highest.
+var Priorities = []string{PriorityLow, PriorityNormal, PriorityHigh, PriorityCritical}
+
+// StepConfig is a single step of a command: set an output, then wait.
+type StepConfig struct {
+	Output   string
+	Level    bool          // True drives the output active, false inactive
+	Duration time.Duration // Time to wait after setting the output
+}
+
+// CommandConfig describes a command, as configured in commands.<name>.
+type CommandConfig struct {
+	Label    string
+	Mode     string        // Mode of the unit after the command, empty if the command does not change it
+	Boost    time.Duration // Duration of the boost, for commands that set the boost mode
+	Backoff  time.Duration // Time to wait after the command, before executing the next one
+	Hold     string        // Output held active after the command, with all other held outputs inactive
+	Analog   bool          // The command sets the analog output to Percent
+	Percent  float64
+	Priority string // Lowest priority class of the command, raising the class of its source
+	Steps    []StepConfig
+}
+
+// Raw form of a step in the configuration file.
+type rawStep struct {
+	Output   string `mapstructure:"output"`
+	Level    string `mapstructure:"level"`
+	Duration int    `mapstructure:"duration"`
+}
+
+// Modes a command can put the unit in.
+var commandModes = []string{"speed1", "speed2", "speed3", "away", "auto", "boost"}
+
+// Parses the level of a step.
+func parseLevel(level string) (bool, error) {
+	switch strings.ToLower(level) {
+	case "on", "high", "true", "1":
+		return true, nil
+	case "off", "low", "false", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid level %s", level)
+	}
+}
+
+// Reads the command with the given name from the configuration file. Values that are not configured are
+// taken from the given base command.
+func readCommand(name string, command CommandConfig) (CommandConfig, error) {
+	key := "commands." + name
+	if viperInst.IsSet(key + ".label") {
+		command.Label = viperInst.GetString(key + ".label")
+	}
+	if command.Label == "" {
+		command.Label = name
+	}
+	if viperInst.IsSet(key + ".mode") {
+		command.Mode = viperInst.GetString(key + ".mode")
+	}
+	if viperInst.IsSet(key + ".boost") {
+		command.Boost = time.Duration(viperInst.GetInt(key+".boost")) * time.Minute
+	}
+	if viperInst.IsSet(key + ".backoff") {
+		command.Backoff = time.Duration(viperInst.GetInt(key+".backoff")) * time.Millisecond
+	}
+	if viperInst.IsSet(key + ".percent") {
+		command.Analog = true
+		command.Percent = viperInst.GetFloat64(key + ".percent")
+	}
+	if viperInst.IsSet(key + ".hold") {
+		command.Hold = viperInst.GetString(key + ".hold")
+	}
+	if viperInst.IsSet(key + ".priority") {
+		command.Priority = viperInst.GetString(key + ".priority")
+	}
+	if !viperInst.IsSet(key + ".steps") {
+		return command, nil
+	}
+
+	var steps []rawStep
+	if err := viperInst.UnmarshalKey(key+".steps", &steps); err != nil {
+		return command, fmt.Errorf("config: %s.steps: %v", key, err)
+	}
+	command.Steps = nil
+	for i, raw := range steps {
+		level, err := parseLevel(raw.Level)
+		if err != nil {
+			return command, fmt.Errorf("config: %s.steps[%d]: %v", key, i, err)
+		}
+		command.Steps = append(command.Steps, StepConfig{
+			Output:   raw.Output,
+			Level:    level,
+			Duration: time.Duration(raw.Duration) * time.Millisecond,
+		})
+	}
+	return command, nil
+}
+
+// GetQueueAging returns the time after which a queued command is raised one priority class, so lower
+// priorities are not starved. Commands are not raised above the high class. Zero disables aging.
+func GetQueueAging() time.Duration {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("queue.aging") {
+		return time.Minute
+	}
+	return time.Duration(viperInst.GetInt("queue.aging")) * time.Second
+}
+
+// Returns whether all outputs used by a command are configured.
+func wired(command CommandConfig, outputs map[string]OutputConfig) bool {
+	if _, ok := outputs[command.Hold]; command.Hold != "" && !ok {
+		return false
+	}
+	for _, step := range command.Steps {
+		if _, ok := outputs[step.Output]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// LevelCommands converts commands to level control: commands that select a mode other than boost with
+// presses on a single output hold that output instead. Other commands are left as they are.
+func LevelCommands(commands map[string]CommandConfig) map[string]CommandConfig {
+	converted := make(map[string]CommandConfig, len(commands))
+	for name, command := range commands {
+		if command.Mode != "" && command.Mode != "boost" && command.Hold == "" && len(command.Steps) > 0 {
+			output := command.Steps[0].Output
+			single := true
+			for _, step := range command.Steps {
+				single = single && step.Output == output
+			}
+			if single {
+				command.Hold = output
+				command.Steps = nil
+			}
+		}
+		converted[name] = command
+	}
+	return converted
+}
+
+// GetCommands returns all commands by name: the commands of the profile whose outputs are configured,
+// with the commands in the configuration file overriding or adding to them.
+func GetCommands() (map[string]CommandConfig, error) {
+	once.Do(loadConfig)
+	profile, err := GetProfile(GetProfileName())
+	if err != nil {
+		return nil, err
+	}
+	if GetGPIOControl() == ControlLevel {
+		profile = LevelCommands(profile)
+	}
+	outputs := GetGPIOOutputs()
+	configured := viperInst.GetStringMap("commands")
+
+	commands := make(map[string]CommandConfig)
+	for name, command := range profile {
+		if _, ok := configured[name]; ok || wired(command, outputs) {
+			commands[name] = command
+		}
+	}
+	if analog, ok := GetAnalog(); ok {
+		for mode, percent := range analog.Speeds {
+			command := profile[mode]
+			command.Mode = mode
+			command.Analog = true
+			command.Percent = percent
+			if command.Label == "" {
+				command.Label = fmt.Sprintf("%s (%g%%)", mode, percent)
+			}
+			command.Hold = ""
+			command.Steps = nil
+			commands[mode] = command
+		}
+	}
+	for name := range configured {
+		command, err := readCommand(name, commands[name])
+		if err != nil {
+			return nil, err
+		}
+		commands[name] = command
+	}
+	backoff := time.Duration(GetGPIOBackoff()) * time.Millisecond
+	for name, command := range commands {
+		if command.Backoff == 0 && !viperInst.IsSet("commands."+name+".backoff") {
+			command.Backoff = backoff
+			commands[name] = command
+		}
+	}
+	return commands, nil
+}
+
+// Verifies the commands against the configured outputs.
+func verifyCommands() error {
+	commands, err := GetCommands()
+	if err != nil {
+		return err
+	}
+	outputs := GetGPIOOutputs()
+	for name, command := range commands {
+		if len(command.Steps) == 0 && command.Hold == "" && !command.Analog {
+			return fmt.Errorf("config: commands.%s must have at least one step, a held output or a percentage", name)
+		}
+		if _, ok := GetAnalog(); command.Analog && !ok {
+			return fmt.Errorf("config: commands.%s.percent requires analog.output", name)
+		}
+		if _, ok := outputs[command.Hold]; command.Hold != "" && !ok {
+			return fmt.Errorf("config: commands.%s.hold refers to unknown output %s", name, command.Hold)
+		}
+		if command.Mode != "" && !contains(commandModes, command.Mode) {
+			return fmt.Errorf("config: commands.%s.mode must be one of %s", name, strings.Join(commandModes, ", "))
+		}
+		if command.Mode == "boost" && command.Boost <= 0 {
+			return fmt.Errorf("config: commands.%s.boost must be set for boost commands", name)
+		}
+		if command.Backoff < 0 {
+			return fmt.Errorf("config: commands.%s.backoff must not be negative", name)
+		}
+		if command.Priority != "" && !contains(Priorities, command.Priority) {
+			return fmt.Errorf("config: commands.%s.priority must be one of %s", name, strings.Join(Priorities, ", "))
+		}
+		for i, step := range command.Steps {
+			if _, ok := outputs[step.Output]; !ok {
+				return fmt.Errorf("config: commands.%s.steps[%d] refers to unknown output %s", name, i, step.Output)
+			}
+			if step.Duration < 0 {
+				return fmt.Errorf("config: commands.%s.steps[%d].duration must not be negative", name, i)
+			}
+		}
+		if output, pulse := longestPulse(command.Steps); pulse > GetGPIOMaxPulse() {
+			return fmt.Errorf("config: commands.%s holds output %s active for %v, longer than gpio.max_pulse", name, output, pulse)
+		}
+	}
+	return nil
+}
+
+// Returns the output that the steps of a command keep active the longest, and for how long.
+func longestPulse(steps []StepConfig) (string, time.Duration) {
+	active := make(map[string]time.Duration)
+	longest, pulse := "", time.Duration(0)
+	for _, step := range steps {
+		if step.Level {
+			if _, ok := active[step.Output]; !ok {
+				active[step.Output] = 0
+			}
+		} else {
+			delete(active, step.Output)
+		}
+		for output := range active {
+			active[output] += step.Duration
+			if active[output] > pulse {
+				longest, pulse = output, active[output]
+			}
+		}
+	}
+	return longest, pulse
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -11,24 +13,126 @@ import (
 var (
 	// All known configuration properties, and weither they are mandatory or not
 	knownKeys = map[string]bool{
-		"mode":                  true,
-		"bind.port":             true,
-		"bind.host":             true,
-		"gpio.backoff":          true,
-		"gpio.pins.speed_1":     true,
-		"gpio.pins.speed_2":     true,
-		"gpio.pins.speed_3":     true,
-		"gpio.pins.away":        true,
-		"gpio.pins.auto":        true,
-		"gpio.pins.timer":       true,
-		"api_keys":              true,
-		"mqtt.enabled":          true,
-		"mqtt.url":              false,
-		"mqtt.username":         false,
-		"mqtt.password":         false,
-		"mqtt.client_id":        false,
-		"mqtt.discovery_prefix": false,
-		"mqtt.id":               false,
+		"mode":                       true,
+		"bind.port":                  true,
+		"bind.host":                  true,
+		"gpio.backoff":               true,
+		"gpio.control":               false,
+		"gpio.driver":                false,
+		"gpio.chip":                  false,
+		"gpio.break_before_make":     false,
+		"gpio.max_pulse":             false,
+		"gpio.outputs.*.type":        false,
+		"gpio.outputs.*.line":        false,
+		"gpio.feedback.timeout":      false,
+		"gpio.feedback.retries":      false,
+		"gpio.inputs.*.pin":          false,
+		"gpio.inputs.*.line":         false,
+		"gpio.inputs.*.active_low":   false,
+		"gpio.inputs.*.pull":         false,
+		"gpio.inputs.*.debounce":     false,
+		"gpio.inputs.*.command":      false,
+		"gpio.inputs.*.mode":         false,
+		"gpio.outputs.*.pin":         false,
+		"gpio.outputs.*.active_low":  false,
+		"gpio.outputs.*.pwm":         false,
+		"gpio.outputs.*.bus":         false,
+		"gpio.outputs.*.address":     false,
+		"gpio.outputs.*.chip":        false,
+		"gpio.outputs.*.channel":     false,
+		"gpio.outputs.*.calibration": false,
+		"gpio.outputs.*.port":        false,
+		"gpio.outputs.*.bit":         false,
+		"gpio.outputs.*.device":      false,
+		"gpio.outputs.*.baud":        false,
+		"gpio.outputs.*.relay":       false,
+		"gpio.outputs.*.status":      false,
+		"gpio.outputs.*.url":         false,
+		"gpio.outputs.*.username":    false,
+		"gpio.outputs.*.password":    false,
+		"gpio.outputs.*.timeout":     false,
+		"gpio.outputs.*.retries":     false,
+		"gpio.outputs.*.pulse":       false,
+		"gpio.outputs.*.register":    false,
+		"gpio.outputs.*.value":       false,
+		"gpio.outputs.*.off_value":   false,
+		"gpio.outputs.*.scale":       false,
+		"modbus.transport":           false,
+		"modbus.address":             false,
+		"modbus.device":              false,
+		"modbus.baud":                false,
+		"modbus.unit":                false,
+		"modbus.timeout":             false,
+		"modbus.poll":                false,
+		"modbus.readings.*.register": false,
+		"modbus.readings.*.input":    false,
+		"modbus.readings.*.signed":   false,
+		"modbus.readings.*.scale":    false,
+		"modbus.readings.*.kind":     false,
+		"analog.output":              false,
+		"analog.frequency":           false,
+		"analog.min":                 false,
+		"analog.max":                 false,
+		"analog.ramp":                false,
+		"analog.speeds.speed1":       false,
+		"analog.speeds.speed2":       false,
+		"analog.speeds.speed3":       false,
+		"commands.*.label":           false,
+		"commands.*.mode":            false,
+		"commands.*.boost":           false,
+		"commands.*.backoff":         false,
+		"commands.*.steps":           false,
+		"commands.*.hold":            false,
+		"commands.*.percent":         false,
+		"commands.*.priority":        false,
+		"queue.aging":                false,
+		"lockout.command":            false,
+		"lockout.input":              false,
+		"service.expiry":             false,
+		"profile":                    false,
+		"api_keys":                   true,
+		"mqtt.enabled":               true,
+		"mqtt.url":                   false,
+		"mqtt.username":              false,
+		"mqtt.password":              false,
+		"mqtt.client_id":             false,
+		"mqtt.discovery_prefix":      false,
+		"mqtt.id":                    false,
+		"data_dir":                   false,
+		"history.max_size":           false,
+		"history.max_files":          false,
+		"history.signing_key":        false,
+		"history.verify_key":         false,
+
+		"maintenance.filter.interval":       false,
+		"maintenance.filter.weights.speed1": false,
+		"maintenance.filter.weights.speed2": false,
+		"maintenance.filter.weights.speed3": false,
+		"maintenance.filter.weights.away":   false,
+		"maintenance.filter.weights.auto":   false,
+		"maintenance.filter.weights.boost":  false,
+
+		"energy.watts.speed1":       false,
+		"energy.watts.speed2":       false,
+		"energy.watts.speed3":       false,
+		"energy.watts.away":         false,
+		"energy.watts.auto":         false,
+		"energy.watts.boost":        false,
+		"energy.tariff.day":         false,
+		"energy.tariff.night":       false,
+		"energy.tariff.night_start": false,
+		"energy.tariff.night_end":   false,
+		"energy.currency":           false,
+	}
+
+	// Default weights for the filter runtime, per mode.
+	defaultFilterWeights = map[string]float64{
+		"speed1": 0.5,
+		"speed2": 1,
+		"speed3": 1.5,
+		"away":   0.3,
+		"auto":   1,
+		"boost":  1.5,
 	}
 
 	viperInst *viper.Viper
@@ -53,21 +157,68 @@ func loadConfig() {
 }
 
 // Verifies that all mandatory keys are set in the configuration file,
-// and that no unknown keys are present.
+// and that no unknown keys are present. A * in a known key matches the name of a map entry, e.g.
+// gpio.outputs.*.pin is mandatory for every entry in gpio.outputs.
 func verifyKeys() error {
+	if viperInst.IsSet("gpio.pins") {
+		return fmt.Errorf("config: gpio.pins has been replaced by gpio.outputs.<name>.pin")
+	}
 	for key, mandatory := range knownKeys {
-		if mandatory && !viperInst.IsSet(key) {
-			return fmt.Errorf("config: configuration property %s is mandatory", key)
+		if !mandatory {
+			continue
+		}
+		for _, instance := range expandKey(key) {
+			if !viperInst.IsSet(instance) {
+				return fmt.Errorf("config: configuration property %s is mandatory", instance)
+			}
 		}
 	}
 	for _, key := range viperInst.AllKeys() {
-		if _, found := knownKeys[key]; !found {
+		if !isKnownKey(key) {
 			return fmt.Errorf("config: configuration property %s is unknown", key)
 		}
 	}
 	return nil
 }
 
+// Expands the first * in a key to the names of the entries in the map it refers to.
+func expandKey(key string) []string {
+	prefix, suffix, found := strings.Cut(key, ".*")
+	if !found {
+		return []string{key}
+	}
+	keys := []string{}
+	for name := range viperInst.GetStringMap(prefix) {
+		keys = append(keys, expandKey(prefix+"."+name+suffix)...)
+	}
+	return keys
+}
+
+// Returns whether a key matches one of the known keys.
+func isKnownKey(key string) bool {
+	if _, found := knownKeys[key]; found {
+		return true
+	}
+	parts := strings.Split(key, ".")
+	for pattern := range knownKeys {
+		patternParts := strings.Split(pattern, ".")
+		if len(patternParts) != len(parts) {
+			continue
+		}
+		match := true
+		for i, part := range patternParts {
+			if part != "*" && part != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
 // Verify that the configuration is valid.
 func Verify() error {
 	once.Do(loadConfig)
@@ -86,11 +237,43 @@ func Verify() error {
 	if gpioBackoff < 0 {
 		return fmt.Errorf("config: gpio.backoff must be a positive integer")
 	}
-	for _, pin := range []string{"speed_1", "speed_2", "speed_3", "away", "auto", "timer"} {
-		pinNum := viperInst.GetInt(fmt.Sprintf("gpio.pins.%s", pin))
-		if pinNum < 2 || pinNum > 27 {
-			return fmt.Errorf("config: gpio.pins.%s must be a valid pin number", pin)
-		}
+	if control := GetGPIOControl(); control != ControlPulse && control != ControlLevel {
+		return fmt.Errorf("config: gpio.control must be %s or %s", ControlPulse, ControlLevel)
+	}
+	if GetGPIOBreakBeforeMake() < 0 {
+		return fmt.Errorf("config: gpio.break_before_make must not be negative")
+	}
+	if GetGPIOMaxPulse() <= 0 {
+		return fmt.Errorf("config: gpio.max_pulse must be positive")
+	}
+	switch GetGPIODriver() {
+	case DriverRPIO, DriverCdev, DriverMock:
+	default:
+		return fmt.Errorf("config: gpio.driver must be %s, %s or %s", DriverCdev, DriverRPIO, DriverMock)
+	}
+	if err := verifyOutputs(); err != nil {
+		return err
+	}
+	if err := verifyAnalog(); err != nil {
+		return err
+	}
+	if err := verifyCommands(); err != nil {
+		return err
+	}
+	if GetQueueAging() < 0 {
+		return fmt.Errorf("config: queue.aging must not be negative")
+	}
+	if err := verifyInputs(); err != nil {
+		return err
+	}
+	if err := verifyLockout(); err != nil {
+		return err
+	}
+	if GetServiceExpiry() < 0 {
+		return fmt.Errorf("config: service.expiry must not be negative")
+	}
+	if GetGPIOFeedbackTimeout() <= 0 || GetGPIOFeedbackRetries() < 0 {
+		return fmt.Errorf("config: gpio.feedback.timeout must be positive and gpio.feedback.retries must not be negative")
 	}
 	apiKeys := viperInst.GetStringSlice("api_keys")
 	if len(apiKeys) == 0 {
@@ -116,6 +299,33 @@ func Verify() error {
 		}
 	}
 
+	if viperInst.IsSet("maintenance.filter.interval") && viperInst.GetFloat64("maintenance.filter.interval") <= 0 {
+		return fmt.Errorf("config: maintenance.filter.interval must be a positive number")
+	}
+	for mode := range defaultFilterWeights {
+		if viperInst.GetFloat64("maintenance.filter.weights."+mode) < 0 {
+			return fmt.Errorf("config: maintenance.filter.weights.%s must not be negative", mode)
+		}
+	}
+	for mode := range defaultFilterWeights {
+		if viperInst.GetFloat64("energy.watts."+mode) < 0 {
+			return fmt.Errorf("config: energy.watts.%s must not be negative", mode)
+		}
+	}
+	if viperInst.IsSet("energy.tariff.night") {
+		for _, key := range []string{"energy.tariff.night_start", "energy.tariff.night_end"} {
+			if _, err := time.Parse("15:04", viperInst.GetString(key)); err != nil {
+				return fmt.Errorf("config: %s must be a time of day (hh:mm) when energy.tariff.night is set", key)
+			}
+		}
+	}
+	if viperInst.IsSet("history.max_size") && viperInst.GetInt("history.max_size") <= 0 {
+		return fmt.Errorf("config: history.max_size must be a positive integer")
+	}
+	if viperInst.IsSet("history.max_files") && viperInst.GetInt("history.max_files") <= 0 {
+		return fmt.Errorf("config: history.max_files must be a positive integer")
+	}
+
 	return nil
 }
 
@@ -143,40 +353,58 @@ func GetGPIOBackoff() int {
 	return viperInst.GetInt("gpio.backoff")
 }
 
-// GetGPIOSpeed1Pin returns the pin number for the first speed button.
-func GetGPIOSpeed1Pin() int {
-	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.speed_1")
-}
-
-// GetGPIOSpeed2Pin returns the pin number for the second speed button.
-func GetGPIOSpeed2Pin() int {
+// GetGPIODriver returns the driver of the gpio outputs. Without a driver, production uses rpio and
+// development the mock.
+func GetGPIODriver() string {
 	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.speed_2")
+	if viperInst.IsSet("gpio.driver") {
+		return viperInst.GetString("gpio.driver")
+	}
+	if viperInst.GetString("mode") == "production" {
+		return DriverRPIO
+	}
+	return DriverMock
 }
 
-// GetGPIOSpeed3Pin returns the pin number for the third speed button.
-func GetGPIOSpeed3Pin() int {
+// GetGPIOChip returns the path of the GPIO character device, for the cdev driver.
+func GetGPIOChip() string {
 	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.speed_3")
+	chip := "gpiochip0"
+	if viperInst.IsSet("gpio.chip") {
+		chip = viperInst.GetString("gpio.chip")
+	}
+	if !strings.HasPrefix(chip, "/") {
+		chip = "/dev/" + chip
+	}
+	return chip
 }
 
-// GetGPIOAwayPin returns the pin number for the away button.
-func GetGPIOAwayPin() int {
+// GetGPIOControl returns how the outputs control the unit: by pulses or by held levels.
+func GetGPIOControl() string {
 	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.away")
+	if !viperInst.IsSet("gpio.control") {
+		return ControlPulse
+	}
+	return viperInst.GetString("gpio.control")
 }
 
-// GetGPIOAutoPin returns the pin number for the auto button.
-func GetGPIOAutoPin() int {
+// GetGPIOBreakBeforeMake returns the time all held outputs are inactive when switching, in ms.
+func GetGPIOBreakBeforeMake() int {
 	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.auto")
+	if !viperInst.IsSet("gpio.break_before_make") {
+		return 100
+	}
+	return viperInst.GetInt("gpio.break_before_make")
 }
 
-// GetGPIOTimerPin returns the pin number for the timer button.
-func GetGPIOTimerPin() int {
+// GetGPIOMaxPulse returns the longest time an output may be active in a pulse, after which it is forced
+// inactive.
+func GetGPIOMaxPulse() time.Duration {
 	once.Do(loadConfig)
-	return viperInst.GetInt("gpio.pins.timer")
+	if !viperInst.IsSet("gpio.max_pulse") {
+		return 5 * time.Second
+	}
+	return time.Duration(viperInst.GetInt("gpio.max_pulse")) * time.Millisecond
 }
 
 // GetAPIKeys returns the list of API keys.
@@ -244,3 +472,119 @@ func GetMQTTID() string {
 	}
 	return viperInst.GetString("mqtt.id")
 }
+
+// GetDataDir returns the directory in which the service keeps its persistent data, unless overridden
+// by $VENTILATIONSERVICE_DATA_DIR.
+func GetDataDir() string {
+	once.Do(loadConfig)
+	if dir := os.Getenv("VENTILATIONSERVICE_DATA_DIR"); dir != "" {
+		return dir
+	}
+	if !viperInst.IsSet("data_dir") {
+		return "data"
+	}
+	return viperInst.GetString("data_dir")
+}
+
+// GetHistoryMaxSize returns the size (in KiB) at which the history log is rotated.
+func GetHistoryMaxSize() int {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("history.max_size") {
+		return 1024
+	}
+	return viperInst.GetInt("history.max_size")
+}
+
+// GetHistoryMaxFiles returns the number of rotated history logs to keep.
+func GetHistoryMaxFiles() int {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("history.max_files") {
+		return 5
+	}
+	return viperInst.GetInt("history.max_files")
+}
+
+// GetHistorySigningKey returns the path of the Ed25519 key used to sign the daily history tip.
+func GetHistorySigningKey() string {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("history.signing_key") {
+		return ""
+	}
+	return viperInst.GetString("history.signing_key")
+}
+
+// GetHistoryVerifyKey returns the path of the Ed25519 public key used to verify history signatures.
+func GetHistoryVerifyKey() string {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("history.verify_key") {
+		return ""
+	}
+	return viperInst.GetString("history.verify_key")
+}
+
+// GetMaintenanceFilterInterval returns the number of weighted running hours after which the filters are due.
+func GetMaintenanceFilterInterval() float64 {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("maintenance.filter.interval") {
+		return 1500
+	}
+	return viperInst.GetFloat64("maintenance.filter.interval")
+}
+
+// GetMaintenanceFilterWeights returns the weight of a running hour, per mode.
+func GetMaintenanceFilterWeights() map[string]float64 {
+	once.Do(loadConfig)
+	weights := make(map[string]float64, len(defaultFilterWeights))
+	for mode, weight := range defaultFilterWeights {
+		key := "maintenance.filter.weights." + mode
+		if viperInst.IsSet(key) {
+			weight = viperInst.GetFloat64(key)
+		}
+		weights[mode] = weight
+	}
+	return weights
+}
+
+// GetEnergyWatts returns the nominal power consumption (in W) per mode.
+func GetEnergyWatts() map[string]float64 {
+	once.Do(loadConfig)
+	watts := make(map[string]float64, len(defaultFilterWeights))
+	for mode := range defaultFilterWeights {
+		watts[mode] = viperInst.GetFloat64("energy.watts." + mode)
+	}
+	return watts
+}
+
+// GetEnergyDayTariff returns the price of a kWh outside the night tariff window.
+func GetEnergyDayTariff() float64 {
+	once.Do(loadConfig)
+	return viperInst.GetFloat64("energy.tariff.day")
+}
+
+// GetEnergyNightTariff returns the price of a kWh in the night tariff window, and whether one is configured.
+func GetEnergyNightTariff() (float64, bool) {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("energy.tariff.night") {
+		return 0, false
+	}
+	return viperInst.GetFloat64("energy.tariff.night"), true
+}
+
+// GetEnergyNightWindow returns the start and end of the night tariff window, in minutes after midnight.
+func GetEnergyNightWindow() (int, int) {
+	once.Do(loadConfig)
+	minutes := func(key string) int {
+		t, _ := time.Parse("15:04", viperInst.GetString(key))
+		return t.Hour()*60 + t.Minute()
+	}
+	return minutes("energy.tariff.night_start"), minutes("energy.tariff.night_end")
+}
+
+// GetEnergyCurrency returns the currency the tariffs are expressed in.
+func GetEnergyCurrency() string {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("energy.currency") {
+		return "EUR"
+	}
+	return viperInst.GetString("energy.currency")
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -33,27 +34,247 @@ func TestBindPortAndHost(t *testing.T) {
 	}
 }
 
+func TestDataDir(t *testing.T) {
+	if GetDataDir() != "data" {
+		t.Fatalf("Expected data dir data, got %s", GetDataDir())
+	}
+	t.Setenv("VENTILATIONSERVICE_DATA_DIR", "/var/lib/ventilation")
+	if GetDataDir() != "/var/lib/ventilation" {
+		t.Fatalf("Expected the data dir from the environment, got %s", GetDataDir())
+	}
+}
+
 func TestGPIO(t *testing.T) {
 	if GetGPIOBackoff() != 3000 {
 		t.Fatalf("Expected GPIO backoff to be 3000, got %d", GetGPIOBackoff())
 	}
-	if GetGPIOSpeed1Pin() != 11 {
-		t.Fatalf("Expected GPIO speed 1 pin to be 11, got %d", GetGPIOSpeed1Pin())
+	outputs := GetGPIOOutputs()
+	for name, pin := range map[string]int{
+		"speed_1": 11,
+		"speed_2": 12,
+		"speed_3": 13,
+		"away":    20,
+		"auto":    21,
+		"timer":   27,
+	} {
+		if outputs[name].Pin != pin {
+			t.Fatalf("Expected GPIO %s pin to be %d, got %d", name, pin, outputs[name].Pin)
+		}
+		if outputs[name].ActiveLow {
+			t.Fatalf("Expected GPIO %s to be active high", name)
+		}
+		if outputs[name].Type != OutputGPIO || outputs[name].Analog() {
+			t.Fatalf("Expected GPIO %s to be a digital gpio output", name)
+		}
+	}
+}
+
+func TestKeyPatterns(t *testing.T) {
+	if !isKnownKey("gpio.outputs.bypass.pin") {
+		t.Fatalf("Expected gpio.outputs.bypass.pin to be a known key")
+	}
+	if isKnownKey("gpio.outputs.bypass.colour") || isKnownKey("gpio.outputs.pin") {
+		t.Fatalf("Expected keys not matching a pattern to be unknown")
+	}
+	if keys := expandKey("gpio.outputs.*.pin"); len(keys) != 6 {
+		t.Fatalf("Expected 6 output pin keys, got %v", keys)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	commands, err := GetCommands()
+	if err != nil {
+		t.Fatalf("Error reading commands: %v", err)
+	}
+	timer := commands["timer60"]
+	if timer.Mode != "boost" || timer.Boost != 60*time.Minute || timer.Backoff != 3*time.Second {
+		t.Fatalf("Unexpected timer60 command: %+v", timer)
+	}
+	if len(timer.Steps) != 6 || timer.Steps[5].Duration != 0 || timer.Steps[5].Level {
+		t.Fatalf("Expected 3 presses on the timer output, got %+v", timer.Steps)
+	}
+	for level, expected := range map[string]bool{"on": true, "HIGH": true, "off": false, "low": false} {
+		if value, err := parseLevel(level); err != nil || value != expected {
+			t.Fatalf("Expected level %s to be %v, got %v (%v)", level, expected, value, err)
+		}
+	}
+	if _, err := parseLevel("half"); err == nil {
+		t.Fatalf("Expected an error for an invalid level")
+	}
+}
+
+func TestLevelCommands(t *testing.T) {
+	if GetGPIOControl() != ControlPulse || GetGPIOBreakBeforeMake() != 100 {
+		t.Fatalf("Expected pulse control with 100ms break-before-make")
+	}
+	profile, _ := GetProfile(DefaultProfile)
+	commands := LevelCommands(profile)
+	if speed := commands["speed2"]; speed.Hold != "speed_2" || len(speed.Steps) != 0 {
+		t.Fatalf("Expected speed2 to hold speed_2, got %+v", speed)
+	}
+	if timer := commands["timer30"]; timer.Hold != "" || len(timer.Steps) != 4 {
+		t.Fatalf("Expected timer30 to keep its pulses, got %+v", timer)
+	}
+}
+
+func TestAnalog(t *testing.T) {
+	if analog, ok := GetAnalog(); ok || analog.Frequency != 1000 {
+		t.Fatalf("Expected no analog output to be configured, and the default frequency")
+	}
+	outputs := map[string]OutputConfig{"fan": {Type: OutputGPIO, Pin: 18, PWM: true}}
+	if err := verifyPWM(outputs, true); err != nil {
+		t.Fatalf("Expected a valid PWM output, got %v", err)
+	}
+	if err := verifyPWM(outputs, false); err == nil {
+		t.Fatalf("Expected an error for a PWM output without analog.output")
 	}
-	if GetGPIOSpeed2Pin() != 12 {
-		t.Fatalf("Expected GPIO speed 2 pin to be 12, got %d", GetGPIOSpeed2Pin())
+	outputs["fan"] = OutputConfig{Type: OutputGPIO, Pin: 17, PWM: true}
+	if err := verifyPWM(outputs, true); err == nil {
+		t.Fatalf("Expected an error for a pin without hardware PWM")
 	}
-	if GetGPIOSpeed3Pin() != 13 {
-		t.Fatalf("Expected GPIO speed 3 pin to be 13, got %d", GetGPIOSpeed3Pin())
+	analog := AnalogConfig{Min: 20, Max: 90}
+	if analog.Clamp(5) != 20 || analog.Clamp(95) != 90 || analog.Clamp(42) != 42 {
+		t.Fatalf("Expected percentages to be clamped to [20, 90]")
 	}
-	if GetGPIOAwayPin() != 20 {
-		t.Fatalf("Expected GPIO away pin to be 20, got %d", GetGPIOAwayPin())
+}
+
+func TestGPIODriver(t *testing.T) {
+	if GetGPIODriver() != DriverMock {
+		t.Fatalf("Expected the mock driver in development, got %s", GetGPIODriver())
+	}
+	if GetGPIOChip() != "/dev/gpiochip0" {
+		t.Fatalf("Expected chip /dev/gpiochip0, got %s", GetGPIOChip())
+	}
+	output := OutputConfig{Type: OutputGPIO, Line: "GPIO17"}
+	if err := verifyGPIO("gpio.outputs.speed_1", output, DriverCdev); err != nil {
+		t.Fatalf("Expected a valid line, got %v", err)
+	}
+	if err := verifyGPIO("gpio.outputs.speed_1", output, DriverRPIO); err == nil {
+		t.Fatalf("Expected an error for a line name with the rpio driver")
+	}
+	output = OutputConfig{Type: OutputGPIO, Pin: 18, PWM: true}
+	if err := verifyGPIO("gpio.outputs.fan", output, DriverCdev); err == nil {
+		t.Fatalf("Expected an error for PWM with the cdev driver")
+	}
+}
+
+func TestVerifyDAC(t *testing.T) {
+	output := OutputConfig{Type: OutputDAC, Bus: 1, Address: 0x60, Chip: ChipMCP4725}
+	if err := verifyDAC("fan", output); err != nil {
+		t.Fatalf("Expected a valid DAC output, got %v", err)
+	}
+	output.Channel = 1
+	if err := verifyDAC("fan", output); err == nil {
+		t.Fatalf("Expected an error for channel 1 on %s", ChipMCP4725)
+	}
+	output = OutputConfig{Type: OutputDAC, Address: 0x58, Chip: ChipGP8403, Calibration: []CalibrationPoint{{Percent: 50, Code: 5000}}}
+	if err := verifyDAC("fan", output); err == nil {
+		t.Fatalf("Expected an error for a code out of range")
+	}
+}
+
+func TestVerifyMCP23017(t *testing.T) {
+	output := OutputConfig{Type: OutputMCP23017, Bus: 1, Address: 0x20, Port: "b", Bit: 7}
+	if err := verifyMCP23017("bypass", output); err != nil {
+		t.Fatalf("Expected a valid expander output, got %v", err)
+	}
+	output.Bit = 8
+	if err := verifyMCP23017("bypass", output); err == nil {
+		t.Fatalf("Expected an error for bit 8")
+	}
+	output = OutputConfig{Type: OutputMCP23017, Address: 0x40, Port: "a"}
+	if err := verifyMCP23017("bypass", output); err == nil {
+		t.Fatalf("Expected an error for an address out of range")
+	}
+}
+
+func TestVerifyUSBRelay(t *testing.T) {
+	output := OutputConfig{Type: OutputUSBRelay, Device: "/dev/ttyUSB0", Baud: 9600, Relay: 4}
+	if err := verifyUSBRelay("speed_1", output); err != nil {
+		t.Fatalf("Expected a valid relay output, got %v", err)
+	}
+	output.Baud = 1200
+	if err := verifyUSBRelay("speed_1", output); err == nil {
+		t.Fatalf("Expected an error for an unsupported baud rate")
+	}
+}
+
+func TestVerifyNetworkRelay(t *testing.T) {
+	output := OutputConfig{Type: OutputShelly, URL: "http://192.168.1.50", Timeout: time.Second, Pulse: 100 * time.Millisecond}
+	if err := verifyNetworkRelay("timer", output); err != nil {
+		t.Fatalf("Expected a valid Shelly output, got %v", err)
+	}
+	output.Type = OutputTasmota
+	if err := verifyNetworkRelay("timer", output); err == nil {
+		t.Fatalf("Expected an error for a pulse on a Tasmota output")
+	}
+	output = OutputConfig{Type: OutputTasmota, URL: "192.168.1.50", Timeout: time.Second}
+	if err := verifyNetworkRelay("timer", output); err == nil {
+		t.Fatalf("Expected an error for a URL without scheme")
+	}
+}
+
+func TestVerifyModbus(t *testing.T) {
+	if _, ok := GetModbus(); ok {
+		t.Fatalf("Expected no Modbus connection to be configured")
+	}
+	modbus := ModbusConfig{
+		Transport: ModbusTCP,
+		Address:   "192.168.1.60:502",
+		Unit:      1,
+		Timeout:   time.Second,
+		Poll:      30 * time.Second,
+		Readings:  map[string]ReadingConfig{"fan_rpm": {Register: 200, Scale: 1, Kind: "rpm"}},
+	}
+	if err := verifyModbusConnection(modbus); err != nil {
+		t.Fatalf("Expected a valid Modbus connection, got %v", err)
+	}
+	if modbus.Readings["fan_rpm"].Unit() != "rpm" || modbus.Readings["fan_rpm"].DeviceClass() != "" {
+		t.Fatalf("Unexpected unit or device class of an rpm reading")
+	}
+	modbus.Transport = ModbusRTU
+	if err := verifyModbusConnection(modbus); err == nil {
+		t.Fatalf("Expected an error for rtu without device")
+	}
+	modbus.Transport = ModbusTCP
+	modbus.Readings["fan_rpm"] = ReadingConfig{Register: 200, Kind: "pressure"}
+	if err := verifyModbusConnection(modbus); err == nil {
+		t.Fatalf("Expected an error for an unknown kind of reading")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	if GetProfileName() != DefaultProfile {
+		t.Fatalf("Expected profile %s, got %s", DefaultProfile, GetProfileName())
+	}
+	for _, name := range Profiles() {
+		profile, err := GetProfile(name)
+		if err != nil || len(profile) == 0 {
+			t.Fatalf("Expected profile %s to have commands, got %v", name, err)
+		}
+	}
+	if _, err := GetProfile("duco"); err == nil {
+		t.Fatalf("Expected an error for an unknown profile")
+	}
+}
+
+func TestLongestPulse(t *testing.T) {
+	for _, name := range Profiles() {
+		profile, _ := GetProfile(name)
+		for command, config := range profile {
+			if output, pulse := longestPulse(config.Steps); pulse > GetGPIOMaxPulse() {
+				t.Fatalf("Expected profile %s to respect gpio.max_pulse, %s holds %s for %v", name, command, output, pulse)
+			}
+		}
 	}
-	if GetGPIOAutoPin() != 21 {
-		t.Fatalf("Expected GPIO auto pin to be 21, got %d", GetGPIOAutoPin())
+	steps := []StepConfig{
+		{Output: "timer", Level: true, Duration: 2 * time.Second},
+		{Output: "away", Level: true, Duration: 3 * time.Second},
+		{Output: "away", Level: false},
+		{Output: "timer", Level: false},
 	}
-	if GetGPIOTimerPin() != 27 {
-		t.Fatalf("Expected GPIO timer pin to be 27, got %d", GetGPIOTimerPin())
+	if output, pulse := longestPulse(steps); output != "timer" || pulse != 5*time.Second {
+		t.Fatalf("Expected timer to be active for 5s, got %s for %v", output, pulse)
 	}
 }
 
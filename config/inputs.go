@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pull resistors of inputs.
+const (
+	PullNone = "none" // PullNone leaves the input floating, for inputs with an external resistor
+	PullUp   = "up"   // PullUp pulls the input up, for contacts to ground
+	PullDown = "down" // PullDown pulls the input down, for contacts to 3.3V
+)
+
+// InputConfig describes a single input, as configured in gpio.inputs.<name>. An input either senses
+// presses of a command on the wall remote, or a status LED that is lit in a mode.
+type InputConfig struct {
+	Pin       int           // GPIO (BCM) pin number, or line offset with the cdev driver
+	Line      string        // Name of the line, with the cdev driver
+	ActiveLow bool          // The input is active when the pin is low
+	Pull      string        // One of the Pull* constants
+	Debounce  time.Duration // Time the input must be stable before a change is reported
+	Command   string        // Command the unit executes when the input becomes active
+	Mode      string        // Mode the unit is in while the input is active
+}
+
+// GetGPIOInputs returns the inputs, by their logical name.
+func GetGPIOInputs() map[string]InputConfig {
+	once.Do(loadConfig)
+	inputs := make(map[string]InputConfig)
+	for name := range viperInst.GetStringMap("gpio.inputs") {
+		key := "gpio.inputs." + name
+		input := InputConfig{
+			Pin:       viperInst.GetInt(key + ".pin"),
+			Line:      viperInst.GetString(key + ".line"),
+			ActiveLow: viperInst.GetBool(key + ".active_low"),
+			Pull:      PullNone,
+			Debounce:  50 * time.Millisecond,
+			Command:   viperInst.GetString(key + ".command"),
+			Mode:      viperInst.GetString(key + ".mode"),
+		}
+		if viperInst.IsSet(key + ".pull") {
+			input.Pull = viperInst.GetString(key + ".pull")
+		}
+		if viperInst.IsSet(key + ".debounce") {
+			input.Debounce = time.Duration(viperInst.GetInt(key+".debounce")) * time.Millisecond
+		}
+		inputs[name] = input
+	}
+	return inputs
+}
+
+// Verifies the inputs. Inputs are verified after the commands, which they can refer to.
+func verifyInputs() error {
+	inputs := GetGPIOInputs()
+	if len(inputs) == 0 {
+		return nil
+	}
+	driver := GetGPIODriver()
+	commands, err := GetCommands()
+	if err != nil {
+		return err
+	}
+	pins := make(map[string]string)
+	for name, output := range GetGPIOOutputs() {
+		if output.Type == OutputGPIO {
+			pins[fmt.Sprintf("%d/%s", output.Pin, output.Line)] = "gpio.outputs." + name
+		}
+	}
+	for _, name := range sortedNames(inputs) {
+		input := inputs[name]
+		key := "gpio.inputs." + name
+		if err := verifyGPIO(key, OutputConfig{Type: OutputGPIO, Pin: input.Pin, Line: input.Line}, driver); err != nil {
+			return err
+		}
+		pin := fmt.Sprintf("%d/%s", input.Pin, input.Line)
+		if other, ok := pins[pin]; ok {
+			return fmt.Errorf("config: %s uses the same pin as %s", key, other)
+		}
+		pins[pin] = key
+		if input.Pull != PullNone && input.Pull != PullUp && input.Pull != PullDown {
+			return fmt.Errorf("config: %s.pull must be %s, %s or %s", key, PullNone, PullUp, PullDown)
+		}
+		if input.Debounce < 0 {
+			return fmt.Errorf("config: %s.debounce must not be negative", key)
+		}
+		if input.Command != "" && input.Mode != "" {
+			return fmt.Errorf("config: %s can have either a command or a mode", key)
+		}
+		if _, ok := commands[input.Command]; input.Command != "" && !ok {
+			return fmt.Errorf("config: %s.command refers to unknown command %s", key, input.Command)
+		}
+		if input.Mode != "" && !contains(commandModes, input.Mode) {
+			return fmt.Errorf("config: %s.mode must be one of %s", key, strings.Join(commandModes, ", "))
+		}
+	}
+	return nil
+}
+
+// GetGPIOFeedbackTimeout returns the time within which a status LED must confirm the mode of a command.
+func GetGPIOFeedbackTimeout() time.Duration {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("gpio.feedback.timeout") {
+		return 2 * time.Second
+	}
+	return time.Duration(viperInst.GetInt("gpio.feedback.timeout")) * time.Millisecond
+}
+
+// GetGPIOFeedbackRetries returns how many times an unconfirmed command is repeated before it fails.
+func GetGPIOFeedbackRetries() int {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("gpio.feedback.retries") {
+		return 2
+	}
+	return viperInst.GetInt("gpio.feedback.retries")
+}
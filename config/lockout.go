@@ -0,0 +1,43 @@
+package config
+
+import "fmt"
+
+// LockoutConfig describes the safety lockout, as configured in lockout. While locked out, e.g. because
+// the smoke detector relay tripped, the forced command is sent and all other commands are rejected.
+type LockoutConfig struct {
+	Command string // Command forced while locked out, if any
+	Input   string // Input in gpio.inputs that trips the lockout when it becomes active, if any
+}
+
+// GetLockout returns the configuration of the safety lockout.
+func GetLockout() LockoutConfig {
+	once.Do(loadConfig)
+	return LockoutConfig{
+		Command: viperInst.GetString("lockout.command"),
+		Input:   viperInst.GetString("lockout.input"),
+	}
+}
+
+// Verifies the safety lockout. The lockout is verified after the inputs, which it can refer to.
+func verifyLockout() error {
+	lockout := GetLockout()
+	if lockout.Command != "" {
+		commands, err := GetCommands()
+		if err != nil {
+			return err
+		}
+		if _, ok := commands[lockout.Command]; !ok {
+			return fmt.Errorf("config: lockout.command refers to unknown command %s", lockout.Command)
+		}
+	}
+	if lockout.Input != "" {
+		input, ok := GetGPIOInputs()[lockout.Input]
+		if !ok {
+			return fmt.Errorf("config: lockout.input refers to unknown input %s", lockout.Input)
+		}
+		if input.Command != "" || input.Mode != "" {
+			return fmt.Errorf("config: lockout.input %s can have neither a command nor a mode", lockout.Input)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Modbus transports.
+const (
+	ModbusTCP = "tcp" // ModbusTCP is Modbus over TCP
+	ModbusRTU = "rtu" // ModbusRTU is Modbus RTU over a serial line
+)
+
+// Kinds of Modbus readings, with their unit and Home Assistant device class.
+var readingKinds = map[string][2]string{
+	"rpm":         {"rpm", ""},
+	"airflow":     {"m³/h", "volume_flow_rate"},
+	"temperature": {"°C", "temperature"},
+	"humidity":    {"%", "humidity"},
+	"percent":     {"%", ""},
+}
+
+// ReadingConfig describes a value read from the unit, as configured in modbus.readings.<name>.
+type ReadingConfig struct {
+	Register int     // Address of the register
+	Input    bool    // Read from the input registers instead of the holding registers
+	Signed   bool    // The register holds a two's complement value
+	Scale    float64 // Factor applied to the register value
+	Kind     string  // rpm, airflow, temperature, humidity or percent
+}
+
+// Unit returns the unit of measurement of the reading.
+func (r ReadingConfig) Unit() string {
+	return readingKinds[r.Kind][0]
+}
+
+// DeviceClass returns the Home Assistant device class of the reading, if any.
+func (r ReadingConfig) DeviceClass() string {
+	return readingKinds[r.Kind][1]
+}
+
+// ModbusConfig describes the connection to a unit with a Modbus interface, as configured in modbus.
+type ModbusConfig struct {
+	Transport string        // tcp or rtu
+	Address   string        // host:port, for tcp
+	Device    string        // Serial device, for rtu
+	Baud      int           // Baud rate, for rtu
+	Unit      int           // Unit (slave) id
+	Timeout   time.Duration // Timeout of a request
+	Poll      time.Duration // Interval at which the readings are read
+	Readings  map[string]ReadingConfig
+}
+
+// GetModbus returns the Modbus connection, and whether it is configured.
+func GetModbus() (ModbusConfig, bool) {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("modbus.transport") {
+		return ModbusConfig{}, false
+	}
+	modbus := ModbusConfig{
+		Transport: viperInst.GetString("modbus.transport"),
+		Address:   viperInst.GetString("modbus.address"),
+		Device:    viperInst.GetString("modbus.device"),
+		Baud:      19200,
+		Unit:      1,
+		Timeout:   time.Second,
+		Poll:      30 * time.Second,
+		Readings:  make(map[string]ReadingConfig),
+	}
+	if viperInst.IsSet("modbus.baud") {
+		modbus.Baud = viperInst.GetInt("modbus.baud")
+	}
+	if viperInst.IsSet("modbus.unit") {
+		modbus.Unit = viperInst.GetInt("modbus.unit")
+	}
+	if viperInst.IsSet("modbus.timeout") {
+		modbus.Timeout = time.Duration(viperInst.GetInt("modbus.timeout")) * time.Millisecond
+	}
+	if viperInst.IsSet("modbus.poll") {
+		modbus.Poll = time.Duration(viperInst.GetInt("modbus.poll")) * time.Second
+	}
+	for name := range viperInst.GetStringMap("modbus.readings") {
+		key := "modbus.readings." + name
+		reading := ReadingConfig{
+			Register: viperInst.GetInt(key + ".register"),
+			Input:    viperInst.GetBool(key + ".input"),
+			Signed:   viperInst.GetBool(key + ".signed"),
+			Scale:    1,
+			Kind:     viperInst.GetString(key + ".kind"),
+		}
+		if viperInst.IsSet(key + ".scale") {
+			reading.Scale = viperInst.GetFloat64(key + ".scale")
+		}
+		modbus.Readings[name] = reading
+	}
+	return modbus, true
+}
+
+// Verifies the Modbus connection, and the outputs and readings that use it.
+func verifyModbus() error {
+	modbus, ok := GetModbus()
+	for name, output := range GetGPIOOutputs() {
+		if output.Type != OutputModbus {
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("config: gpio.outputs.%s requires modbus to be configured", name)
+		}
+		if output.Register < 0 || output.Register > 0xffff {
+			return fmt.Errorf("config: gpio.outputs.%s.register must be a register address", name)
+		}
+		if output.Value < 0 || output.Value > 0xffff || (output.HasOffValue && (output.OffValue < 0 || output.OffValue > 0xffff)) {
+			return fmt.Errorf("config: gpio.outputs.%s values must be 0-65535", name)
+		}
+		if output.Scale < 0 || output.Scale*100 > 0xffff {
+			return fmt.Errorf("config: gpio.outputs.%s.scale must map 100%% onto at most 65535", name)
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return verifyModbusConnection(modbus)
+}
+
+// Verifies the settings of a Modbus connection.
+func verifyModbusConnection(modbus ModbusConfig) error {
+	switch modbus.Transport {
+	case ModbusTCP:
+		if modbus.Address == "" {
+			return fmt.Errorf("config: modbus.address must be set for tcp")
+		}
+	case ModbusRTU:
+		if modbus.Device == "" {
+			return fmt.Errorf("config: modbus.device must be set for rtu")
+		}
+		if !baudRates[modbus.Baud] {
+			return fmt.Errorf("config: modbus.baud must be 9600, 19200, 38400, 57600 or 115200")
+		}
+	default:
+		return fmt.Errorf("config: modbus.transport must be %s or %s", ModbusTCP, ModbusRTU)
+	}
+	if modbus.Unit < 0 || modbus.Unit > 247 {
+		return fmt.Errorf("config: modbus.unit must be 0-247")
+	}
+	if modbus.Timeout <= 0 || modbus.Poll <= 0 {
+		return fmt.Errorf("config: modbus.timeout and modbus.poll must be positive integers")
+	}
+	for name, reading := range modbus.Readings {
+		if reading.Register < 0 || reading.Register > 0xffff {
+			return fmt.Errorf("config: modbus.readings.%s.register must be a register address", name)
+		}
+		if _, ok := readingKinds[reading.Kind]; !ok {
+			return fmt.Errorf("config: modbus.readings.%s.kind must be rpm, airflow, temperature, humidity or percent", name)
+		}
+	}
+	return nil
+}
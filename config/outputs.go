@@ -0,0 +1,326 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of outputs.
+const (
+	OutputGPIO     = "gpio"     // OutputGPIO is a pin of the Raspberry Pi
+	OutputDAC      = "dac"      // OutputDAC is a channel of an I2C DAC, for 0-10V speed control
+	OutputMCP23017 = "mcp23017" // OutputMCP23017 is a pin of an MCP23017 I2C GPIO expander
+	OutputUSBRelay = "usbrelay" // OutputUSBRelay is a relay on a USB serial (LCUS/CH340) relay board
+	OutputShelly   = "shelly"   // OutputShelly is a switch of a Shelly Gen2 device, through its RPC api
+	OutputTasmota  = "tasmota"  // OutputTasmota is a relay of a Tasmota device, through its HTTP api
+	OutputModbus   = "modbus"   // OutputModbus is a holding register of a unit with a Modbus interface
+)
+
+// Drivers of the gpio outputs.
+const (
+	DriverRPIO = "rpio" // DriverRPIO maps the Raspberry Pi (1-4) GPIO registers through /dev/gpiomem
+	DriverCdev = "cdev" // DriverCdev uses the Linux GPIO character device, e.g. /dev/gpiochip0
+	DriverMock = "mock" // DriverMock only logs the outputs, for development
+)
+
+// Baud rates supported for serial devices.
+var baudRates = map[int]bool{9600: true, 19200: true, 38400: true, 57600: true, 115200: true}
+
+// DAC chips.
+const (
+	ChipMCP4725 = "mcp4725" // ChipMCP4725 is the Microchip MCP4725 single channel 12-bit DAC
+	ChipGP8403  = "gp8403"  // ChipGP8403 is the Guestgood GP8403 dual channel 0-10V 12-bit DAC
+)
+
+// Highest code of the supported (12-bit) DACs.
+const dacMaxCode = 4095
+
+// CalibrationPoint maps a percentage onto a DAC code.
+type CalibrationPoint struct {
+	Percent float64 `mapstructure:"percent"`
+	Code    int     `mapstructure:"code"`
+}
+
+// OutputConfig describes a single output, as configured in gpio.outputs.<name>.
+type OutputConfig struct {
+	Type      string // One of the Output* constants
+	Pin       int    // GPIO (BCM) pin number, or line offset with the cdev driver
+	Line      string // Name of the line, with the cdev driver, e.g. GPIO17
+	ActiveLow bool   // The output is active when the pin is driven low
+	PWM       bool   // The output is driven by hardware PWM, for analog speed control
+
+	// I2C outputs
+	Bus         int                // Number of the I2C bus, as in /dev/i2c-N
+	Address     int                // I2C address of the device
+	Chip        string             // One of the Chip* constants
+	Channel     int                // Channel of the DAC
+	Calibration []CalibrationPoint // Percentages and their DAC codes, in increasing order
+	Port        string             // Port of the expander: a or b
+	Bit         int                // Bit of the expander port, 0-7
+
+	// Serial outputs
+	Device string // Path of the serial device, e.g. /dev/ttyUSB0
+	Baud   int    // Baud rate of the serial device
+	Relay  int    // Number of the relay on the board, starting at 1
+	Status bool   // The board reports the relay status, which is read back after every write
+
+	// Network outputs
+	URL      string        // Base URL of the device, e.g. http://192.168.1.50
+	Username string        // Credentials of the device, if protected
+	Password string        //
+	Timeout  time.Duration // Timeout of a single request
+	Retries  int           // Number of times a failed request is retried
+	Pulse    time.Duration // When set, the device switches the output off by itself after this time
+
+	// Modbus outputs
+	Register    int     // Address of the holding register
+	Value       int     // Value written when the output becomes active
+	OffValue    int     // Value written when the output becomes inactive, if HasOffValue
+	HasOffValue bool    //
+	Scale       float64 // When set, the output is analog: the register is written with percent * scale
+}
+
+// Analog returns whether an output supports a continuous level.
+func (o OutputConfig) Analog() bool {
+	return o.PWM || o.Type == OutputDAC || (o.Type == OutputModbus && o.Scale > 0)
+}
+
+// Code returns the DAC code for a percentage, interpolating linearly between the calibration points.
+func (o OutputConfig) Code(percent float64) int {
+	points := o.Calibration
+	if len(points) == 0 {
+		points = []CalibrationPoint{{Percent: 0, Code: 0}, {Percent: 100, Code: dacMaxCode}}
+	}
+	if percent <= points[0].Percent {
+		return points[0].Code
+	}
+	for i := 1; i < len(points); i++ {
+		if percent <= points[i].Percent {
+			from, to := points[i-1], points[i]
+			fraction := (percent - from.Percent) / (to.Percent - from.Percent)
+			return from.Code + int(fraction*float64(to.Code-from.Code)+0.5)
+		}
+	}
+	return points[len(points)-1].Code
+}
+
+// GetGPIOOutputs returns the outputs, by their logical name.
+func GetGPIOOutputs() map[string]OutputConfig {
+	once.Do(loadConfig)
+	outputs := make(map[string]OutputConfig)
+	for name := range viperInst.GetStringMap("gpio.outputs") {
+		key := "gpio.outputs." + name
+		output := OutputConfig{
+			Type:      viperInst.GetString(key + ".type"),
+			Pin:       viperInst.GetInt(key + ".pin"),
+			Line:      viperInst.GetString(key + ".line"),
+			ActiveLow: viperInst.GetBool(key + ".active_low"),
+			PWM:       viperInst.GetBool(key + ".pwm"),
+			Bus:       viperInst.GetInt(key + ".bus"),
+			Address:   viperInst.GetInt(key + ".address"),
+			Chip:      viperInst.GetString(key + ".chip"),
+			Channel:   viperInst.GetInt(key + ".channel"),
+			Port:      strings.ToLower(viperInst.GetString(key + ".port")),
+			Bit:       viperInst.GetInt(key + ".bit"),
+			Device:    viperInst.GetString(key + ".device"),
+			Baud:      9600,
+			Relay:     viperInst.GetInt(key + ".relay"),
+			Status:    viperInst.GetBool(key + ".status"),
+		}
+		if viperInst.IsSet(key + ".baud") {
+			output.Baud = viperInst.GetInt(key + ".baud")
+		}
+		output.URL = strings.TrimSuffix(viperInst.GetString(key+".url"), "/")
+		output.Username = viperInst.GetString(key + ".username")
+		output.Password = viperInst.GetString(key + ".password")
+		output.Timeout = 2 * time.Second
+		if viperInst.IsSet(key + ".timeout") {
+			output.Timeout = time.Duration(viperInst.GetInt(key+".timeout")) * time.Millisecond
+		}
+		output.Retries = 2
+		if viperInst.IsSet(key + ".retries") {
+			output.Retries = viperInst.GetInt(key + ".retries")
+		}
+		output.Pulse = time.Duration(viperInst.GetInt(key+".pulse")) * time.Millisecond
+		output.Register = viperInst.GetInt(key + ".register")
+		output.Value = viperInst.GetInt(key + ".value")
+		output.OffValue = viperInst.GetInt(key + ".off_value")
+		output.HasOffValue = viperInst.IsSet(key + ".off_value")
+		output.Scale = viperInst.GetFloat64(key + ".scale")
+		if output.Type == "" {
+			output.Type = OutputGPIO
+		}
+		_ = viperInst.UnmarshalKey(key+".calibration", &output.Calibration)
+		sort.Slice(output.Calibration, func(i, j int) bool {
+			return output.Calibration[i].Percent < output.Calibration[j].Percent
+		})
+		outputs[name] = output
+	}
+	return outputs
+}
+
+// Verifies the outputs.
+func verifyOutputs() error {
+	outputs := GetGPIOOutputs()
+	if len(outputs) == 0 {
+		return fmt.Errorf("config: gpio.outputs must contain at least one output")
+	}
+	driver := GetGPIODriver()
+	expanderBits := make(map[string]string)
+	for _, name := range sortedNames(outputs) {
+		output := outputs[name]
+		key := "gpio.outputs." + name
+		switch output.Type {
+		case OutputGPIO:
+			if err := verifyGPIO(key, output, driver); err != nil {
+				return err
+			}
+		case OutputDAC:
+			if err := verifyDAC(key, output); err != nil {
+				return err
+			}
+		case OutputMCP23017:
+			if err := verifyMCP23017(key, output); err != nil {
+				return err
+			}
+			bit := fmt.Sprintf("%d/0x%02x/%s%d", output.Bus, output.Address, output.Port, output.Bit)
+			if other, ok := expanderBits[bit]; ok {
+				return fmt.Errorf("config: %s uses the same expander pin as gpio.outputs.%s", key, other)
+			}
+			expanderBits[bit] = name
+		case OutputUSBRelay:
+			if err := verifyUSBRelay(key, output); err != nil {
+				return err
+			}
+		case OutputShelly, OutputTasmota:
+			if err := verifyNetworkRelay(key, output); err != nil {
+				return err
+			}
+		case OutputModbus:
+			// Verified with the Modbus connection.
+		default:
+			return fmt.Errorf("config: %s.type must be one of %s", key,
+				strings.Join([]string{OutputGPIO, OutputDAC, OutputMCP23017, OutputUSBRelay, OutputShelly, OutputTasmota, OutputModbus}, ", "))
+		}
+	}
+	return verifyModbus()
+}
+
+// Verifies the settings of an MCP23017 output.
+func verifyMCP23017(key string, output OutputConfig) error {
+	if output.Bus < 0 {
+		return fmt.Errorf("config: %s.bus must not be negative", key)
+	}
+	if output.Address < 0x20 || output.Address > 0x27 {
+		return fmt.Errorf("config: %s.address must be an MCP23017 address (0x20-0x27)", key)
+	}
+	if output.Port != "a" && output.Port != "b" {
+		return fmt.Errorf("config: %s.port must be a or b", key)
+	}
+	if output.Bit < 0 || output.Bit > 7 {
+		return fmt.Errorf("config: %s.bit must be 0-7", key)
+	}
+	return nil
+}
+
+// Verifies the settings of a USB relay board output.
+func verifyUSBRelay(key string, output OutputConfig) error {
+	if output.Device == "" {
+		return fmt.Errorf("config: %s.device must be set", key)
+	}
+	if output.Relay < 1 || output.Relay > 8 {
+		return fmt.Errorf("config: %s.relay must be 1-8", key)
+	}
+	if !baudRates[output.Baud] {
+		return fmt.Errorf("config: %s.baud must be 9600, 19200, 38400, 57600 or 115200", key)
+	}
+	return nil
+}
+
+// Verifies the settings of a Shelly or Tasmota output.
+func verifyNetworkRelay(key string, output OutputConfig) error {
+	u, err := url.Parse(output.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("config: %s.url must be an http or https URL", key)
+	}
+	if output.Channel < 0 {
+		return fmt.Errorf("config: %s.channel must not be negative", key)
+	}
+	if output.Timeout <= 0 {
+		return fmt.Errorf("config: %s.timeout must be a positive integer", key)
+	}
+	if output.Retries < 0 {
+		return fmt.Errorf("config: %s.retries must not be negative", key)
+	}
+	if output.Pulse < 0 {
+		return fmt.Errorf("config: %s.pulse must not be negative", key)
+	}
+	if output.Pulse > 0 && output.Type != OutputShelly {
+		return fmt.Errorf("config: %s.pulse is only supported for %s outputs", key, OutputShelly)
+	}
+	return nil
+}
+
+// Returns the sorted names of the outputs.
+func sortedNames[T any](outputs map[string]T) []string {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verifies the settings of a GPIO pin output, for the given driver.
+func verifyGPIO(key string, output OutputConfig, driver string) error {
+	if driver != DriverCdev {
+		if output.Line != "" {
+			return fmt.Errorf("config: %s.line requires gpio.driver %s", key, DriverCdev)
+		}
+		if output.Pin < 2 || output.Pin > 27 {
+			return fmt.Errorf("config: %s.pin must be a valid pin number", key)
+		}
+		return nil
+	}
+	if output.Pin < 0 {
+		return fmt.Errorf("config: %s.pin must be a line offset", key)
+	}
+	if output.PWM {
+		return fmt.Errorf("config: %s.pwm is not supported by gpio.driver %s", key, DriverCdev)
+	}
+	return nil
+}
+
+// Verifies the settings of a DAC output.
+func verifyDAC(key string, output OutputConfig) error {
+	if output.Bus < 0 {
+		return fmt.Errorf("config: %s.bus must not be negative", key)
+	}
+	if output.Address < 0x03 || output.Address > 0x77 {
+		return fmt.Errorf("config: %s.address must be a valid 7-bit I2C address", key)
+	}
+	switch output.Chip {
+	case ChipMCP4725:
+		if output.Channel != 0 {
+			return fmt.Errorf("config: %s.channel must be 0 for %s", key, ChipMCP4725)
+		}
+	case ChipGP8403:
+		if output.Channel != 0 && output.Channel != 1 {
+			return fmt.Errorf("config: %s.channel must be 0 or 1 for %s", key, ChipGP8403)
+		}
+	default:
+		return fmt.Errorf("config: %s.chip must be %s or %s", key, ChipMCP4725, ChipGP8403)
+	}
+	for i, point := range output.Calibration {
+		if point.Percent < 0 || point.Percent > 100 || point.Code < 0 || point.Code > dacMaxCode {
+			return fmt.Errorf("config: %s.calibration[%d] must map a percentage onto a code 0-%d", key, i, dacMaxCode)
+		}
+		if i > 0 && point.Percent == output.Calibration[i-1].Percent {
+			return fmt.Errorf("config: %s.calibration has duplicate percentage %g", key, point.Percent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultProfile is the profile used when none is configured.
+const DefaultProfile = "itho-cve-rft"
+
+// Built-in profiles, by name. All profiles drive the wired remote contacts of the unit, through the
+// outputs speed_1, speed_2, speed_3, away, auto and timer. Commands for outputs that are not configured
+// are left out, so only the outputs that are actually wired need to be mapped to pins.
+var profiles = map[string]map[string]CommandConfig{
+	// Itho Daalderop CVE with an RFT wired remote: 100 ms presses, and 1, 2 or 3 presses on the timer
+	// button for a 15, 30 or 60 minute boost.
+	"itho-cve-rft": {
+		"speed1":  {Label: "Low ventilation", Mode: "speed1", Steps: press("speed_1", 1, 100, 100)},
+		"speed2":  {Label: "Medium ventilation", Mode: "speed2", Steps: press("speed_2", 1, 100, 100)},
+		"speed3":  {Label: "High ventilation", Mode: "speed3", Steps: press("speed_3", 1, 100, 100)},
+		"away":    {Label: "Away mode", Mode: "away", Steps: press("away", 1, 100, 100)},
+		"auto":    {Label: "Automatic mode", Mode: "auto", Steps: press("auto", 1, 100, 100)},
+		"timer15": {Label: "High ventilation (15 minutes)", Mode: "boost", Boost: 15 * time.Minute, Steps: press("timer", 1, 100, 100)},
+		"timer30": {Label: "High ventilation (30 minutes)", Mode: "boost", Boost: 30 * time.Minute, Steps: press("timer", 2, 100, 100)},
+		"timer60": {Label: "High ventilation (60 minutes)", Mode: "boost", Boost: 60 * time.Minute, Steps: press("timer", 3, 100, 100)},
+	},
+	// Zehnder ComfoAir with a wired multi-position switch: 200 ms presses, and a 2 second press on the
+	// timer button for a 30 minute boost. There is no automatic mode.
+	"zehnder": {
+		"speed1":  {Label: "Low ventilation", Mode: "speed1", Steps: press("speed_1", 1, 200, 0)},
+		"speed2":  {Label: "Medium ventilation", Mode: "speed2", Steps: press("speed_2", 1, 200, 0)},
+		"speed3":  {Label: "High ventilation", Mode: "speed3", Steps: press("speed_3", 1, 200, 0)},
+		"away":    {Label: "Away mode", Mode: "away", Steps: press("away", 1, 200, 0)},
+		"timer30": {Label: "High ventilation (30 minutes)", Mode: "boost", Boost: 30 * time.Minute, Steps: press("timer", 1, 2000, 0)},
+	},
+	// Brink Renovent with a wired 4-position switch: 300 ms presses, and a single press on the timer
+	// button for a 60 minute boost. The unit needs more time between commands.
+	"brink": {
+		"speed1":  {Label: "Low ventilation", Mode: "speed1", Backoff: 5 * time.Second, Steps: press("speed_1", 1, 300, 0)},
+		"speed2":  {Label: "Medium ventilation", Mode: "speed2", Backoff: 5 * time.Second, Steps: press("speed_2", 1, 300, 0)},
+		"speed3":  {Label: "High ventilation", Mode: "speed3", Backoff: 5 * time.Second, Steps: press("speed_3", 1, 300, 0)},
+		"away":    {Label: "Away mode", Mode: "away", Backoff: 5 * time.Second, Steps: press("away", 1, 300, 0)},
+		"timer60": {Label: "High ventilation (60 minutes)", Mode: "boost", Boost: 60 * time.Minute, Backoff: 5 * time.Second, Steps: press("timer", 1, 300, 0)},
+	},
+	// Orcon with a wired 15RF-style remote: 150 ms presses with 250 ms between presses, and 1, 2 or 3
+	// presses on the timer button for a 15, 30 or 60 minute boost.
+	"orcon": {
+		"speed1":  {Label: "Low ventilation", Mode: "speed1", Steps: press("speed_1", 1, 150, 250)},
+		"speed2":  {Label: "Medium ventilation", Mode: "speed2", Steps: press("speed_2", 1, 150, 250)},
+		"speed3":  {Label: "High ventilation", Mode: "speed3", Steps: press("speed_3", 1, 150, 250)},
+		"away":    {Label: "Away mode", Mode: "away", Steps: press("away", 1, 150, 250)},
+		"auto":    {Label: "Automatic mode", Mode: "auto", Steps: press("auto", 1, 150, 250)},
+		"timer15": {Label: "High ventilation (15 minutes)", Mode: "boost", Boost: 15 * time.Minute, Steps: press("timer", 1, 150, 250)},
+		"timer30": {Label: "High ventilation (30 minutes)", Mode: "boost", Boost: 30 * time.Minute, Steps: press("timer", 2, 150, 250)},
+		"timer60": {Label: "High ventilation (60 minutes)", Mode: "boost", Boost: 60 * time.Minute, Steps: press("timer", 3, 150, 250)},
+	},
+}
+
+// Returns the steps for pressing a button a number of times, for width ms with gap ms between presses.
+func press(output string, times int, width int, gap int) []StepConfig {
+	steps := []StepConfig{}
+	for i := 0; i < times; i++ {
+		if i > 0 {
+			steps[len(steps)-1].Duration = time.Duration(gap) * time.Millisecond
+		}
+		steps = append(steps,
+			StepConfig{Output: output, Level: true, Duration: time.Duration(width) * time.Millisecond},
+			StepConfig{Output: output, Level: false},
+		)
+	}
+	return steps
+}
+
+// Profiles returns the names of the built-in profiles, sorted.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the commands of a built-in profile.
+func GetProfile(name string) (map[string]CommandConfig, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("config: unknown profile %s, must be one of %s", name, strings.Join(Profiles(), ", "))
+	}
+	commands := make(map[string]CommandConfig, len(profile))
+	for name, command := range profile {
+		command.Steps = append([]StepConfig{}, command.Steps...)
+		commands[name] = command
+	}
+	return commands, nil
+}
+
+// GetProfileName returns the name of the configured profile.
+func GetProfileName() string {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("profile") {
+		return DefaultProfile
+	}
+	return viperInst.GetString("profile")
+}
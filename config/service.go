@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+// GetServiceExpiry returns the time after which the service mode ends by itself, when no duration is
+// given. Zero keeps the service mode on until it is turned off.
+func GetServiceExpiry() time.Duration {
+	once.Do(loadConfig)
+	if !viperInst.IsSet("service.expiry") {
+		return 2 * time.Hour
+	}
+	return time.Duration(viperInst.GetInt("service.expiry")) * time.Minute
+}
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// Source of time for the controller, replaced by a fake clock in tests.
+type clock interface {
+	Now() time.Time
+	// Sleep waits for a duration, or returns the error of the context when it is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Clock of the system.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
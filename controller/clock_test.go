@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+// Returns a request for a command that keeps an output active for a while, followed by a backoff.
+func slowRequest(name string, pulse time.Duration, backoff time.Duration, priority string) request {
+	return request{
+		command: name,
+		adhoc: &config.CommandConfig{Backoff: backoff, Steps: []config.StepConfig{
+			{Output: "timer", Level: true, Duration: pulse},
+			{Output: "timer", Level: false},
+		}},
+		origin:   testOrigin,
+		priority: priority,
+		enqueued: time.Now(),
+	}
+}
+
+// Collects the command records of a controller.
+func recordOutcomes(controller *VentilationControllerService) chan CommandRecord {
+	records := make(chan CommandRecord, 10)
+	controller.AddCommandListener(func(record CommandRecord) {
+		records <- record
+	})
+	return records
+}
+
+func TestStopDeadline(t *testing.T) {
+	adapter := gpio.NewGPIOMockAdapter()
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	records := recordOutcomes(controller)
+
+	controller.Start()
+	controller.enqueue(slowRequest("slow", 3*time.Second, 0, config.PriorityHigh))
+	controller.enqueue(slowRequest("queued", 100*time.Millisecond, 0, config.PriorityHigh))
+	for i := 0; i < 100 && !adapter.Values()["timer"]; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := controller.Stop(ctx)
+	if stopped := time.Since(started); stopped > time.Second {
+		t.Fatalf("Expected Stop to respect the deadline, took %v", stopped)
+	}
+	if err == nil || !strings.Contains(err.Error(), "slow") || !strings.Contains(err.Error(), "queued") {
+		t.Fatalf("Expected both commands to be reported as aborted, got %v", err)
+	}
+	if adapter.Values()["timer"] {
+		t.Fatalf("Expected the output of the aborted pulse to be inactive")
+	}
+	for _, name := range []string{"slow", "queued"} {
+		if record := <-records; record.Command != name || record.Outcome != OutcomeAborted {
+			t.Fatalf("Expected %s to be aborted, got %+v", name, record)
+		}
+	}
+}
+
+func TestCriticalCutsBackoff(t *testing.T) {
+	controller := newVentilationControllerService()
+	records := recordOutcomes(controller)
+
+	controller.Start()
+	defer controller.Stop(context.Background())
+	controller.enqueue(slowRequest("slow", 10*time.Millisecond, 10*time.Second, config.PriorityHigh))
+	<-records
+	controller.enqueue(slowRequest("critical", 10*time.Millisecond, 0, config.PriorityCritical))
+	select {
+	case record := <-records:
+		if record.Command != "critical" || record.Outcome != OutcomeExecuted {
+			t.Fatalf("Expected the critical command to be executed, got %+v", record)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the critical command to cut the backoff short")
+	}
+}
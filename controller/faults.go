@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/gpio"
+	"github.com/rs/zerolog/log"
+)
+
+// Interval at which the backend of the outputs is checked.
+const healthInterval = 10 * time.Second
+
+// Kinds of faults.
+const (
+	faultBackend  = "backend"  // The outputs cannot be written, e.g. a device that is unplugged
+	faultFeedback = "feedback" // The unit does not confirm the commands
+)
+
+// ErrFault is returned for commands that are rejected because the outputs cannot be written.
+var ErrFault = errors.New("unit in fault state")
+
+// Sets a fault of the unit, or clears it with an empty reason. The state shows all current faults.
+func (d *VentilationControllerService) setFault(kind string, reason string) {
+	d.lock.Lock()
+	if d.faults == nil {
+		d.faults = make(map[string]string)
+	}
+	if reason == "" {
+		delete(d.faults, kind)
+	} else {
+		d.faults[kind] = reason
+	}
+	kinds := make([]string, 0, len(d.faults))
+	for k := range d.faults {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	reasons := make([]string, 0, len(kinds))
+	for _, k := range kinds {
+		reasons = append(reasons, d.faults[k])
+	}
+	fault := strings.Join(reasons, "; ")
+	changed := d.state.Fault != fault
+	d.state.Fault = fault
+	d.lock.Unlock()
+
+	if changed {
+		d.notifyState()
+	}
+}
+
+// Fault returns an error wrapping ErrFault while the outputs cannot be written, and nil otherwise.
+func (d *VentilationControllerService) Fault() error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if reason, ok := d.faults[faultBackend]; ok {
+		return fmt.Errorf("%w: %s", ErrFault, reason)
+	}
+	return nil
+}
+
+// Checks the backend of the outputs, raising or clearing the backend fault.
+func (d *VentilationControllerService) checkHealth(adapter gpio.HealthAdapter) {
+	err := adapter.Healthy()
+	faulted := d.Fault() != nil
+	switch {
+	case err != nil && !faulted:
+		log.Error().Msgf("Outputs unavailable: %v", err)
+		d.setFault(faultBackend, err.Error())
+	case err != nil:
+		d.setFault(faultBackend, err.Error())
+	case faulted:
+		log.Info().Msg("Outputs available again")
+		d.setFault(faultBackend, "")
+	}
+}
+
+// Loop checking the backend of the outputs, until the service is stopped.
+func (d *VentilationControllerService) healthLoop(adapter gpio.HealthAdapter) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.health)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.checkHealth(adapter)
+		}
+	}
+}
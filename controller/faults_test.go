@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+// Adapter with a backend that can be unplugged.
+type unpluggedAdapter struct {
+	gpio.GPIOAdapter
+	err  error
+	lock sync.Mutex
+}
+
+func (a *unpluggedAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	if err := a.Healthy(); err != nil {
+		return err
+	}
+	return a.GPIOAdapter.WriteOutput(ctx, name, value)
+}
+
+func (a *unpluggedAdapter) Healthy() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.err
+}
+
+func (a *unpluggedAdapter) plug(err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.err = err
+}
+
+// Waits for the fault of the controller to be raised or cleared.
+func waitFault(t *testing.T, controller *VentilationControllerService, faulted bool) {
+	for i := 0; i < 100; i++ {
+		if (controller.Fault() != nil) == faulted {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected the fault to be raised: %v, got %v", faulted, controller.Fault())
+}
+
+func TestBackendFault(t *testing.T) {
+	adapter := &unpluggedAdapter{GPIOAdapter: gpio.NewGPIOMockAdapter(), err: errors.New("device unplugged")}
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	controller.health = 20 * time.Millisecond
+
+	controller.Start()
+	defer controller.Stop(context.Background())
+	waitFault(t, controller, true)
+	if err := controller.SendCommand(CmdSpeed1, testOrigin); !errors.Is(err, ErrFault) {
+		t.Fatalf("Expected the command to be rejected with a fault, got %v", err)
+	}
+	if fault := controller.GetState().Fault; fault != "device unplugged" {
+		t.Fatalf("Expected the reason in the state, got %q", fault)
+	}
+
+	adapter.plug(nil)
+	waitFault(t, controller, false)
+	if err := controller.SendCommand(CmdSpeed1, testOrigin); err != nil {
+		t.Fatalf("Expected the command to be accepted after recovery, got %v", err)
+	}
+	if fault := controller.GetState().Fault; fault != "" {
+		t.Fatalf("Expected the fault to be cleared, got %q", fault)
+	}
+}
+
+func TestExecuteFault(t *testing.T) {
+	adapter := &unpluggedAdapter{GPIOAdapter: gpio.NewGPIOMockAdapter(), err: errors.New("write failed")}
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	controller.clock = sleepFunc(func(time.Duration) {})
+
+	if outcome := controller.executeVerified(context.Background(), CmdSpeed2, controller.commands[CmdSpeed2]); outcome != OutcomeFailed {
+		t.Fatalf("Expected the command to fail, got %s", outcome)
+	}
+	if !errors.Is(controller.Fault(), ErrFault) {
+		t.Fatalf("Expected a fault after the failed write")
+	}
+	adapter.plug(nil)
+	if outcome := controller.executeVerified(context.Background(), CmdSpeed2, controller.commands[CmdSpeed2]); outcome != OutcomeExecuted {
+		t.Fatalf("Expected the command to be executed, got %s", outcome)
+	}
+	if controller.Fault() != nil {
+		t.Fatalf("Expected the fault to be cleared, got %v", controller.Fault())
+	}
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Interval at which the feedback input is read while waiting for confirmation.
+const feedbackInterval = 50 * time.Millisecond
+
+// Returns the input whose status LED shows a mode, if any.
+func (d *VentilationControllerService) feedbackInput(mode string) (string, bool) {
+	if d.inputs == nil || mode == "" {
+		return "", false
+	}
+	for name, input := range d.inputConfig {
+		if input.Mode == mode {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Executes a command, and when an input shows its mode, waits for the input to confirm it. Unconfirmed
+// commands are repeated up to the configured number of retries; commands that fail to write to the
+// outputs are not. Returns the outcome of the command, which is aborted when the context is done.
+func (d *VentilationControllerService) executeVerified(ctx context.Context, name string, command config.CommandConfig) string {
+	input, verified := d.feedbackInput(command.Mode)
+	for attempt := 0; attempt <= d.feedbackRetries; attempt++ {
+		if attempt > 0 {
+			log.Warn().Msgf("Command %s not confirmed by input %s, retrying", name, input)
+		}
+		if err := d.execute(ctx, command); ctx.Err() != nil {
+			return OutcomeAborted
+		} else if err != nil {
+			d.setFault(faultBackend, err.Error())
+			return OutcomeFailed
+		}
+		d.setFault(faultBackend, "")
+		if !verified {
+			return OutcomeExecuted
+		}
+		if d.confirmed(ctx, input) {
+			d.setFault(faultFeedback, "")
+			return OutcomeExecuted
+		}
+		if ctx.Err() != nil {
+			return OutcomeAborted
+		}
+	}
+	fault := fmt.Sprintf("command %s not confirmed by input %s after %d attempts", name, input, d.feedbackRetries+1)
+	log.Error().Msg(fault)
+	d.setFault(faultFeedback, fault)
+	return OutcomeFailed
+}
+
+// Waits for an input to become active, up to the feedback timeout or until the context is done.
+func (d *VentilationControllerService) confirmed(ctx context.Context, input string) bool {
+	for waited := time.Duration(0); ; waited += feedbackInterval {
+		active, err := d.inputs.ReadInput(input)
+		if err != nil {
+			log.Error().Msgf("Failed to read input %s: %v", input, err)
+		}
+		if active {
+			return true
+		}
+		if waited >= d.feedbackTimeout {
+			return false
+		}
+		if d.clock.Sleep(ctx, feedbackInterval) != nil {
+			return false
+		}
+	}
+}
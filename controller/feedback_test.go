@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+// Creates a controller with a status LED for speed3, on a timeline adapter. The LED lights up when
+// the given function returns true, checked at every sleep.
+func feedbackController(lit func(adapter *timelineAdapter) bool) (*VentilationControllerService, *timelineAdapter) {
+	adapter := &timelineAdapter{}
+	inputConfig := map[string]config.InputConfig{"led_speed_3": {Mode: ModeSpeed3}}
+	inputs := gpio.NewGPIOMockInputAdapter(inputConfig)
+	commands, _ := config.GetProfile(config.DefaultProfile)
+	controller := &VentilationControllerService{
+		adapter:         adapter,
+		commands:        commands,
+		inputs:          inputs,
+		inputConfig:     inputConfig,
+		feedbackTimeout: time.Second,
+		feedbackRetries: 2,
+		clock: sleepFunc(func(d time.Duration) {
+			adapter.sleep(d)
+			if lit(adapter) {
+				inputs.SetInput("led_speed_3", true)
+			}
+		}),
+	}
+	return controller, adapter
+}
+
+func TestFeedbackConfirmed(t *testing.T) {
+	controller, adapter := feedbackController(func(adapter *timelineAdapter) bool {
+		return adapter.now >= 300*time.Millisecond
+	})
+	if outcome := controller.executeVerified(context.Background(), CmdSpeed3, controller.commands[CmdSpeed3]); outcome != OutcomeExecuted {
+		t.Fatalf("Expected the command to be confirmed, got %s", outcome)
+	}
+	if presses := strings.Count(adapter.timeline.String(), "speed_3 on"); presses != 1 {
+		t.Fatalf("Expected a single press, got %d", presses)
+	}
+	// Commands without a status LED are not verified.
+	if outcome := controller.executeVerified(context.Background(), CmdAway, controller.commands[CmdAway]); outcome != OutcomeExecuted {
+		t.Fatalf("Expected an unverified command to be executed, got %s", outcome)
+	}
+}
+
+func TestFeedbackRetried(t *testing.T) {
+	controller, adapter := feedbackController(func(adapter *timelineAdapter) bool {
+		return strings.Count(adapter.timeline.String(), "speed_3 on") == 2
+	})
+	if outcome := controller.executeVerified(context.Background(), CmdSpeed3, controller.commands[CmdSpeed3]); outcome != OutcomeExecuted {
+		t.Fatalf("Expected the command to be confirmed after a retry, got %s", outcome)
+	}
+	if controller.GetState().Fault != "" {
+		t.Fatalf("Expected no fault, got %s", controller.GetState().Fault)
+	}
+	if presses := strings.Count(adapter.timeline.String(), "speed_3 on"); presses != 2 {
+		t.Fatalf("Expected two presses, got %d", presses)
+	}
+}
+
+func TestFeedbackFailed(t *testing.T) {
+	controller, adapter := feedbackController(func(*timelineAdapter) bool { return false })
+	if outcome := controller.executeVerified(context.Background(), CmdSpeed3, controller.commands[CmdSpeed3]); outcome != OutcomeFailed {
+		t.Fatalf("Expected the command to fail, got %s", outcome)
+	}
+	if presses := strings.Count(adapter.timeline.String(), "speed_3 on"); presses != 3 {
+		t.Fatalf("Expected three presses, got %d", presses)
+	}
+	if fault := controller.GetState().Fault; !strings.Contains(fault, "led_speed_3") {
+		t.Fatalf("Expected a fault naming the input, got %q", fault)
+	}
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/gpio"
+	"github.com/rs/zerolog/log"
+)
+
+// Time after a command during which input changes are attributed to the command, on top of the
+// debounce period of the input. Inputs wired in parallel with the outputs see every pulse.
+const inputSettle = 250 * time.Millisecond
+
+// Marks the start or end of the execution of a command.
+func (d *VentilationControllerService) setExecuting(executing bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.executing = executing
+	if !executing {
+		d.executed = d.clock.Now()
+	}
+}
+
+// Reads the levels of the inputs into the state. A lit status LED gives the mode of the unit. Called
+// with the lock held.
+func (d *VentilationControllerService) readInputs() {
+	levels := make(map[string]bool)
+	for _, name := range d.inputs.Inputs() {
+		active, err := d.inputs.ReadInput(name)
+		if err != nil {
+			log.Error().Msgf("Failed to read input %s: %v", name, err)
+			continue
+		}
+		levels[name] = active
+		if mode := d.inputConfig[name].Mode; active && mode != "" {
+			d.state.Mode = mode
+			d.state.BaseMode = mode
+			d.state.Since = d.clock.Now()
+		}
+	}
+	d.state.Inputs = levels
+}
+
+// Loop handling the changes of the inputs, until the service is stopped.
+func (d *VentilationControllerService) inputLoop() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case event := <-d.inputs.Events():
+			d.handleInput(event)
+		}
+	}
+}
+
+// Handles a change of an input. The lockout input trips the safety lockout. Changes caused by the
+// service's own commands are only reported; others correct the believed state of the unit.
+func (d *VentilationControllerService) handleInput(event gpio.InputEvent) {
+	input := d.inputConfig[event.Name]
+
+	d.lock.Lock()
+	levels := make(map[string]bool)
+	for name, active := range d.state.Inputs {
+		levels[name] = active
+	}
+	levels[event.Name] = event.Active
+	d.state.Inputs = levels
+	own := d.executing || event.Time.Sub(d.executed) < input.Debounce+inputSettle
+	mode := d.state.Mode
+	listeners := d.inputListeners
+	d.lock.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+	if event.Name == d.lockout.Input {
+		if event.Active {
+			d.Trip(fmt.Sprintf("input %s is active", event.Name), Origin{Source: SourceLockout, Identity: event.Name})
+		}
+		d.notifyState()
+		return
+	}
+	if own || !event.Active {
+		d.notifyState()
+		return
+	}
+	if command, ok := d.commands[input.Command]; ok {
+		log.Info().Msgf("Command %s given on the wall remote (input %s)", input.Command, event.Name)
+		d.notify(request{command: input.Command, origin: Origin{Source: SourceRemote, Identity: event.Name}, enqueued: event.Time},
+			event.Time, 0, OutcomeExternal)
+		if command.Mode == "" {
+			d.notifyState()
+		}
+		d.setMode(command.Mode, command.Boost, true)
+		return
+	}
+	if input.Mode != "" && input.Mode != mode {
+		log.Info().Msgf("Unit changed to mode %s outside of the service (input %s)", input.Mode, event.Name)
+		d.setMode(input.Mode, 0, true)
+		return
+	}
+	d.notifyState()
+}
+
+// AddInputListener registers a function that is called with every change of an input. Listeners are
+// called from the input loop, and should not block.
+func (d *VentilationControllerService) AddInputListener(listener func(gpio.InputEvent)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.inputListeners = append(d.inputListeners, listener)
+}
+
+// Inputs returns the names of the inputs.
+func (d *VentilationControllerService) Inputs() []string {
+	if d.inputs == nil {
+		return nil
+	}
+	return d.inputs.Inputs()
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+// Creates a controller with a press input for speed3 on the wall remote, and a status LED for away.
+func inputController() (*VentilationControllerService, *gpio.GPIOMockInputAdapter) {
+	controller := newVentilationControllerService()
+	controller.inputConfig = map[string]config.InputConfig{
+		"remote_speed_3": {Command: CmdSpeed3, Debounce: 50 * time.Millisecond},
+		"led_away":       {Mode: ModeAway},
+	}
+	inputs := gpio.NewGPIOMockInputAdapter(controller.inputConfig)
+	controller.inputs = inputs
+	return controller, inputs
+}
+
+func TestRemotePress(t *testing.T) {
+	controller, _ := inputController()
+	var records []CommandRecord
+	controller.AddCommandListener(func(record CommandRecord) {
+		records = append(records, record)
+	})
+	var events []gpio.InputEvent
+	controller.AddInputListener(func(event gpio.InputEvent) {
+		events = append(events, event)
+	})
+
+	controller.handleInput(gpio.InputEvent{Name: "remote_speed_3", Active: true, Time: time.Now()})
+	state := controller.GetState()
+	if state.Mode != ModeSpeed3 || !state.External || !state.Inputs["remote_speed_3"] {
+		t.Fatalf("Expected an external change to speed3, got %+v", state)
+	}
+	if len(records) != 1 || records[0].Origin.Source != SourceRemote || records[0].Outcome != OutcomeExternal {
+		t.Fatalf("Expected the press to be reported as an external command, got %+v", records)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected the input listener to be called, got %v", events)
+	}
+
+	// A command sent by the service is not external.
+	controller.updateState(controller.commands[CmdSpeed1])
+	if controller.GetState().External {
+		t.Fatalf("Expected a command of the service not to be external")
+	}
+}
+
+func TestOwnPulsesIgnored(t *testing.T) {
+	controller, _ := inputController()
+	controller.updateState(controller.commands[CmdSpeed1])
+
+	controller.setExecuting(true)
+	controller.handleInput(gpio.InputEvent{Name: "remote_speed_3", Active: true, Time: time.Now()})
+	controller.setExecuting(false)
+	controller.handleInput(gpio.InputEvent{Name: "remote_speed_3", Active: true, Time: time.Now()})
+	if state := controller.GetState(); state.Mode != ModeSpeed1 || state.External {
+		t.Fatalf("Expected presses during and right after a command to be ignored, got %+v", state)
+	}
+}
+
+func TestStatusLED(t *testing.T) {
+	controller, inputs := inputController()
+	inputs.SetInput("led_away", true)
+	controller.Start()
+	defer controller.Stop(context.Background())
+	if state := controller.GetState(); state.Mode != ModeAway || state.External {
+		t.Fatalf("Expected the mode of the lit LED on start, got %+v", state)
+	}
+
+	states := make(chan State, 16)
+	controller.AddStateListener(func(state State) {
+		states <- state
+	})
+	controller.updateState(controller.commands[CmdSpeed2])
+	inputs.SetInput("led_away", false)
+	inputs.SetInput("led_away", true)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case state := <-states:
+			if state.Mode == ModeAway && state.External {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected an external change to away, got %+v", controller.GetState())
+		}
+	}
+}
+
+// Input adapter that records when it is closed.
+type closingInputs struct {
+	*gpio.GPIOMockInputAdapter
+	closed bool
+}
+
+func (c *closingInputs) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestInputsClosedOnStop(t *testing.T) {
+	controller, mock := inputController()
+	inputs := &closingInputs{GPIOMockInputAdapter: mock}
+	controller.inputs = inputs
+	controller.Start()
+	if inputs.closed {
+		t.Fatalf("Expected the inputs to stay open while running")
+	}
+	controller.Stop(context.Background())
+	if !inputs.closed {
+		t.Fatalf("Expected the inputs to be closed on stop")
+	}
+}
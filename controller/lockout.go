@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/persist"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrLockout is returned for commands that are rejected because the safety lockout tripped.
+var ErrLockout = errors.New("safety lockout")
+
+// LockoutStatus describes the safety lockout. It is persisted, so a restart does not release it.
+type LockoutStatus struct {
+	Tripped  bool      `json:"tripped"`
+	Reason   string    `json:"reason,omitempty"`
+	Since    time.Time `json:"since,omitempty"`
+	Source   string    `json:"source,omitempty"`   // Source that tripped the lockout
+	Identity string    `json:"identity,omitempty"` // Input, API key identity or MQTT client that tripped the lockout
+}
+
+// Lockout returns an error wrapping ErrLockout while the safety lockout is tripped, and nil otherwise.
+func (d *VentilationControllerService) Lockout() error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.tripped.Tripped {
+		return fmt.Errorf("%w: %s", ErrLockout, d.tripped.Reason)
+	}
+	return nil
+}
+
+// LockoutStatus returns the status of the safety lockout.
+func (d *VentilationControllerService) LockoutStatus() LockoutStatus {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.tripped
+}
+
+// Trip trips the safety lockout: the command in progress is interrupted, the queue is flushed, the
+// service mode ends, outputs driven directly are released and the lockout command, if configured, is
+// forced. Other commands are rejected until the lockout is reset. Tripping a lockout that is tripped
+// already has no effect.
+func (d *VentilationControllerService) Trip(reason string, by Origin) {
+	d.lock.Lock()
+	if d.tripped.Tripped {
+		d.lock.Unlock()
+		return
+	}
+	d.tripped = LockoutStatus{Tripped: true, Reason: reason, Since: d.clock.Now(), Source: by.Source, Identity: by.Identity}
+	d.state.Lockout = reason
+	tripped := d.tripped
+	pending := d.queue
+	d.queue = nil
+	if d.interrupt != nil {
+		d.interrupt()
+	}
+	service := d.service.Active
+	if d.serviceTimer != nil {
+		d.serviceTimer.Stop()
+		d.serviceTimer = nil
+	}
+	d.service = ServiceMode{}
+	d.state.ServiceMode = false
+	release := d.rawActive
+	d.rawActive = nil
+	d.lock.Unlock()
+
+	log.Warn().Msgf("Safety lockout tripped by %s %s: %s", by.Source, by.Identity, reason)
+	if service {
+		log.Warn().Msg("Service mode ended by the safety lockout")
+	}
+	for output := range release {
+		d.write(context.Background(), output, false)
+	}
+	if err := persist.Save(d.lockoutPath, tripped); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+	d.canceled(pending, by)
+	d.notifyState()
+	d.force(by)
+}
+
+// Reset releases the safety lockout. Returns an error wrapping ErrLockout while the lockout input is
+// still active.
+func (d *VentilationControllerService) Reset(by Origin) error {
+	d.lock.Lock()
+	if !d.tripped.Tripped {
+		d.lock.Unlock()
+		return nil
+	}
+	if input := d.lockout.Input; input != "" && d.state.Inputs[input] {
+		d.lock.Unlock()
+		return fmt.Errorf("%w: input %s is still active", ErrLockout, input)
+	}
+	d.tripped = LockoutStatus{}
+	d.state.Lockout = ""
+	d.lock.Unlock()
+
+	log.Warn().Msgf("Safety lockout reset by %s %s", by.Source, by.Identity)
+	if err := persist.Save(d.lockoutPath, LockoutStatus{}); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+	d.notifyState()
+	return nil
+}
+
+// Queues the lockout command, if configured, ahead of everything else.
+func (d *VentilationControllerService) force(by Origin) {
+	if d.lockout.Command == "" {
+		return
+	}
+	d.enqueue(request{
+		command:  d.lockout.Command,
+		origin:   by,
+		priority: config.PriorityCritical,
+		enqueued: d.clock.Now(),
+		forced:   true,
+	})
+}
+
+// Restores the safety lockout on start: a persisted lockout forces its command again, and an active
+// lockout input trips it.
+func (d *VentilationControllerService) restoreLockout() {
+	var tripped LockoutStatus
+	if err := persist.Load(d.lockoutPath, &tripped); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+
+	d.lock.Lock()
+	input := d.lockout.Input
+	active := input != "" && d.state.Inputs[input]
+	if tripped.Tripped {
+		d.tripped = tripped
+		d.state.Lockout = tripped.Reason
+	}
+	d.lock.Unlock()
+
+	switch {
+	case tripped.Tripped:
+		log.Warn().Msgf("Safety lockout tripped since %s: %s", tripped.Since.Format(time.RFC3339), tripped.Reason)
+		d.notifyState()
+		d.force(Origin{Source: tripped.Source, Identity: tripped.Identity})
+	case active:
+		d.Trip(fmt.Sprintf("input %s is active", input), Origin{Source: SourceLockout, Identity: input})
+	}
+}
+
+// Reports a request that is not started, as the safety lockout tripped after it was queued.
+func (d *VentilationControllerService) locked(req request) {
+	log.Warn().Msgf("Command %s not started, safety lockout", req.command)
+	d.notify(req, time.Time{}, 0, OutcomeCanceled)
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+// Creates a controller with a smoke detector input that trips the lockout, which forces away.
+func lockoutController(path string) (*VentilationControllerService, *gpio.GPIOMockAdapter, *gpio.GPIOMockInputAdapter) {
+	adapter := gpio.NewGPIOMockAdapter()
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	controller.inputConfig = map[string]config.InputConfig{"smoke": {}}
+	inputs := gpio.NewGPIOMockInputAdapter(controller.inputConfig)
+	controller.inputs = inputs
+	controller.lockout = config.LockoutConfig{Command: CmdAway, Input: "smoke"}
+	controller.lockoutPath = path
+	return controller, adapter, inputs
+}
+
+func TestLockout(t *testing.T) {
+	controller, adapter, inputs := lockoutController(filepath.Join(t.TempDir(), "lockout.json"))
+	records := recordOutcomes(controller)
+
+	controller.Start()
+	defer controller.Stop(context.Background())
+	controller.enqueue(slowRequest("slow", 3*time.Second, 0, config.PriorityHigh))
+	for i := 0; i < 100 && !adapter.Values()["timer"]; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	controller.SendCommand(CmdSpeed1, testOrigin)
+
+	inputs.SetInput("smoke", true)
+	outcomes := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		select {
+		case record := <-records:
+			outcomes[record.Command] = record.Outcome
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected the lockout to interrupt, cancel and force commands, got %v", outcomes)
+		}
+	}
+	if outcomes["slow"] != OutcomeAborted || outcomes[CmdSpeed1] != OutcomeCanceled || outcomes[CmdAway] != OutcomeExecuted {
+		t.Fatalf("Expected the lockout to interrupt, cancel and force commands, got %v", outcomes)
+	}
+
+	if err := controller.SendCommand(CmdSpeed3, testOrigin); !errors.Is(err, ErrLockout) {
+		t.Fatalf("Expected the command to be rejected with the lockout, got %v", err)
+	}
+	if state := controller.GetState(); state.Lockout == "" || state.Mode != ModeAway {
+		t.Fatalf("Expected the lockout and the forced mode in the state, got %+v", state)
+	}
+	if err := controller.Reset(testOrigin); !errors.Is(err, ErrLockout) {
+		t.Fatalf("Expected no reset while the input is active, got %v", err)
+	}
+
+	// Clearing the input does not release the lockout.
+	inputs.SetInput("smoke", false)
+	for i := 0; i < 100 && controller.GetState().Inputs["smoke"]; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if controller.Lockout() == nil {
+		t.Fatalf("Expected the lockout to stay tripped until it is reset")
+	}
+	if err := controller.Reset(testOrigin); err != nil {
+		t.Fatalf("Error resetting the lockout: %v", err)
+	}
+	if err := controller.SendCommand(CmdSpeed3, testOrigin); err != nil {
+		t.Fatalf("Expected the command to be accepted after the reset, got %v", err)
+	}
+}
+
+func TestLockoutPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lockout.json")
+	tripped, _, _ := lockoutController(path)
+	tripped.Trip("smoke in the attic", testOrigin)
+
+	controller, _, _ := lockoutController(path)
+	records := recordOutcomes(controller)
+	controller.Start()
+	if status := controller.LockoutStatus(); !status.Tripped || status.Reason != "smoke in the attic" || status.Source != testOrigin.Source {
+		t.Fatalf("Expected the lockout to survive a restart, got %+v", status)
+	}
+	if record := <-records; record.Command != CmdAway || record.Outcome != OutcomeExecuted {
+		t.Fatalf("Expected the lockout command to be forced again, got %+v", record)
+	}
+	if err := controller.Reset(testOrigin); err != nil {
+		t.Fatalf("Error resetting the lockout: %v", err)
+	}
+	controller.Stop(context.Background())
+
+	restarted, _, _ := lockoutController(path)
+	restarted.Start()
+	defer restarted.Stop(context.Background())
+	if err := restarted.Lockout(); err != nil {
+		t.Fatalf("Expected the reset to survive a restart, got %v", err)
+	}
+}
+
+func TestLockoutEndsServiceMode(t *testing.T) {
+	controller, adapter, _ := lockoutController(filepath.Join(t.TempDir(), "lockout.json"))
+	controller.lockout.Command = ""
+	controller.SetServiceMode(true, time.Hour, true, testOrigin)
+	if err := controller.SetOutput("timer", true, testOrigin); err != nil || !adapter.Values()["timer"] {
+		t.Fatalf("Expected the output to be active, got %v", err)
+	}
+
+	controller.Trip("smoke in the attic", testOrigin)
+	if adapter.Values()["timer"] {
+		t.Fatalf("Expected the lockout to release the output driven directly")
+	}
+	if service := controller.ServiceMode(); service.Active || controller.GetState().ServiceMode {
+		t.Fatalf("Expected the lockout to end the service mode, got %+v", service)
+	}
+	if err := controller.SetOutput("timer", true, testOrigin); !errors.Is(err, ErrLockout) {
+		t.Fatalf("Expected raw outputs to be rejected with the lockout, got %v", err)
+	}
+}
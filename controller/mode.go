@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/persist"
+	"github.com/rs/zerolog/log"
+)
+
+// Believed mode, as persisted, so runtime, filter hours and energy keep accumulating after a restart.
+// A boost is not persisted: it ends by itself, and the unit returns to the base mode.
+type savedMode struct {
+	Mode  string    `json:"mode"`
+	Since time.Time `json:"since"`
+}
+
+// Persists the base mode, when it changed.
+func (d *VentilationControllerService) saveMode(state State) {
+	if state.Mode == ModeBoost || state.BaseMode == ModeUnknown {
+		return
+	}
+	if err := persist.Save(d.modePath, savedMode{Mode: state.BaseMode, Since: state.Since}); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+}
+
+// Restores the persisted mode on start, and returns whether it did. With level control, the mode is
+// lost, as the held outputs are released on start. Called with the lock held.
+func (d *VentilationControllerService) restoreMode() bool {
+	var saved savedMode
+	if err := persist.Load(d.modePath, &saved); err != nil {
+		log.Error().Msgf("%v", err)
+		return false
+	}
+	if saved.Mode == "" || !contains(Modes, saved.Mode) {
+		return false
+	}
+	if len(d.holdOutputs) > 0 {
+		log.Info().Msgf("Mode %s not restored, the held outputs were released", saved.Mode)
+		return false
+	}
+	log.Info().Msgf("Restored mode %s, since %s", saved.Mode, saved.Since.Format(time.RFC3339))
+	d.state.Mode = saved.Mode
+	d.state.BaseMode = saved.Mode
+	d.state.Since = saved.Since
+	return true
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// Adapter that records the pin timeline of the outputs it drives.
+type timelineAdapter struct {
+	now      time.Duration
+	timeline strings.Builder
+}
+
+func (a *timelineAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	level := "off"
+	if value {
+		level = "on"
+	}
+	fmt.Fprintf(&a.timeline, "  %6dms %s %s\n", a.now.Milliseconds(), name, level)
+	return nil
+}
+
+func (a *timelineAdapter) Outputs() []string {
+	return nil
+}
+
+func (a *timelineAdapter) sleep(d time.Duration) {
+	a.now += d
+}
+
+// Renders the pin timeline of every command of a profile, in the order of the command names.
+func profileTimeline(t *testing.T, profile string, control string) string {
+	commands, err := config.GetProfile(profile)
+	if err != nil {
+		t.Fatalf("Error loading profile: %v", err)
+	}
+	if control == config.ControlLevel {
+		commands = config.LevelCommands(commands)
+	}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		adapter := &timelineAdapter{}
+		controller := &VentilationControllerService{
+			adapter:     adapter,
+			holdOutputs: holdOutputs(commands),
+			breakMake:   100 * time.Millisecond,
+			clock:       sleepFunc(adapter.sleep),
+		}
+		controller.execute(context.Background(), commands[name])
+		fmt.Fprintf(&out, "%s (%s, %dms)\n%s", name, commands[name].Mode, adapter.now.Milliseconds(), adapter.timeline.String())
+	}
+	return out.String()
+}
+
+func TestProfileTimelines(t *testing.T) {
+	for _, profile := range config.Profiles() {
+		for control, suffix := range map[string]string{config.ControlPulse: "", config.ControlLevel: "-level"} {
+			profile, control, suffix := profile, control, suffix
+			t.Run(profile+suffix, func(t *testing.T) {
+				golden := filepath.Join("testdata", "profiles", profile+suffix+".golden")
+				actual := profileTimeline(t, profile, control)
+				if *update {
+					if err := os.WriteFile(golden, []byte(actual), 0644); err != nil {
+						t.Fatalf("Error writing golden file: %v", err)
+					}
+				}
+				expected, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("Error reading golden file: %v", err)
+				}
+				if actual != string(expected) {
+					t.Fatalf("Timeline differs from %s:\n%s", golden, actual)
+				}
+			})
+		}
+	}
+}
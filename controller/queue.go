@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Priority classes of the sources. The priority of a command can raise the class of its source.
+var sourcePriorities = map[string]string{
+	SourceWeb:      config.PriorityHigh,
+	SourceMQTT:     config.PriorityNormal,
+	SourceSchedule: config.PriorityLow,
+	SourceRule:     config.PriorityLow,
+}
+
+// ErrNotQueued is returned when canceling a command that is not (or no longer) waiting in the queue.
+var ErrNotQueued = errors.New("command not queued")
+
+// QueuedCommand describes a command waiting in the queue.
+type QueuedCommand struct {
+	ID             uint64    `json:"id"`
+	Command        string    `json:"command"`
+	Source         string    `json:"source"`
+	Identity       string    `json:"identity,omitempty"`
+	Priority       string    `json:"priority"`
+	Enqueued       time.Time `json:"enqueued"`
+	EstimatedStart time.Time `json:"estimated_start"` // From the backoff and duration of the commands before it
+}
+
+// Returns the rank of a priority class: the higher, the sooner a command is served.
+func priorityRank(priority string) int {
+	for rank, p := range config.Priorities {
+		if p == priority {
+			return rank
+		}
+	}
+	return 0
+}
+
+// Returns the priority class of a command from a source: the class of the source, unless the command
+// has a higher one.
+func requestPriority(source string, command config.CommandConfig) string {
+	priority, ok := sourcePriorities[source]
+	if !ok {
+		priority = config.PriorityNormal
+	}
+	if command.Priority != "" && priorityRank(command.Priority) > priorityRank(priority) {
+		priority = command.Priority
+	}
+	return priority
+}
+
+// Returns the rank a request is served with. Every period of aging it waits raises it one class, up to
+// the high class, so commands of lower classes are not starved.
+func effectiveRank(req request, now time.Time, aging time.Duration) int {
+	rank := priorityRank(req.priority)
+	limit := priorityRank(config.PriorityHigh)
+	if aging <= 0 || rank >= limit {
+		return rank
+	}
+	rank += int(now.Sub(req.enqueued) / aging)
+	if rank > limit {
+		return limit
+	}
+	return rank
+}
+
+// Returns the index of the request to serve next: the one with the highest rank, and of those, the one
+// that was queued first.
+func nextIndex(queue []request, now time.Time, aging time.Duration) int {
+	next := 0
+	for i := 1; i < len(queue); i++ {
+		rank, best := effectiveRank(queue[i], now, aging), effectiveRank(queue[next], now, aging)
+		if rank > best || rank == best && queue[i].enqueued.Before(queue[next].enqueued) {
+			next = i
+		}
+	}
+	return next
+}
+
+// Returns the estimated duration of a command, without the backoff.
+func (d *VentilationControllerService) commandDuration(command config.CommandConfig) time.Duration {
+	duration := time.Duration(0)
+	if command.Hold != "" {
+		duration += d.breakMake
+	}
+	for _, step := range command.Steps {
+		duration += step.Duration
+	}
+	return duration
+}
+
+// Returns the configuration of the command of a request.
+func (d *VentilationControllerService) requestCommand(req request) config.CommandConfig {
+	if req.adhoc != nil {
+		return *req.adhoc
+	}
+	return d.commands[req.command]
+}
+
+// Sets the time at which the command loop is expected to be ready for the next command.
+func (d *VentilationControllerService) setBusy(until time.Time) {
+	d.lock.Lock()
+	d.busyUntil = until
+	d.lock.Unlock()
+	d.notifyQueue()
+}
+
+// Adds a request to the queue. When the queue is full, the oldest request of the lowest rank is dropped,
+// which may be the new request itself, and returned. Must be called with the lock held.
+func (d *VentilationControllerService) push(req request, now time.Time) (request, bool) {
+	d.queue = append(d.queue, req)
+	if len(d.queue) <= queueSize {
+		return request{}, false
+	}
+	drop := 0
+	for i := 1; i < len(d.queue); i++ {
+		if effectiveRank(d.queue[i], now, d.aging) < effectiveRank(d.queue[drop], now, d.aging) {
+			drop = i
+		}
+	}
+	dropped := d.queue[drop]
+	d.queue = append(d.queue[:drop], d.queue[drop+1:]...)
+	return dropped, true
+}
+
+// Removes the request to serve next from the queue. Must be called with the lock held, on a queue that
+// is not empty.
+func (d *VentilationControllerService) pop(now time.Time) request {
+	next := nextIndex(d.queue, now, d.aging)
+	req := d.queue[next]
+	d.queue = append(d.queue[:next], d.queue[next+1:]...)
+	return req
+}
+
+// Waits for the next request to serve. Returns false when the service stops.
+func (d *VentilationControllerService) next() (request, bool) {
+	for {
+		select {
+		case <-d.done:
+			return request{}, false
+		default:
+		}
+		d.lock.Lock()
+		if len(d.queue) > 0 {
+			req := d.pop(d.clock.Now())
+			d.lock.Unlock()
+			return req, true
+		}
+		d.lock.Unlock()
+		select {
+		case <-d.queued:
+		case <-d.done:
+		}
+	}
+}
+
+// Returns whether a critical request is waiting, which cuts the backoff short.
+func (d *VentilationControllerService) urgentQueued() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, req := range d.queue {
+		if req.priority == config.PriorityCritical {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue returns the commands waiting in the queue, in the order they would be served now, with the time
+// they are expected to start.
+func (d *VentilationControllerService) Queue() []QueuedCommand {
+	d.lock.RLock()
+	pending := append([]request{}, d.queue...)
+	start := d.busyUntil
+	d.lock.RUnlock()
+
+	now := d.clock.Now()
+	if start.Before(now) {
+		start = now
+	}
+	queued := make([]QueuedCommand, 0, len(pending))
+	for len(pending) > 0 {
+		next := nextIndex(pending, now, d.aging)
+		req := pending[next]
+		pending = append(pending[:next], pending[next+1:]...)
+		queued = append(queued, QueuedCommand{
+			ID:             req.id,
+			Command:        req.command,
+			Source:         req.origin.Source,
+			Identity:       req.origin.Identity,
+			Priority:       req.priority,
+			Enqueued:       req.enqueued,
+			EstimatedStart: start,
+		})
+		command := d.requestCommand(req)
+		start = start.Add(d.commandDuration(command) + command.Backoff)
+	}
+	return queued
+}
+
+// Cancel removes a command from the queue, by its id. Returns an error wrapping ErrNotQueued when the
+// command is not waiting in the queue, e.g. because it was started already.
+func (d *VentilationControllerService) Cancel(id uint64, by Origin) error {
+	d.lock.Lock()
+	var canceled []request
+	for i, req := range d.queue {
+		if req.id == id {
+			canceled = append(canceled, req)
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			break
+		}
+	}
+	d.lock.Unlock()
+
+	if len(canceled) == 0 {
+		return fmt.Errorf("%w: %d", ErrNotQueued, id)
+	}
+	d.canceled(canceled, by)
+	return nil
+}
+
+// Flush removes all commands from the queue, and returns how many were removed.
+func (d *VentilationControllerService) Flush(by Origin) int {
+	d.lock.Lock()
+	canceled := d.queue
+	d.queue = nil
+	d.lock.Unlock()
+
+	d.canceled(canceled, by)
+	return len(canceled)
+}
+
+// Reports commands that were removed from the queue.
+func (d *VentilationControllerService) canceled(canceled []request, by Origin) {
+	for _, req := range canceled {
+		log.Info().Msgf("Command %s (%d) canceled by %s %s", req.command, req.id, by.Source, by.Identity)
+		d.notify(req, time.Time{}, 0, OutcomeCanceled)
+	}
+	if len(canceled) > 0 {
+		d.notifyQueue()
+	}
+}
+
+// Report the queue to all registered queue listeners.
+func (d *VentilationControllerService) notifyQueue() {
+	d.lock.RLock()
+	listeners := d.queueListeners
+	d.lock.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+
+	queued := d.Queue()
+	for _, listener := range listeners {
+		listener(queued)
+	}
+}
+
+// AddQueueListener registers a function that is called with the waiting commands whenever the queue
+// changes. Listeners should not block.
+func (d *VentilationControllerService) AddQueueListener(listener func([]QueuedCommand)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.queueListeners = append(d.queueListeners, listener)
+}
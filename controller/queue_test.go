@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+// Returns the names of the queued commands, in the order they would be served.
+func queuedNames(controller *VentilationControllerService) []string {
+	names := []string{}
+	for _, queued := range controller.Queue() {
+		names = append(names, queued.Command)
+	}
+	return names
+}
+
+func TestPriorityOrder(t *testing.T) {
+	controller := newVentilationControllerService()
+	controller.aging = 0
+
+	controller.SendCommand(CmdAuto, Origin{Source: SourceSchedule, Identity: "night"})
+	controller.SendCommand(CmdAway, Origin{Source: SourceMQTT})
+	controller.SendCommand(CmdSpeed3, testOrigin)
+	if names := queuedNames(controller); len(names) != 3 || names[0] != CmdSpeed3 || names[1] != CmdAway || names[2] != CmdAuto {
+		t.Fatalf("Expected the commands by priority, got %v", names)
+	}
+	if queued := controller.Queue(); queued[0].Priority != config.PriorityHigh || queued[2].Priority != config.PriorityLow {
+		t.Fatalf("Expected the priorities in the queue, got %+v", queued)
+	}
+}
+
+func TestPriorityFIFO(t *testing.T) {
+	controller := newVentilationControllerService()
+	schedule := Origin{Source: SourceSchedule, Identity: "night"}
+
+	controller.SendCommand(CmdSpeed1, schedule)
+	controller.SendCommand(CmdSpeed2, schedule)
+	controller.SendCommand(CmdAway, schedule)
+	if names := queuedNames(controller); names[0] != CmdSpeed1 || names[1] != CmdSpeed2 || names[2] != CmdAway {
+		t.Fatalf("Expected the commands of a class in the order they were queued, got %v", names)
+	}
+}
+
+func TestQueueFullDropsLowest(t *testing.T) {
+	controller := newVentilationControllerService()
+	controller.aging = 0
+	records := recordOutcomes(controller)
+
+	controller.SendCommand(CmdSpeed1, testOrigin)
+	controller.SendCommand(CmdAuto, Origin{Source: SourceRule, Identity: "humidity"})
+	controller.SendCommand(CmdAway, Origin{Source: SourceRule, Identity: "presence"})
+	controller.SendCommand(CmdSpeed3, testOrigin)
+	if record := <-records; record.Command != CmdAuto || record.Outcome != OutcomeDropped || record.Priority != config.PriorityLow {
+		t.Fatalf("Expected the oldest command of the lowest class to be dropped, got %+v", record)
+	}
+
+	// A command of the lowest class is dropped itself when the queue is full of higher classes.
+	controller.SendCommand(CmdSpeed2, Origin{Source: SourceSchedule})
+	if record := <-records; record.Command != CmdAway {
+		t.Fatalf("Expected the oldest command of the lowest class to be dropped, got %+v", record)
+	}
+	controller.SendCommand(CmdAuto, Origin{Source: SourceSchedule})
+	if record := <-records; record.Command != CmdSpeed2 {
+		t.Fatalf("Expected the oldest command of the lowest class to be dropped, got %+v", record)
+	}
+}
+
+func TestAging(t *testing.T) {
+	now := time.Now()
+	low := request{command: CmdAuto, priority: config.PriorityLow, enqueued: now.Add(-3 * time.Minute)}
+	high := request{command: CmdSpeed3, priority: config.PriorityHigh, enqueued: now}
+	critical := request{command: CmdAway, priority: config.PriorityCritical, enqueued: now}
+
+	if rank := effectiveRank(low, now, 0); rank != priorityRank(config.PriorityLow) {
+		t.Fatalf("Expected no aging when disabled, got rank %d", rank)
+	}
+	if rank := effectiveRank(low, now, time.Minute); rank != priorityRank(config.PriorityHigh) {
+		t.Fatalf("Expected a waiting command to be raised up to the high class, got rank %d", rank)
+	}
+	if next := nextIndex([]request{high, low}, now, time.Minute); next != 1 {
+		t.Fatalf("Expected the aged command to be served before a newer command of the same rank")
+	}
+	if next := nextIndex([]request{low, critical}, now, time.Minute); next != 1 {
+		t.Fatalf("Expected aging not to pass the critical class")
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	command := config.CommandConfig{}
+	if priority := requestPriority(SourceRule, command); priority != config.PriorityLow {
+		t.Fatalf("Expected rules to have a low priority, got %s", priority)
+	}
+	command.Priority = config.PriorityCritical
+	if priority := requestPriority(SourceSchedule, command); priority != config.PriorityCritical {
+		t.Fatalf("Expected the priority of the command to raise the class, got %s", priority)
+	}
+	command.Priority = config.PriorityLow
+	if priority := requestPriority(SourceWeb, command); priority != config.PriorityHigh {
+		t.Fatalf("Expected the priority of the command not to lower the class, got %s", priority)
+	}
+}
+
+func TestCancelAndFlush(t *testing.T) {
+	controller := newVentilationControllerService()
+	records := recordOutcomes(controller)
+	var lengths []int
+	controller.AddQueueListener(func(queued []QueuedCommand) {
+		lengths = append(lengths, len(queued))
+	})
+
+	controller.SendCommand(CmdSpeed1, testOrigin)
+	controller.SendCommand(CmdSpeed2, testOrigin)
+	controller.SendCommand(CmdAway, testOrigin)
+	queued := controller.Queue()
+	if queued[0].ID == queued[1].ID || queued[1].EstimatedStart.Before(queued[0].EstimatedStart.Add(controller.commands[CmdSpeed1].Backoff)) {
+		t.Fatalf("Expected distinct ids and starts after the backoff of the commands before, got %+v", queued)
+	}
+
+	if err := controller.Cancel(queued[1].ID, testOrigin); err != nil {
+		t.Fatalf("Error canceling command: %v", err)
+	}
+	if record := <-records; record.Command != CmdSpeed2 || record.Outcome != OutcomeCanceled {
+		t.Fatalf("Expected the command to be canceled, got %+v", record)
+	}
+	if err := controller.Cancel(queued[1].ID, testOrigin); !errors.Is(err, ErrNotQueued) {
+		t.Fatalf("Expected the command not to be queued anymore, got %v", err)
+	}
+	if names := queuedNames(controller); len(names) != 2 || names[1] != CmdAway {
+		t.Fatalf("Expected the other commands to stay queued, got %v", names)
+	}
+
+	if canceled := controller.Flush(testOrigin); canceled != 2 || len(controller.Queue()) != 0 {
+		t.Fatalf("Expected the queue to be flushed, canceled %d", canceled)
+	}
+	if last := lengths[len(lengths)-1]; len(lengths) != 5 || last != 0 {
+		t.Fatalf("Expected the listeners to follow the queue, got %v", lengths)
+	}
+}
+
+func TestAgingWithClock(t *testing.T) {
+	controller := newVentilationControllerService()
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)}
+	controller.clock = clock
+	controller.aging = time.Minute
+
+	controller.SendCommand(CmdAuto, Origin{Source: SourceSchedule, Identity: "night"})
+	clock.advance(30 * time.Second)
+	controller.SendCommand(CmdAway, Origin{Source: SourceMQTT})
+	if names := queuedNames(controller); names[0] != CmdAway || names[1] != CmdAuto {
+		t.Fatalf("Expected the older command of the lower class to wait its turn, got %v", names)
+	}
+
+	// After two periods, the command of the lower class is raised past the other one.
+	clock.advance(90 * time.Second)
+	queued := controller.Queue()
+	if queued[0].Command != CmdAuto || queued[1].Command != CmdAway {
+		t.Fatalf("Expected the aged command to be served first, got %+v", queued)
+	}
+	now := clock.Now()
+	auto := controller.commands[CmdAuto]
+	if !queued[0].EstimatedStart.Equal(now) || !queued[1].EstimatedStart.Equal(now.Add(controller.commandDuration(auto)+auto.Backoff)) {
+		t.Fatalf("Expected the estimates from the time of the clock, got %+v", queued)
+	}
+
+	controller.setBusy(now.Add(30 * time.Second))
+	if queued := controller.Queue(); !queued[0].EstimatedStart.Equal(now.Add(30 * time.Second)) {
+		t.Fatalf("Expected the estimates to wait for the command loop, got %+v", queued)
+	}
+}
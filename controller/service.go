@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Sources of automations, whose commands are suppressed in service mode. MQTT counts as an automation,
+// as Home Assistant automations and demand control publish on it.
+var automatedSources = []string{SourceMQTT, SourceSchedule, SourceRule}
+
+// ErrServiceMode is returned for commands of automations that are suppressed in service mode.
+var ErrServiceMode = errors.New("service mode")
+
+// ErrRawOutputs is returned for writes to outputs that are not allowed, outside of a service mode that
+// allows them.
+var ErrRawOutputs = errors.New("raw outputs not allowed")
+
+// ServiceMode describes the service mode, in which an installer services the unit.
+type ServiceMode struct {
+	Active     bool      `json:"active"`
+	Since      time.Time `json:"since,omitempty"`
+	Until      time.Time `json:"until,omitempty"` // When the service mode ends by itself, if so
+	RawOutputs bool      `json:"raw_outputs"`     // The outputs can be driven directly
+	Source     string    `json:"source,omitempty"`
+	Identity   string    `json:"identity,omitempty"`
+}
+
+// ServiceMode returns the service mode.
+func (d *VentilationControllerService) ServiceMode() ServiceMode {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.service
+}
+
+// SetServiceMode turns the service mode on or off. While on, commands of automations are suppressed,
+// and with raw outputs, the outputs can be driven directly with SetOutput. A positive duration ends
+// the service mode by itself. Outputs driven directly are made inactive when they are no longer allowed.
+func (d *VentilationControllerService) SetServiceMode(active bool, duration time.Duration, raw bool, by Origin) ServiceMode {
+	if active {
+		log.Warn().Msgf("Service mode on by %s %s, for %v, raw outputs: %v", by.Source, by.Identity, duration, raw)
+	} else {
+		log.Info().Msgf("Service mode off by %s %s", by.Source, by.Identity)
+	}
+	return d.setServiceMode(active, duration, raw, by)
+}
+
+// Turns the service mode on or off, and releases the outputs driven directly when they are no longer
+// allowed.
+func (d *VentilationControllerService) setServiceMode(active bool, duration time.Duration, raw bool, by Origin) ServiceMode {
+	now := d.clock.Now()
+	d.lock.Lock()
+	if d.serviceTimer != nil {
+		d.serviceTimer.Stop()
+		d.serviceTimer = nil
+	}
+	service := ServiceMode{}
+	if active {
+		service = ServiceMode{Active: true, Since: now, RawOutputs: raw, Source: by.Source, Identity: by.Identity}
+		if duration > 0 {
+			service.Until = now.Add(duration)
+			d.serviceTimer = time.AfterFunc(duration, d.expireServiceMode)
+		}
+	}
+	d.service = service
+	d.state.ServiceMode = active
+	var release []string
+	if !service.RawOutputs {
+		for output := range d.rawActive {
+			release = append(release, output)
+		}
+		d.rawActive = nil
+	}
+	d.lock.Unlock()
+
+	for _, output := range release {
+		d.write(context.Background(), output, false)
+	}
+	d.notifyState()
+	return service
+}
+
+// Ends the service mode when it expires.
+func (d *VentilationControllerService) expireServiceMode() {
+	d.lock.RLock()
+	expired := d.service.Active && !d.service.Until.IsZero() && !d.clock.Now().Before(d.service.Until)
+	d.lock.RUnlock()
+	if expired {
+		log.Info().Msg("Service mode expired")
+		d.setServiceMode(false, 0, false, Origin{})
+	}
+}
+
+// Suppresses a request of an automation in service mode, reporting and logging it. Returns whether the
+// request was suppressed.
+func (d *VentilationControllerService) suppress(req request) bool {
+	d.lock.RLock()
+	active := d.service.Active
+	d.lock.RUnlock()
+	if !active || req.forced || !contains(automatedSources, req.origin.Source) {
+		return false
+	}
+	log.Warn().Msgf("Command %s from %s %s suppressed, service mode", req.command, req.origin.Source, req.origin.Identity)
+	d.notify(req, time.Time{}, 0, OutcomeSuppressed)
+	return true
+}
+
+// SetOutput drives an output directly, for testing. Only allowed in a service mode with raw outputs,
+// and not while the safety lockout is tripped. The watchdog still ends pulses that are too long.
+func (d *VentilationControllerService) SetOutput(output string, active bool, by Origin) error {
+	if err := d.Lockout(); err != nil {
+		return err
+	}
+	if !contains(d.adapter.Outputs(), output) {
+		return fmt.Errorf("unknown output: %s", output)
+	}
+	d.lock.Lock()
+	if !d.service.RawOutputs {
+		d.lock.Unlock()
+		return ErrRawOutputs
+	}
+	if d.rawActive == nil {
+		d.rawActive = make(map[string]bool)
+	}
+	if active {
+		d.rawActive[output] = true
+	} else {
+		delete(d.rawActive, output)
+	}
+	d.lock.Unlock()
+
+	log.Warn().Msgf("Output %s set to %v by %s %s, service mode", output, active, by.Source, by.Identity)
+	return d.write(context.Background(), output, active)
+}
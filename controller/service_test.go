@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+func TestServiceMode(t *testing.T) {
+	controller := newVentilationControllerService()
+	controller.clock = sleepFunc(func(time.Duration) {})
+	records := recordOutcomes(controller)
+
+	controller.SendCommand(CmdAway, Origin{Source: SourceMQTT, Identity: "homeassistant"})
+	controller.SetServiceMode(true, 0, false, testOrigin)
+	if err := controller.SendCommand(CmdAuto, Origin{Source: SourceSchedule, Identity: "night"}); !errors.Is(err, ErrServiceMode) {
+		t.Fatalf("Expected the command of the schedule to be suppressed, got %v", err)
+	}
+	if record := <-records; record.Command != CmdAuto || record.Outcome != OutcomeSuppressed {
+		t.Fatalf("Expected the suppressed command to be reported, got %+v", record)
+	}
+	if err := controller.SendCommand(CmdSpeed1, testOrigin); err != nil {
+		t.Fatalf("Expected the command of the installer to be accepted, got %v", err)
+	}
+
+	// A command of an automation queued before the service mode is suppressed when it is served.
+	controller.Start()
+	defer controller.Stop(context.Background())
+	outcomes := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		record := <-records
+		outcomes[record.Command] = record.Outcome
+	}
+	if outcomes[CmdSpeed1] != OutcomeExecuted || outcomes[CmdAway] != OutcomeSuppressed {
+		t.Fatalf("Expected only the command of the installer to be executed, got %v", outcomes)
+	}
+
+	controller.SetServiceMode(false, 0, false, testOrigin)
+	if err := controller.SendCommand(CmdAuto, Origin{Source: SourceSchedule, Identity: "night"}); err != nil {
+		t.Fatalf("Expected the command of the schedule to be accepted after the service mode, got %v", err)
+	}
+}
+
+func TestServiceModeExpiry(t *testing.T) {
+	controller := newVentilationControllerService()
+	if service := controller.SetServiceMode(true, 50*time.Millisecond, false, testOrigin); service.Until.IsZero() {
+		t.Fatalf("Expected the service mode to end by itself, got %+v", service)
+	}
+	if !controller.GetState().ServiceMode {
+		t.Fatalf("Expected the service mode in the state")
+	}
+	for i := 0; i < 100 && controller.ServiceMode().Active; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if controller.ServiceMode().Active || controller.GetState().ServiceMode {
+		t.Fatalf("Expected the service mode to expire")
+	}
+}
+
+func TestRawOutputs(t *testing.T) {
+	adapter := gpio.NewGPIOMockAdapter()
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+
+	if err := controller.SetOutput("timer", true, testOrigin); !errors.Is(err, ErrRawOutputs) {
+		t.Fatalf("Expected raw outputs to be rejected outside of the service mode, got %v", err)
+	}
+	controller.SetServiceMode(true, 0, false, testOrigin)
+	if err := controller.SetOutput("timer", true, testOrigin); !errors.Is(err, ErrRawOutputs) {
+		t.Fatalf("Expected raw outputs to be rejected unless allowed, got %v", err)
+	}
+
+	controller.SetServiceMode(true, 0, true, testOrigin)
+	if err := controller.SetOutput("unknown", true, testOrigin); err == nil {
+		t.Fatalf("Expected an error for an unknown output")
+	}
+	if err := controller.SetOutput("timer", true, testOrigin); err != nil || !adapter.Values()["timer"] {
+		t.Fatalf("Expected the output to be active, got %v", err)
+	}
+	controller.SetServiceMode(false, 0, false, testOrigin)
+	if adapter.Values()["timer"] {
+		t.Fatalf("Expected the output to be made inactive when the service mode ends")
+	}
+}
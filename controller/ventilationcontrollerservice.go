@@ -1,6 +1,12 @@
 package controller
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,41 +15,162 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// Enum pseudo-type.
-type Enum uint
-
-// Queue size for the command channel.
+// Size of the command queue.
 const queueSize = 3
 
-// Enumeration of commands.
+// Names of the default commands. Other commands can be defined in the configuration.
+const (
+	CmdSpeed1  = "speed1"  // CmdSpeed1 identifies the speed 1 request command
+	CmdSpeed2  = "speed2"  // CmdSpeed2 identifies the speed 2 request command
+	CmdSpeed3  = "speed3"  // CmdSpeed3 identifies the speed 3 request command
+	CmdAway    = "away"    // CmdAway identifies the away request command
+	CmdAuto    = "auto"    // CmdAuto identifies the auto request command
+	CmdTimer15 = "timer15" // CmdTimer15 identifies the timer request command (15')
+	CmdTimer30 = "timer30" // CmdTimer30 identifies the timer request command (30')
+	CmdTimer60 = "timer60" // CmdTimer60 identifies the timer request command (60')
+	CmdPercent = "percent" // CmdPercent identifies a request for a percentage on the analog output
+)
+
+// Interval between changes of the analog output while ramping.
+const rampInterval = 100 * time.Millisecond
+
+// Sources a command can originate from.
 const (
-	CmdDummy   Enum = iota // CmdDummy does nothing, but prevents errors when closing the channel.
-	CmdSpeed1              // CmdSpeed1 identifies the speed 1 request command
-	CmdSpeed2              // CmdSpeed2 identifies the speed 2 request command
-	CmdSpeed3              // CmdSpeed3 identifies the speed 3 request command
-	CmdAway                // CmdAway identifies the away request command
-	CmdAuto                // CmdAuto identifies the auto request command
-	CmdTimer15             // CmdTimer15 identifies the timer request command (10')
-	CmdTimer30             // CmdTimer30 identifies the timer request command (30')
-	CmdTimer60             // CmdTimer60 identifies the timer request command (60')
+	SourceWeb      = "web"      // SourceWeb identifies commands received through the REST api
+	SourceMQTT     = "mqtt"     // SourceMQTT identifies commands received on the MQTT action topic
+	SourceSchedule = "schedule" // SourceSchedule identifies commands issued by a schedule
+	SourceRule     = "rule"     // SourceRule identifies commands issued by an automation rule
+	SourceRemote   = "remote"   // SourceRemote identifies presses on the wall remote, sensed by an input
+	SourceLockout  = "lockout"  // SourceLockout identifies the safety lockout input
 )
 
+// Outcomes of a command.
 const (
-	reactTime time.Duration = 100 * time.Millisecond
+	OutcomeExecuted   = "executed"   // OutcomeExecuted means the pulse sequence was sent to the unit
+	OutcomeDropped    = "dropped"    // OutcomeDropped means the command was pushed out of a full queue
+	OutcomeExternal   = "external"   // OutcomeExternal means the command was given outside of the service
+	OutcomeFailed     = "failed"     // OutcomeFailed means the unit did not confirm the command
+	OutcomeAborted    = "aborted"    // OutcomeAborted means the command was cut short or not started, as the service stopped or the lockout tripped
+	OutcomeCanceled   = "canceled"   // OutcomeCanceled means the command was removed from the queue before it started
+	OutcomeSuppressed = "suppressed" // OutcomeSuppressed means the command of an automation was not executed, in service mode
+)
+
+// Modes the unit can be in, as far as the controller knows.
+const (
+	ModeUnknown = "unknown" // ModeUnknown is the mode until the first command is sent, unless one is restored
+	ModeSpeed1  = "speed1"  // ModeSpeed1 is low ventilation
+	ModeSpeed2  = "speed2"  // ModeSpeed2 is medium ventilation
+	ModeSpeed3  = "speed3"  // ModeSpeed3 is high ventilation
+	ModeAway    = "away"    // ModeAway is the away mode
+	ModeAuto    = "auto"    // ModeAuto is the automatic (demand driven) mode
+	ModeBoost   = "boost"   // ModeBoost is high ventilation for a limited time
 )
 
 var (
 	instance *VentilationControllerService
 	once     sync.Once
+
+	// Modes = all known modes, in display order.
+	Modes = []string{ModeSpeed1, ModeSpeed2, ModeSpeed3, ModeAway, ModeAuto, ModeBoost, ModeUnknown}
 )
 
+// Command describes a command that can be sent to the unit.
+type Command struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Mode  string `json:"mode,omitempty"`
+}
+
+// Origin describes who sent a command.
+type Origin struct {
+	Source   string // One of the Source* constants
+	Identity string // API key identity, MQTT client, schedule or rule name
+}
+
+// CommandRecord describes the outcome of a command, as reported to the command listeners.
+type CommandRecord struct {
+	Command  string
+	Origin   Origin
+	Priority string
+	Enqueued time.Time
+	Started  time.Time
+	Duration time.Duration
+	Outcome  string
+}
+
+// State is the believed state of the unit. The unit does not report its state, so this is derived from
+// the commands sent to it, and the mode is persisted across restarts.
+type State struct {
+	Mode        string             `json:"mode"`
+	Since       time.Time          `json:"since"`
+	BoostUntil  time.Time          `json:"boost_until,omitempty"`
+	BaseMode    string             `json:"base_mode"`              // Mode the unit returns to after a boost
+	Percent     float64            `json:"percent,omitempty"`      // Level of the analog output, if configured
+	Readings    map[string]float64 `json:"readings,omitempty"`     // Values read back from the unit, if configured
+	Inputs      map[string]bool    `json:"inputs,omitempty"`       // Levels of the inputs, if configured
+	External    bool               `json:"externally_changed"`     // The mode was last changed outside of the service
+	Fault       string             `json:"fault,omitempty"`        // Why the unit does not follow the commands, if so
+	Lockout     string             `json:"lockout,omitempty"`      // Why the safety lockout tripped, if so
+	ServiceMode bool               `json:"service_mode,omitempty"` // Commands of automations are suppressed
+}
+
+// Command request as it waits in the queue.
+type request struct {
+	id       uint64
+	command  string
+	adhoc    *config.CommandConfig // Command that is not configured, such as a percentage
+	origin   Origin
+	priority string
+	enqueued time.Time
+	forced   bool // Forced by the safety lockout
+}
+
 // VentilationControllerService implements the service for controlling the ventilation and reporting its state.
 type VentilationControllerService struct {
-	command chan Enum
-	adapter gpio.GPIOAdapter
-	wg      sync.WaitGroup
-	lock    sync.RWMutex
-	backoff time.Duration
+	queue           []request     // Pending requests, in the order they were queued
+	queued          chan struct{} // Signals a new request to the command loop
+	aging           time.Duration // Time after which a queued request is raised one priority class
+	lastID          uint64        // Id of the last queued request
+	busyUntil       time.Time     // Estimated end of the command being executed and its backoff
+	queueListeners  []func([]QueuedCommand)
+	adapter         gpio.GPIOAdapter
+	commands        map[string]config.CommandConfig
+	holdOutputs     []string
+	breakMake       time.Duration
+	analog          config.AnalogConfig
+	hasAnalog       bool
+	clock           clock
+	poll            time.Duration
+	done            chan struct{}
+	ctx             context.Context // Context of the command execution, canceled when stopping is overdue
+	cancel          context.CancelFunc
+	wake            chan struct{} // Signals a critical request to the command loop in the backoff
+	aborted         []string      // Commands aborted while stopping
+	wg              sync.WaitGroup
+	lock            sync.RWMutex
+	listeners       []func(CommandRecord)
+	state           State
+	boostTimer      *time.Timer
+	stateListeners  []func(State)
+	inputs          gpio.InputAdapter
+	inputConfig     map[string]config.InputConfig
+	executing       bool      // A command is being executed, so input changes are its own
+	executed        time.Time // End of the last command
+	inputListeners  []func(gpio.InputEvent)
+	feedbackTimeout time.Duration
+	feedbackRetries int
+	faults          map[string]string    // Reasons of the current faults, by kind
+	health          time.Duration        // Interval of the health checks
+	maxPulse        time.Duration        // Longest time an output may be active, except for held outputs
+	pulses          map[string]time.Time // Outputs that may be active, with the time they were activated
+	lockout         config.LockoutConfig
+	lockoutPath     string
+	modePath        string
+	tripped         LockoutStatus
+	interrupt       context.CancelFunc // Interrupts the command in progress
+	service         ServiceMode
+	serviceTimer    *time.Timer
+	rawActive       map[string]bool // Outputs made active directly in service mode
 }
 
 // GetVentilationControllerService returns the one and only VentilationControllerServiceImpl instance.
@@ -56,11 +183,37 @@ func GetVentilationControllerService() *VentilationControllerService {
 
 // Creates a new VentilationControllerServiceImpl object.
 func newVentilationControllerService() *VentilationControllerService {
+	commands, err := config.GetCommands()
+	if err != nil {
+		panic(err)
+	}
+	analog, hasAnalog := config.GetAnalog()
+	modbus, _ := config.GetModbus()
 	return &VentilationControllerService{
-		command: nil,
-		adapter: gpio.GetGPIOAdapter(),
-		wg:      sync.WaitGroup{},
-		backoff: time.Duration(config.GetGPIOBackoff()) * time.Millisecond,
+		adapter:         gpio.GetGPIOAdapter(),
+		commands:        commands,
+		holdOutputs:     holdOutputs(commands),
+		breakMake:       time.Duration(config.GetGPIOBreakBeforeMake()) * time.Millisecond,
+		analog:          analog,
+		hasAnalog:       hasAnalog,
+		clock:           realClock{},
+		poll:            modbus.Poll,
+		inputs:          gpio.GetInputAdapter(),
+		inputConfig:     config.GetGPIOInputs(),
+		feedbackTimeout: config.GetGPIOFeedbackTimeout(),
+		feedbackRetries: config.GetGPIOFeedbackRetries(),
+		health:          healthInterval,
+		aging:           config.GetQueueAging(),
+		maxPulse:        config.GetGPIOMaxPulse(),
+		lockout:         config.GetLockout(),
+		lockoutPath:     filepath.Join(config.GetDataDir(), "lockout.json"),
+		modePath:        filepath.Join(config.GetDataDir(), "mode.json"),
+		wg:              sync.WaitGroup{},
+		state: State{
+			Mode:     ModeUnknown,
+			Since:    time.Now(),
+			BaseMode: ModeUnknown,
+		},
 	}
 }
 
@@ -69,79 +222,555 @@ func (d *VentilationControllerService) commandLoop() {
 	defer d.wg.Done()
 
 	for {
-		cmd, ok := <-d.command
+		req, ok := d.next()
 		if !ok {
-			log.Info().Msg("command channel closed")
 			break
 		}
-		switch cmd {
-		case CmdSpeed1:
-			d.toggle(d.adapter.WriteSpeed1Pin)
-		case CmdSpeed2:
-			d.toggle(d.adapter.WriteSpeed2Pin)
-		case CmdSpeed3:
-			d.toggle(d.adapter.WriteSpeed3Pin)
-		case CmdAway:
-			d.toggle(d.adapter.WriteAwayPin)
-		case CmdAuto:
-			d.toggle(d.adapter.WriteAutoPin)
-		case CmdTimer15:
-			d.toggleX(d.adapter.WriteTimerPin, 1)
-		case CmdTimer30:
-			d.toggleX(d.adapter.WriteTimerPin, 2)
-		case CmdTimer60:
-			d.toggleX(d.adapter.WriteTimerPin, 3)
-		default:
-			log.Warn().Msgf("unknown command: %v", d.command)
+		d.lock.Lock()
+		locked := d.tripped.Tripped && !req.forced
+		ctx, interrupt := context.WithCancel(d.ctx)
+		d.interrupt = interrupt
+		d.lock.Unlock()
+		if locked {
+			interrupt()
+			d.locked(req)
+			continue
 		}
+		if d.suppress(req) {
+			interrupt()
+			continue
+		}
+
+		command := d.requestCommand(req)
+		started := d.clock.Now()
+		d.setBusy(started.Add(d.commandDuration(command) + command.Backoff))
+		outcome := d.executeRequest(ctx, req.command, command)
+		interrupt()
+		if outcome == OutcomeAborted && d.ctx.Err() == nil {
+			log.Warn().Msgf("Command %s interrupted by the safety lockout", req.command)
+			d.notify(req, started, d.clock.Now().Sub(started), outcome)
+			continue
+		}
+		if outcome == OutcomeAborted {
+			d.abort(req, started, d.clock.Now().Sub(started))
+			continue
+		}
+		d.notify(req, started, d.clock.Now().Sub(started), outcome)
+		if outcome == OutcomeExecuted {
+			d.updateState(command)
+		}
+		d.setBusy(d.clock.Now().Add(command.Backoff))
+		d.backoff(command.Backoff)
+		d.setBusy(time.Time{})
 	}
 
+	// Commands still queued when the service stops are not started.
+	d.lock.Lock()
+	pending := d.queue
+	d.queue = nil
+	d.lock.Unlock()
+	for _, req := range pending {
+		d.abort(req, time.Time{}, 0)
+	}
 	log.Info().Msg("commandLoop exiting")
 }
 
-func (d *VentilationControllerService) toggle(pinHandle func(bool)) {
-	pinHandle(true)
-	time.Sleep(reactTime)
-	pinHandle(false)
-	time.Sleep(d.backoff)
+// Executes a command from the queue. A panic releases all outputs and fails the command, instead of
+// leaving a contact closed.
+func (d *VentilationControllerService) executeRequest(ctx context.Context, name string, command config.CommandConfig) (outcome string) {
+	d.setExecuting(true)
+	defer d.setExecuting(false)
+	defer d.recoverCommand(&outcome)
+	return d.executeVerified(ctx, name, command)
 }
 
-func (d *VentilationControllerService) toggleX(pinHandle func(bool), times int) {
-	for i := 0; i < times; i++ {
-		pinHandle(true)
-		time.Sleep(reactTime)
-		pinHandle(false)
-		time.Sleep(reactTime)
+// Reports a command that was aborted, or not started, because the service stopped.
+func (d *VentilationControllerService) abort(req request, started time.Time, duration time.Duration) {
+	log.Warn().Msgf("Command %s aborted", req.command)
+	d.lock.Lock()
+	d.aborted = append(d.aborted, req.command)
+	d.lock.Unlock()
+	d.notify(req, started, duration, OutcomeAborted)
+}
+
+// Waits for the backoff after a command. The backoff is cut short by a critical request, and when the
+// service stops.
+func (d *VentilationControllerService) backoff(duration time.Duration) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	d.lock.RLock()
+	wake, done := d.wake, d.done
+	d.lock.RUnlock()
+	select {
+	case <-wake: // Left by a critical request that was served already
+	default:
+	}
+	if d.urgentQueued() {
+		return
 	}
-	time.Sleep(d.backoff - reactTime)
+
+	// The goroutine ends with the backoff, as the context is canceled on return.
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		select {
+		case <-wake:
+		case <-done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	d.clock.Sleep(ctx, duration)
 }
 
-// Start all goroutines.
-func (d *VentilationControllerService) Start() {
+// Returns the outputs held by any of the commands, sorted.
+func holdOutputs(commands map[string]config.CommandConfig) []string {
+	held := make(map[string]bool)
+	for _, command := range commands {
+		if command.Hold != "" {
+			held[command.Hold] = true
+		}
+	}
+	outputs := make([]string, 0, len(held))
+	for output := range held {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// Execute a command: switch the held output, if any, then run the steps. Stops when the context is
+// done, returning its error.
+func (d *VentilationControllerService) execute(ctx context.Context, command config.CommandConfig) error {
+	if command.Hold != "" {
+		if err := d.hold(ctx, command.Hold); err != nil {
+			return err
+		}
+	}
+	if command.Analog {
+		if err := d.ramp(ctx, command.Percent); err != nil {
+			return err
+		}
+	}
+	return d.run(ctx, command.Steps)
+}
+
+// Move the analog output to a percentage, no faster than the configured ramp.
+func (d *VentilationControllerService) ramp(ctx context.Context, percent float64) error {
+	adapter, ok := d.adapter.(gpio.AnalogAdapter)
+	if !ok || !d.hasAnalog {
+		return fmt.Errorf("no analog output configured")
+	}
+	target := d.analog.Clamp(percent)
+	d.lock.RLock()
+	current := d.state.Percent
+	d.lock.RUnlock()
+
+	for current != target {
+		next := target
+		if limit := d.analog.Ramp * rampInterval.Seconds(); limit > 0 {
+			if next > current+limit {
+				next = current + limit
+			} else if next < current-limit {
+				next = current - limit
+			}
+		}
+		if err := adapter.WritePercent(ctx, d.analog.Output, next); err != nil {
+			d.notifyState()
+			return err
+		}
+		d.lock.Lock()
+		d.state.Percent = next
+		d.lock.Unlock()
+		current = next
+		if current != target {
+			if err := d.clock.Sleep(ctx, rampInterval); err != nil {
+				d.notifyState()
+				return err
+			}
+		}
+	}
+	d.notifyState()
+	return nil
+}
+
+// Hold an output active, with break-before-make: all other held outputs are made inactive first, so
+// two switch positions are never active at the same time.
+func (d *VentilationControllerService) hold(ctx context.Context, output string) error {
+	for _, other := range d.holdOutputs {
+		if other != output {
+			if err := d.write(ctx, other, false); err != nil {
+				return err
+			}
+		}
+	}
+	if err := d.clock.Sleep(ctx, d.breakMake); err != nil {
+		return err
+	}
+	return d.write(ctx, output, true)
+}
+
+// Make all outputs inactive, which leaves the unit under control of its own switch or remote. This is
+// not canceled, as it runs when commands are aborted.
+func (d *VentilationControllerService) release() {
+	for _, output := range d.adapter.Outputs() {
+		d.write(context.Background(), output, false)
+	}
+	d.lock.Lock()
+	d.state.Percent = 0
+	d.lock.Unlock()
+}
+
+// Execute the steps of a command, up to the first step that fails. When the context is done during a
+// step, the outputs of the steps are made inactive.
+func (d *VentilationControllerService) run(ctx context.Context, steps []config.StepConfig) error {
+	for _, step := range steps {
+		if err := d.write(ctx, step.Output, step.Level); err != nil {
+			return err
+		}
+		if step.Duration <= 0 {
+			continue
+		}
+		if err := d.clock.Sleep(ctx, step.Duration); err != nil {
+			released := make(map[string]bool)
+			for _, step := range steps {
+				if !released[step.Output] {
+					d.write(context.Background(), step.Output, false)
+					released[step.Output] = true
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write an output, and track it for the watchdog. A write to a device stops retrying when the context is
+// done.
+func (d *VentilationControllerService) write(ctx context.Context, output string, value bool) error {
+	err := d.adapter.WriteOutput(ctx, output, value)
+	if err != nil {
+		log.Error().Msgf("%v", err)
+	}
+	d.track(output, value, err)
+	return err
+}
+
+// Update the believed state of the unit after a command was executed.
+func (d *VentilationControllerService) updateState(command config.CommandConfig) {
+	d.setMode(command.Mode, command.Boost, false)
+}
+
+// Sets the believed mode of the unit. A boost without duration, as reported by a status LED, lasts
+// until another mode is reported.
+func (d *VentilationControllerService) setMode(mode string, boost time.Duration, external bool) {
+	if mode == "" {
+		return
+	}
+
+	d.lock.Lock()
+	now := d.clock.Now()
+	if d.boostTimer != nil {
+		d.boostTimer.Stop()
+		d.boostTimer = nil
+	}
+	switch {
+	case mode == ModeBoost && boost > 0:
+		d.state.BoostUntil = now.Add(boost)
+		d.boostTimer = time.AfterFunc(boost, d.endBoost)
+	case mode == ModeBoost:
+		d.state.BoostUntil = time.Time{}
+	default:
+		d.state.BaseMode = mode
+		d.state.BoostUntil = time.Time{}
+	}
+	d.state.Mode = mode
+	d.state.Since = now
+	d.state.External = external
+	state := d.state
+	d.lock.Unlock()
+
+	d.saveMode(state)
+	d.notifyState()
+}
+
+// Return to the base mode when a boost timer expires.
+func (d *VentilationControllerService) endBoost() {
+	d.lock.Lock()
+	if d.state.Mode != ModeBoost || d.clock.Now().Before(d.state.BoostUntil) {
+		d.lock.Unlock()
+		return
+	}
+	d.state.Mode = d.state.BaseMode
+	d.state.Since = d.clock.Now()
+	d.state.BoostUntil = time.Time{}
+	d.boostTimer = nil
+	d.lock.Unlock()
+
+	d.notifyState()
+}
+
+// Loop reading values back from the unit, until the service is stopped.
+func (d *VentilationControllerService) pollLoop(adapter gpio.ReadingsAdapter) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.poll)
+	defer ticker.Stop()
+	for {
+		d.updateReadings(adapter)
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reads the values from the unit into the state. Values that could not be read are left out.
+func (d *VentilationControllerService) updateReadings(adapter gpio.ReadingsAdapter) {
+	readings, err := adapter.Readings()
+	if err != nil {
+		log.Error().Msgf("Failed to read values from the unit: %v", err)
+	}
+	d.lock.Lock()
+	d.state.Readings = readings
+	d.lock.Unlock()
+
+	d.notifyState()
+}
+
+// Report the current state to all registered state listeners.
+func (d *VentilationControllerService) notifyState() {
+	d.lock.RLock()
+	listeners := d.stateListeners
+	state := d.state
+	d.lock.RUnlock()
+
+	for _, listener := range listeners {
+		listener(state)
+	}
+}
+
+// GetState returns the believed state of the unit.
+func (d *VentilationControllerService) GetState() State {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.state
+}
+
+// AddStateListener registers a function that is called whenever the believed state of the unit changes.
+// Listeners should not block.
+func (d *VentilationControllerService) AddStateListener(listener func(State)) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
+	d.stateListeners = append(d.stateListeners, listener)
+}
+
+// Report the outcome of a command to all registered listeners.
+func (d *VentilationControllerService) notify(req request, started time.Time, duration time.Duration, outcome string) {
+	d.lock.RLock()
+	listeners := d.listeners
+	d.lock.RUnlock()
+
+	record := CommandRecord{
+		Command:  req.command,
+		Origin:   req.origin,
+		Priority: req.priority,
+		Enqueued: req.enqueued,
+		Started:  started,
+		Duration: duration,
+		Outcome:  outcome,
+	}
+	for _, listener := range listeners {
+		listener(record)
+	}
+}
+
+// AddCommandListener registers a function that is called with the outcome of every command.
+// Listeners are called from the command loop, and should not block.
+func (d *VentilationControllerService) AddCommandListener(listener func(CommandRecord)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.listeners = append(d.listeners, listener)
+}
+
+// Start all goroutines. All outputs are made inactive first, whatever state a previous run left them in.
+// The believed mode is restored, unless the inputs report it.
+func (d *VentilationControllerService) Start() {
+	d.release()
+	health, checked := d.adapter.(gpio.HealthAdapter)
+	if checked {
+		d.checkHealth(health)
+	}
 
-	d.command = make(chan Enum, queueSize)
+	d.lock.Lock()
+	restored := d.restoreMode()
+	d.done = make(chan struct{})
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.wake = make(chan struct{}, 1)
+	d.queued = make(chan struct{}, 1)
+	d.aborted = nil
 	go d.commandLoop()
 	d.wg.Add(1)
+
+	if d.maxPulse > 0 {
+		go d.watchdogLoop()
+		d.wg.Add(1)
+	}
+	if readings, ok := d.adapter.(gpio.ReadingsAdapter); ok && d.poll > 0 {
+		go d.pollLoop(readings)
+		d.wg.Add(1)
+	}
+	if checked {
+		go d.healthLoop(health)
+		d.wg.Add(1)
+	}
+	if d.inputs != nil {
+		d.readInputs()
+		go d.inputLoop()
+		d.wg.Add(1)
+	}
+	d.lock.Unlock()
+	if restored {
+		d.notifyState()
+	}
+	d.restoreLockout()
 }
 
-// Stop all goroutines, gracefully.
-func (d *VentilationControllerService) Stop() {
+// Stop all goroutines, cutting a backoff short. The command in progress may finish until the deadline
+// of the context; then it is aborted. Queued commands are not started. The inputs are closed, so no
+// events arrive during shutdown, and all outputs are made inactive. An error lists the commands that
+// were aborted.
+func (d *VentilationControllerService) Stop(ctx context.Context) error {
 	d.lock.Lock()
-	close(d.command)
+	close(d.done)
 	d.lock.Unlock()
 	log.Info().Msg("Stopping VentilationControllerService")
 
-	d.wg.Wait()
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn().Msg("Stopping is overdue, aborting the command in progress")
+		d.cancel()
+		<-stopped
+	}
+	d.cancel()
+	if closer, ok := d.inputs.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Error().Msgf("%v", err)
+		}
+	}
+	d.release()
 	log.Info().Msg("VentilationControllerService stopped")
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.aborted) > 0 {
+		return fmt.Errorf("aborted commands: %s", strings.Join(d.aborted, ", "))
+	}
+	return nil
+}
+
+// SetPercent queues a request to set the analog output to a percentage, clamped to the configured range.
+func (d *VentilationControllerService) SetPercent(percent float64, origin Origin) error {
+	if !d.hasAnalog {
+		return fmt.Errorf("no analog output configured")
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid percentage: %g", percent)
+	}
+	if err := d.Lockout(); err != nil {
+		return err
+	}
+	if err := d.Fault(); err != nil {
+		return err
+	}
+	command := config.CommandConfig{Analog: true, Percent: percent}
+	req := request{
+		command:  fmt.Sprintf("%s:%g", CmdPercent, percent),
+		adhoc:    &command,
+		origin:   origin,
+		priority: requestPriority(origin.Source, command),
+		enqueued: d.clock.Now(),
+	}
+	if d.suppress(req) {
+		return fmt.Errorf("%w: %s suppressed", ErrServiceMode, req.command)
+	}
+	d.enqueue(req)
+	return nil
+}
+
+// HasAnalog returns whether the unit's speed can be set as a percentage.
+func (d *VentilationControllerService) HasAnalog() bool {
+	return d.hasAnalog
+}
+
+// Commands returns the commands that can be sent to the unit, sorted by name.
+func (d *VentilationControllerService) Commands() []Command {
+	commands := make([]Command, 0, len(d.commands))
+	for name, command := range d.commands {
+		commands = append(commands, Command{Name: name, Label: command.Label, Mode: command.Mode})
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// SendCommand queues a command for execution. Commands are served by priority class, which is taken from
+// the source and raised by the priority of the command; within a class, in the order they were queued.
+// When the queue is full, the oldest command of the lowest class is dropped. An error is returned for
+// unknown commands, while the safety lockout is tripped (wrapping ErrLockout), for commands of automations
+// in service mode (wrapping ErrServiceMode) and while the outputs cannot be written (wrapping ErrFault).
+func (d *VentilationControllerService) SendCommand(command string, origin Origin) error {
+	configured, ok := d.commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	if err := d.Lockout(); err != nil {
+		return err
+	}
+	if err := d.Fault(); err != nil {
+		return err
+	}
+	req := request{
+		command:  command,
+		origin:   origin,
+		priority: requestPriority(origin.Source, configured),
+		enqueued: d.clock.Now(),
+	}
+	if d.suppress(req) {
+		return fmt.Errorf("%w: %s suppressed", ErrServiceMode, command)
+	}
+	d.enqueue(req)
+	return nil
+}
+
+// Queue a request, and wake the command loop. A critical request also cuts the backoff short.
+func (d *VentilationControllerService) enqueue(req request) {
+	d.lock.Lock()
+	d.lastID++
+	req.id = d.lastID
+	dropped, full := d.push(req, d.clock.Now())
+	d.lock.Unlock()
+	if full {
+		log.Warn().Msgf("Command queue full, dropped %s", dropped.command)
+		d.notify(dropped, time.Time{}, 0, OutcomeDropped)
+	}
+	d.notifyQueue()
+	signal(d.queued)
+	if req.priority == config.PriorityCritical {
+		signal(d.wake)
+	}
 }
 
-func (d *VentilationControllerService) SendCommand(command Enum) {
+// Signals a channel, unless a signal is pending already.
+func signal(c chan struct{}) {
 	select {
-	case d.command <- command:
+	case c <- struct{}{}:
 	default:
-		<-d.command
-		d.command <- command
 	}
 }
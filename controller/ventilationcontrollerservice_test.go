@@ -1,17 +1,72 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+	"github.com/dlefevre/go.ventilation-service/internal/testenv"
 )
 
+var testOrigin = Origin{Source: SourceWeb, Identity: "test"}
+
+// Clock that calls a function instead of sleeping, which lets tests observe and skip the waits.
+type sleepFunc func(time.Duration)
+
+func (f sleepFunc) Now() time.Time {
+	return time.Now()
+}
+
+func (f sleepFunc) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f(d)
+	return nil
+}
+
+// Clock that only moves when a test advances it, or when the controller sleeps.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.advance(d)
+	return nil
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
 func init() {
 	// Set the environment variable for the configuration path
 	os.Setenv("VENTILATIONSERVICE_CONFIG_PATH", "..")
 	//zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(testenv.Run(m))
+}
+
 func TestCreateVentilationController(t *testing.T) {
 	controller := GetVentilationControllerService()
 	if controller == nil {
@@ -23,50 +78,258 @@ func TestStartStop(t *testing.T) {
 	controller := GetVentilationControllerService()
 	controller.Start()
 	time.Sleep(1 * time.Second)
-	controller.Stop()
+	controller.Stop(context.Background())
 }
 
 func TestRestart(t *testing.T) {
 	controller := GetVentilationControllerService()
 	controller.Start()
 	time.Sleep(1 * time.Second)
-	controller.Stop()
+	controller.Stop(context.Background())
 	controller.Start()
 	time.Sleep(1 * time.Second)
-	controller.Stop()
+	controller.Stop(context.Background())
 }
 
 func TestSpeedToggles(t *testing.T) {
 	controller := GetVentilationControllerService()
 	controller.Start()
 
-	controller.SendCommand(CmdSpeed1)
-	controller.SendCommand(CmdSpeed2)
-	controller.SendCommand(CmdSpeed3)
+	controller.SendCommand(CmdSpeed1, testOrigin)
+	controller.SendCommand(CmdSpeed2, testOrigin)
+	controller.SendCommand(CmdSpeed3, testOrigin)
 	time.Sleep(10 * time.Second)
 
-	controller.Stop()
+	controller.Stop(context.Background())
 }
 
 func TestOtherToggles(t *testing.T) {
 	controller := GetVentilationControllerService()
 	controller.Start()
 
-	controller.SendCommand(CmdAway)
-	controller.SendCommand(CmdAuto)
+	controller.SendCommand(CmdAway, testOrigin)
+	controller.SendCommand(CmdAuto, testOrigin)
 	time.Sleep(10 * time.Second)
 
-	controller.Stop()
+	controller.Stop(context.Background())
 }
 
 func TestTimerToggles(t *testing.T) {
 	controller := GetVentilationControllerService()
 	controller.Start()
 
-	controller.SendCommand(CmdTimer15)
-	controller.SendCommand(CmdTimer30)
-	controller.SendCommand(CmdTimer60)
+	controller.SendCommand(CmdTimer15, testOrigin)
+	controller.SendCommand(CmdTimer30, testOrigin)
+	controller.SendCommand(CmdTimer60, testOrigin)
 	time.Sleep(10 * time.Second)
 
-	controller.Stop()
+	controller.Stop(context.Background())
+}
+
+func TestCommandListener(t *testing.T) {
+	controller := GetVentilationControllerService()
+	records := make(chan CommandRecord, 1)
+	controller.AddCommandListener(func(record CommandRecord) {
+		select {
+		case records <- record:
+		default:
+		}
+	})
+	controller.Start()
+	defer controller.Stop(context.Background())
+
+	controller.SendCommand(CmdAway, testOrigin)
+	select {
+	case record := <-records:
+		if record.Command != CmdAway || record.Origin != testOrigin || record.Outcome != OutcomeExecuted {
+			t.Fatalf("Unexpected command record: %+v", record)
+		}
+		if record.Duration < 100*time.Millisecond {
+			t.Fatalf("Expected duration of at least 100ms, got %v", record.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected command listener to be called")
+	}
+}
+
+func TestStateTracking(t *testing.T) {
+	controller := newVentilationControllerService()
+	states := []State{}
+	controller.AddStateListener(func(state State) {
+		states = append(states, state)
+	})
+
+	controller.updateState(controller.commands[CmdSpeed2])
+	controller.updateState(controller.commands[CmdTimer30])
+	if state := controller.GetState(); state.Mode != ModeBoost || state.BaseMode != ModeSpeed2 {
+		t.Fatalf("Expected boost on top of speed2, got %+v", state)
+	}
+
+	// Let the boost expire.
+	controller.lock.Lock()
+	controller.state.BoostUntil = time.Now()
+	controller.lock.Unlock()
+	controller.endBoost()
+	if state := controller.GetState(); state.Mode != ModeSpeed2 || !state.BoostUntil.IsZero() {
+		t.Fatalf("Expected to return to speed2 after the boost, got %+v", state)
+	}
+	if len(states) != 3 {
+		t.Fatalf("Expected 3 state changes, got %d", len(states))
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	controller := newVentilationControllerService()
+	if err := controller.SendCommand("turbo", testOrigin); err == nil {
+		t.Fatalf("Expected an error for an unknown command")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	controller := newVentilationControllerService()
+	commands := controller.Commands()
+	if len(commands) != 8 {
+		t.Fatalf("Expected the 8 default commands, got %+v", commands)
+	}
+	if commands[0].Name != CmdAuto || commands[0].Mode != ModeAuto {
+		t.Fatalf("Expected commands sorted by name, got %+v", commands)
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	controller := newVentilationControllerService()
+	slept := time.Duration(0)
+	controller.clock = sleepFunc(func(d time.Duration) { slept += d })
+	controller.run(context.Background(), controller.commands[CmdTimer30].Steps)
+	if slept != 300*time.Millisecond {
+		t.Fatalf("Expected two 100ms pulses with a 100ms gap, slept %v", slept)
+	}
+}
+
+func TestHoldBreakBeforeMake(t *testing.T) {
+	controller := newVentilationControllerService()
+	adapter := gpio.NewGPIOMockAdapter()
+	controller.adapter = adapter
+	controller.commands = config.LevelCommands(controller.commands)
+	controller.holdOutputs = holdOutputs(controller.commands)
+	controller.clock = sleepFunc(func(time.Duration) {
+		for name, value := range adapter.Values() {
+			if value {
+				t.Fatalf("Expected all outputs inactive during break-before-make, %s is active", name)
+			}
+		}
+	})
+
+	controller.execute(context.Background(), controller.commands[CmdSpeed1])
+	controller.execute(context.Background(), controller.commands[CmdSpeed3])
+	if values := adapter.Values(); !values["speed_3"] || values["speed_1"] {
+		t.Fatalf("Expected only speed_3 to be held, got %v", values)
+	}
+	controller.release()
+	if values := adapter.Values(); values["speed_3"] {
+		t.Fatalf("Expected all outputs inactive after release, got %v", values)
+	}
+}
+
+// Adapter that records the levels written to analog outputs.
+type percentAdapter struct {
+	timelineAdapter
+	levels []float64
+}
+
+func (a *percentAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	a.levels = append(a.levels, percent)
+	return nil
+}
+
+func TestRamp(t *testing.T) {
+	adapter := &percentAdapter{}
+	controller := &VentilationControllerService{
+		adapter:   adapter,
+		clock:     sleepFunc(adapter.sleep),
+		hasAnalog: true,
+		analog:    config.AnalogConfig{Output: "fan", Min: 10, Max: 80, Ramp: 50},
+	}
+
+	controller.ramp(context.Background(), 30)
+	if fmt.Sprint(adapter.levels) != "[5 10 15 20 25 30]" || adapter.now != 500*time.Millisecond {
+		t.Fatalf("Expected a ramp of 5%% per 100ms, got %v in %v", adapter.levels, adapter.now)
+	}
+	adapter.levels = nil
+	controller.ramp(context.Background(), 95)
+	if last := adapter.levels[len(adapter.levels)-1]; last != 80 || controller.GetState().Percent != 80 {
+		t.Fatalf("Expected the percentage to be clamped to 80, got %v", last)
+	}
+}
+
+func TestSetPercentWithoutAnalog(t *testing.T) {
+	controller := newVentilationControllerService()
+	if err := controller.SetPercent(42, testOrigin); err == nil {
+		t.Fatalf("Expected an error without analog output")
+	}
+}
+
+// Adapter that reads back fixed values.
+type readingsAdapter struct {
+	gpio.GPIOAdapter
+}
+
+func (a *readingsAdapter) Readings() (map[string]float64, error) {
+	return map[string]float64{"fan_rpm": 1380}, nil
+}
+
+func TestReadings(t *testing.T) {
+	controller := newVentilationControllerService()
+	controller.adapter = &readingsAdapter{gpio.NewGPIOMockAdapter()}
+	controller.poll = time.Hour
+	states := make(chan State, 1)
+	controller.AddStateListener(func(state State) {
+		states <- state
+	})
+
+	controller.Start()
+	defer controller.Stop(context.Background())
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case state := <-states:
+			// A restored mode is reported before the readings.
+			if state.Readings == nil {
+				continue
+			}
+			if state.Readings["fan_rpm"] != 1380 {
+				t.Fatalf("Expected the readings in the state, got %v", state.Readings)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("Expected the readings to be polled on start")
+		}
+	}
+}
+
+func TestModePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mode.json")
+	controller := newVentilationControllerService()
+	controller.modePath = path
+	controller.setMode(ModeSpeed3, 0, false)
+	since := controller.GetState().Since
+	controller.setMode(ModeBoost, 10*time.Minute, false)
+
+	restarted := newVentilationControllerService()
+	restarted.modePath = path
+	restarted.Start()
+	defer restarted.Stop(context.Background())
+	if state := restarted.GetState(); state.Mode != ModeSpeed3 || state.BaseMode != ModeSpeed3 || !state.Since.Equal(since) {
+		t.Fatalf("Expected the base mode to survive a restart, got %+v", state)
+	}
+
+	// With level control, the held outputs are released on start, so the mode is not restored.
+	level := newVentilationControllerService()
+	level.modePath = path
+	level.holdOutputs = []string{"speed_3"}
+	level.Start()
+	defer level.Stop(context.Background())
+	if state := level.GetState(); state.Mode != ModeUnknown {
+		t.Fatalf("Expected the mode not to be restored with level control, got %+v", state)
+	}
 }
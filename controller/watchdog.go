@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Number of watchdog checks per maximum pulse length.
+const watchdogChecks = 4
+
+// Records a write to an output for the watchdog. Outputs that may be active, including outputs that
+// could not be written, are tracked from the moment they were first activated until they are inactive.
+func (d *VentilationControllerService) track(output string, value bool, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !value && err == nil {
+		delete(d.pulses, output)
+		return
+	}
+	if d.pulses == nil {
+		d.pulses = make(map[string]time.Time)
+	}
+	if _, ok := d.pulses[output]; !ok {
+		d.pulses[output] = d.clock.Now()
+	}
+}
+
+// Forces outputs that have been active longer than the maximum pulse length back to inactive. Held
+// outputs, in level control, are meant to stay active and are left alone.
+func (d *VentilationControllerService) checkPulses(now time.Time) {
+	d.lock.RLock()
+	stuck := []string{}
+	for output, since := range d.pulses {
+		if now.Sub(since) > d.maxPulse && !contains(d.holdOutputs, output) {
+			stuck = append(stuck, output)
+		}
+	}
+	d.lock.RUnlock()
+
+	for _, output := range stuck {
+		log.Error().Msgf("Output %s active for longer than %v, forcing it inactive", output, d.maxPulse)
+		d.write(context.Background(), output, false)
+	}
+}
+
+// Loop running the watchdog, until the service is stopped.
+func (d *VentilationControllerService) watchdogLoop() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.maxPulse / watchdogChecks)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			d.checkPulses(now)
+		}
+	}
+}
+
+// Makes all outputs inactive after a panic while executing a command, so no contact is left closed,
+// and reports the command as failed.
+func (d *VentilationControllerService) recoverCommand(outcome *string) {
+	if r := recover(); r != nil {
+		log.Error().Msgf("Panic while executing a command, releasing all outputs: %v\n%s", r, debug.Stack())
+		d.release()
+		*outcome = OutcomeFailed
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
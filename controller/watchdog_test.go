@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/gpio"
+)
+
+func TestWatchdog(t *testing.T) {
+	adapter := gpio.NewGPIOMockAdapter()
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	controller.holdOutputs = []string{"speed_1"}
+	controller.maxPulse = 40 * time.Millisecond
+
+	controller.Start()
+	defer controller.Stop(context.Background())
+	controller.write(context.Background(), "timer", true)
+	controller.write(context.Background(), "speed_1", true)
+	for i := 0; i < 100 && adapter.Values()["timer"]; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if values := adapter.Values(); values["timer"] || !values["speed_1"] {
+		t.Fatalf("Expected the pulse to be ended and the held output to stay active, got %v", values)
+	}
+}
+
+// Adapter that panics once, when an output is made inactive.
+type panicAdapter struct {
+	*gpio.GPIOMockAdapter
+	panicked bool
+}
+
+func (a *panicAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	if !value && !a.panicked {
+		a.panicked = true
+		panic("output driver crashed")
+	}
+	return a.GPIOMockAdapter.WriteOutput(ctx, name, value)
+}
+
+func TestPanicReleasesOutputs(t *testing.T) {
+	adapter := &panicAdapter{GPIOMockAdapter: gpio.NewGPIOMockAdapter()}
+	controller := newVentilationControllerService()
+	controller.adapter = adapter
+	controller.clock = sleepFunc(func(time.Duration) {})
+
+	if outcome := controller.executeRequest(context.Background(), CmdSpeed3, controller.commands[CmdSpeed3]); outcome != OutcomeFailed {
+		t.Fatalf("Expected the command to fail, got %s", outcome)
+	}
+	if values := adapter.Values(); values["speed_3"] {
+		t.Fatalf("Expected all outputs to be released, got %v", values)
+	}
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	controller := newVentilationControllerService()
+	controller.Start()
+	if err := controller.SendCommand(CmdSpeed1, testOrigin); err != nil {
+		t.Fatalf("Error sending command: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	started := time.Now()
+	controller.Stop(context.Background())
+	if stopped := time.Since(started); stopped > time.Second {
+		t.Fatalf("Expected Stop to cut the backoff short, took %v", stopped)
+	}
+}
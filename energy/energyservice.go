@@ -0,0 +1,279 @@
+package energy
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/persist"
+	"github.com/dlefevre/go.ventilation-service/stats"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	saveInterval  = time.Minute
+	retentionDays = 400
+)
+
+var (
+	instance *EnergyService
+	once     sync.Once
+)
+
+// Tariff describes the price of a kWh, with an optional night window. The window is expressed in minutes
+// after midnight, and may wrap around midnight.
+type Tariff struct {
+	Day        float64
+	Night      float64
+	HasNight   bool
+	NightStart int
+	NightEnd   int
+}
+
+// Day holds the estimated consumption of a single (local) day, per tariff window.
+type Day struct {
+	Date     string  `json:"date"`
+	KWhDay   float64 `json:"kwh_day"`
+	KWhNight float64 `json:"kwh_night"`
+	Cost     float64 `json:"cost"`
+}
+
+// Aggregate holds the estimated consumption and cost for a period.
+type Aggregate struct {
+	KWh      float64 `json:"kwh"`
+	KWhDay   float64 `json:"kwh_day"`
+	KWhNight float64 `json:"kwh_night"`
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency"`
+	TotalKWh float64 `json:"total_kwh"` // Since the service was first started
+	Days     []Day   `json:"days"`
+}
+
+// Persisted form of the counters.
+type snapshot struct {
+	TotalKWh float64         `json:"total_kwh"`
+	Days     map[string]*Day `json:"days"`
+}
+
+// EnergyService integrates the estimated energy consumption from the nominal power of each mode.
+type EnergyService struct {
+	path     string
+	watts    map[string]float64
+	tariff   Tariff
+	currency string
+	counters snapshot
+	mode     string
+	since    time.Time
+	lock     sync.Mutex
+	stop     chan bool
+	wg       sync.WaitGroup
+}
+
+// GetEnergyService returns the one and only EnergyService instance.
+func GetEnergyService() *EnergyService {
+	once.Do(func() {
+		tariff := Tariff{Day: config.GetEnergyDayTariff()}
+		tariff.Night, tariff.HasNight = config.GetEnergyNightTariff()
+		tariff.NightStart, tariff.NightEnd = config.GetEnergyNightWindow()
+		instance = newEnergyService(
+			filepath.Join(config.GetDataDir(), "energy.json"),
+			config.GetEnergyWatts(),
+			tariff,
+			config.GetEnergyCurrency(),
+		)
+		controller.GetVentilationControllerService().AddStateListener(instance.stateListener)
+	})
+	return instance
+}
+
+// Creates a new EnergyService object.
+func newEnergyService(path string, watts map[string]float64, tariff Tariff, currency string) *EnergyService {
+	return &EnergyService{
+		path:     path,
+		watts:    watts,
+		tariff:   tariff,
+		currency: currency,
+		counters: snapshot{Days: make(map[string]*Day)},
+		mode:     controller.ModeUnknown,
+		since:    time.Now(),
+	}
+}
+
+// Start loads the persisted counters, and starts saving them periodically.
+func (s *EnergyService) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := persist.Load(s.path, &s.counters); err != nil {
+		return err
+	}
+	if s.counters.Days == nil {
+		s.counters.Days = make(map[string]*Day)
+	}
+	s.since = time.Now()
+	s.stop = make(chan bool)
+	s.wg.Add(1)
+	go s.saveLoop(s.stop)
+	return nil
+}
+
+// Stop saves the counters and stops the periodic saving. Stopping a service that did not
+// start has no effect.
+func (s *EnergyService) Stop() {
+	s.lock.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.lock.Unlock()
+	if stop == nil {
+		return // Not started, so there is nothing to save
+	}
+	close(stop)
+	s.wg.Wait()
+	s.save()
+}
+
+func (s *EnergyService) saveLoop(stop chan bool) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.save()
+		}
+	}
+}
+
+func (s *EnergyService) save() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.accumulate(time.Now())
+	limit := time.Now().AddDate(0, 0, -retentionDays).Format(stats.DateFormat)
+	for date := range s.counters.Days {
+		if date < limit {
+			delete(s.counters.Days, date)
+		}
+	}
+	if err := persist.Save(s.path, s.counters); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+}
+
+// Returns whether a time falls in the night tariff window, and when the current window ends.
+func (t Tariff) window(at time.Time) (bool, time.Time) {
+	y, m, d := at.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, at.Location())
+	next := midnight.AddDate(0, 0, 1)
+	if !t.HasNight {
+		return false, next
+	}
+	minute := at.Hour()*60 + at.Minute()
+	start := midnight.Add(time.Duration(t.NightStart) * time.Minute)
+	end := midnight.Add(time.Duration(t.NightEnd) * time.Minute)
+
+	var night bool
+	if t.NightStart <= t.NightEnd {
+		night = minute >= t.NightStart && minute < t.NightEnd
+	} else {
+		night = minute >= t.NightStart || minute < t.NightEnd
+	}
+	// The window ends at the first boundary (start, end or midnight) after the given time.
+	for _, boundary := range []time.Time{start, end} {
+		if boundary.After(at) && boundary.Before(next) {
+			next = boundary
+		}
+	}
+	return night, next
+}
+
+// Returns the price of a kWh in the day or night window.
+func (t Tariff) price(night bool) float64 {
+	if night {
+		return t.Night
+	}
+	return t.Day
+}
+
+func (s *EnergyService) day(date string) *Day {
+	day, ok := s.counters.Days[date]
+	if !ok {
+		day = &Day{Date: date}
+		s.counters.Days[date] = day
+	}
+	return day
+}
+
+// Add the energy consumed in the current mode up to now, split over days and tariff windows.
+func (s *EnergyService) accumulate(now time.Time) {
+	watts := s.watts[s.mode]
+	for s.since.Before(now) {
+		night, end := s.tariff.window(s.since)
+		if end.After(now) {
+			end = now
+		}
+		kwh := watts * end.Sub(s.since).Hours() / 1000
+		day := s.day(s.since.Format(stats.DateFormat))
+		if night {
+			day.KWhNight += kwh
+		} else {
+			day.KWhDay += kwh
+		}
+		day.Cost += kwh * s.tariff.price(night)
+		s.counters.TotalKWh += kwh
+		s.since = end
+	}
+}
+
+func (s *EnergyService) stateListener(state controller.State) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	s.mode = state.Mode
+}
+
+// TotalKWh returns the estimated consumption since the service was first started.
+func (s *EnergyService) TotalKWh() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	return s.counters.TotalKWh
+}
+
+// Query returns the estimated consumption and cost for the current day, week or month.
+func (s *EnergyService) Query(period string) (Aggregate, error) {
+	now := time.Now()
+	from, to, err := stats.PeriodBounds(period, now)
+	if err != nil {
+		return Aggregate{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(now)
+
+	aggregate := Aggregate{
+		Currency: s.currency,
+		TotalKWh: s.counters.TotalKWh,
+		Days:     []Day{},
+	}
+	fromDate, toDate := from.Format(stats.DateFormat), to.Format(stats.DateFormat)
+	for date, day := range s.counters.Days {
+		if date < fromDate || date > toDate {
+			continue
+		}
+		aggregate.KWhDay += day.KWhDay
+		aggregate.KWhNight += day.KWhNight
+		aggregate.Cost += day.Cost
+		aggregate.Days = append(aggregate.Days, *day)
+	}
+	aggregate.KWh = aggregate.KWhDay + aggregate.KWhNight
+	sort.Slice(aggregate.Days, func(i, j int) bool { return aggregate.Days[i].Date < aggregate.Days[j].Date })
+	return aggregate, nil
+}
@@ -0,0 +1,111 @@
+package energy
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/stats"
+)
+
+func init() {
+	// Set the environment variable for the configuration path
+	os.Setenv("VENTILATIONSERVICE_CONFIG_PATH", "..")
+}
+
+var testTariff = Tariff{Day: 0.30, Night: 0.20, HasNight: true, NightStart: 22 * 60, NightEnd: 7 * 60}
+
+func newTestService(t *testing.T) *EnergyService {
+	return newEnergyService(filepath.Join(t.TempDir(), "energy.json"), map[string]float64{
+		controller.ModeSpeed2: 100,
+	}, testTariff, "EUR")
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTariffWindow(t *testing.T) {
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		at    time.Duration
+		night bool
+		next  time.Duration
+	}{
+		{at: 3 * time.Hour, night: true, next: 7 * time.Hour},
+		{at: 12 * time.Hour, night: false, next: 22 * time.Hour},
+		{at: 22 * time.Hour, night: true, next: 24 * time.Hour},
+	} {
+		night, next := testTariff.window(day.Add(tc.at))
+		if night != tc.night || !next.Equal(day.Add(tc.next)) {
+			t.Fatalf("At %v: expected night=%v until %v, got night=%v until %v", tc.at, tc.night, tc.next, night, next)
+		}
+	}
+
+	night, next := Tariff{Day: 0.3}.window(day.Add(23 * time.Hour))
+	if night || !next.Equal(day.AddDate(0, 0, 1)) {
+		t.Fatalf("Expected a single tariff to only split at midnight")
+	}
+}
+
+func TestAccumulate(t *testing.T) {
+	s := newTestService(t)
+	s.mode = controller.ModeSpeed2
+	s.since = time.Date(2025, 3, 1, 20, 0, 0, 0, time.Local)
+	s.accumulate(time.Date(2025, 3, 2, 8, 0, 0, 0, time.Local))
+
+	first, second := s.counters.Days["2025-03-01"], s.counters.Days["2025-03-02"]
+	// 20:00-22:00 day, 22:00-00:00 night, 00:00-07:00 night, 07:00-08:00 day, at 100 W.
+	if !almostEqual(first.KWhDay, 0.2) || !almostEqual(first.KWhNight, 0.2) {
+		t.Fatalf("Unexpected consumption on the first day: %+v", first)
+	}
+	if !almostEqual(second.KWhDay, 0.1) || !almostEqual(second.KWhNight, 0.7) {
+		t.Fatalf("Unexpected consumption on the second day: %+v", second)
+	}
+	if !almostEqual(first.Cost, 0.2*0.30+0.2*0.20) {
+		t.Fatalf("Unexpected cost on the first day: %v", first.Cost)
+	}
+	if !almostEqual(s.counters.TotalKWh, 1.2) {
+		t.Fatalf("Expected a total of 1.2 kWh, got %v", s.counters.TotalKWh)
+	}
+}
+
+func TestQueryAndPersistence(t *testing.T) {
+	s := newTestService(t)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting energy service: %v", err)
+	}
+	s.stateListener(controller.State{Mode: controller.ModeSpeed2})
+	s.lock.Lock()
+	s.since = s.since.Add(-time.Minute)
+	s.lock.Unlock()
+	s.Stop()
+
+	restored := newTestService(t)
+	restored.path = s.path
+	if err := restored.Start(); err != nil {
+		t.Fatalf("Error restarting energy service: %v", err)
+	}
+	defer restored.Stop()
+	month, err := restored.Query(stats.PeriodMonth)
+	if err != nil {
+		t.Fatalf("Error querying energy consumption: %v", err)
+	}
+	if month.TotalKWh <= 0 || month.Currency != "EUR" {
+		t.Fatalf("Expected the consumption to survive a restart, got %+v", month)
+	}
+	if _, err := restored.Query("year"); err == nil {
+		t.Fatalf("Expected an error for an unknown period")
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s := newTestService(t)
+	s.Stop()
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Fatalf("Expected a service that did not start not to save, got %v", err)
+	}
+}
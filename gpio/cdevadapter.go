@@ -0,0 +1,171 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Output line of a GPIO chip.
+type cdevOutput struct {
+	index     int // Index of the line in the request
+	activeLow bool
+}
+
+// CdevAdapter is an adapter for GPIO pins through the Linux GPIO character device, which works on
+// every board with a GPIO driver, including the Raspberry Pi 5. All outputs are requested at once,
+// and start inactive.
+type CdevAdapter struct {
+	lines   GPIOLines
+	outputs map[string]cdevOutput
+	lock    sync.Mutex
+}
+
+// NewCdevAdapter creates a new CdevAdapter for the given outputs, on the chip at a path. Lines are
+// identified by name (line) or by offset (pin).
+func NewCdevAdapter(path string, outputs map[string]config.OutputConfig) (*CdevAdapter, error) {
+	chip, err := openGPIOChip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+
+	adapter := &CdevAdapter{outputs: make(map[string]cdevOutput)}
+	names := outputNames(outputs)
+	offsets := make([]int, len(names))
+	values := make([]bool, len(names))
+	used := make(map[int]string)
+	for i, name := range names {
+		output := outputs[name]
+		offsets[i] = output.Pin
+		if output.Line != "" {
+			if offsets[i], err = chip.LineOffset(output.Line); err != nil {
+				return nil, err
+			}
+		}
+		if other, ok := used[offsets[i]]; ok {
+			return nil, fmt.Errorf("gpio: outputs %s and %s use the same line %d of %s", other, name, offsets[i], path)
+		}
+		used[offsets[i]] = name
+		values[i] = output.ActiveLow
+		adapter.outputs[name] = cdevOutput{index: i, activeLow: output.ActiveLow}
+	}
+	if adapter.lines, err = chip.RequestOutputs(offsets, values); err != nil {
+		return nil, err
+	}
+	return adapter, nil
+}
+
+// WriteOutput writes a value to an output.
+func (a *CdevAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	mask := uint64(1) << output.index
+	bits := uint64(0)
+	if value != output.activeLow {
+		bits = mask
+	}
+	return a.lines.SetValues(bits, mask)
+}
+
+// Outputs returns the names of all outputs.
+func (a *CdevAdapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
+
+// Close releases the lines.
+func (a *CdevAdapter) Close() error {
+	return a.lines.Close()
+}
+
+// Input line of a GPIO chip.
+type cdevInput struct {
+	line      GPIOInput
+	activeLow bool
+}
+
+// CdevInputAdapter is an adapter for input pins through the Linux GPIO character device. Debouncing
+// is done by the kernel.
+type CdevInputAdapter struct {
+	eventQueue
+	inputs map[string]cdevInput
+}
+
+// NewCdevInputAdapter creates a new CdevInputAdapter for the given inputs, on the chip at a path, and
+// starts watching them.
+func NewCdevInputAdapter(path string, inputs map[string]config.InputConfig) (*CdevInputAdapter, error) {
+	chip, err := openGPIOChip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+
+	adapter := &CdevInputAdapter{eventQueue: newEventQueue(), inputs: make(map[string]cdevInput)}
+	for _, name := range outputNames(inputs) {
+		input := inputs[name]
+		offset := input.Pin
+		if input.Line != "" {
+			offset, err = chip.LineOffset(input.Line)
+		}
+		var line GPIOInput
+		if err == nil {
+			line, err = chip.RequestInput(offset, input.Pull, input.Debounce)
+		}
+		if err != nil {
+			adapter.Close()
+			return nil, err
+		}
+		adapter.inputs[name] = cdevInput{line: line, activeLow: input.ActiveLow}
+	}
+	for name, input := range adapter.inputs {
+		go adapter.watch(name, input)
+	}
+	return adapter, nil
+}
+
+// Reports the changes of an input, until its line is closed.
+func (a *CdevInputAdapter) watch(name string, input cdevInput) {
+	active, _ := a.ReadInput(name)
+	for {
+		level, err := input.line.WaitEdge()
+		if err != nil {
+			log.Debug().Msgf("Stopped watching input %s: %v", name, err)
+			return
+		}
+		if level != input.activeLow != active {
+			active = !active
+			a.emit(name, active)
+		}
+	}
+}
+
+// ReadInput returns whether an input is active.
+func (a *CdevInputAdapter) ReadInput(name string) (bool, error) {
+	input, ok := a.inputs[name]
+	if !ok {
+		return false, unknownInputError(name)
+	}
+	level, err := input.line.Value()
+	return level != input.activeLow, err
+}
+
+// Inputs returns the names of all inputs.
+func (a *CdevInputAdapter) Inputs() []string {
+	return outputNames(a.inputs)
+}
+
+// Close releases the lines, which stops watching them.
+func (a *CdevInputAdapter) Close() error {
+	for _, input := range a.inputs {
+		input.line.Close()
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+// GPIO chip that keeps the levels of its lines, by offset.
+type fakeGPIOChip struct {
+	names  []string
+	levels map[int]bool
+	inputs map[int]*fakeGPIOInput
+	closed bool
+}
+
+// Line of a fakeGPIOChip requested as input, whose edges are sent by the test.
+type fakeGPIOInput struct {
+	pull     string
+	debounce time.Duration
+	level    bool
+	edges    chan bool
+}
+
+// Lines requested from a fakeGPIOChip.
+type fakeGPIOLines struct {
+	chip    *fakeGPIOChip
+	offsets []int
+	closed  bool
+}
+
+func (c *fakeGPIOChip) LineOffset(name string) (int, error) {
+	for offset, lineName := range c.names {
+		if lineName == name {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("no line named %s", name)
+}
+
+func (c *fakeGPIOChip) RequestOutputs(offsets []int, values []bool) (GPIOLines, error) {
+	for i, offset := range offsets {
+		if offset >= len(c.names) {
+			return nil, fmt.Errorf("invalid offset %d", offset)
+		}
+		c.levels[offset] = values[i]
+	}
+	return &fakeGPIOLines{chip: c, offsets: offsets}, nil
+}
+
+func (c *fakeGPIOChip) RequestInput(offset int, pull string, debounce time.Duration) (GPIOInput, error) {
+	if offset >= len(c.names) {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	// The line idles at the level of its pull resistor.
+	input := &fakeGPIOInput{pull: pull, debounce: debounce, level: pull == config.PullUp, edges: make(chan bool, 4)}
+	c.inputs[offset] = input
+	return input, nil
+}
+
+func (c *fakeGPIOChip) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (l *fakeGPIOLines) SetValues(bits uint64, mask uint64) error {
+	if l.closed {
+		return fmt.Errorf("lines released")
+	}
+	for i, offset := range l.offsets {
+		if mask&(1<<i) != 0 {
+			l.chip.levels[offset] = bits&(1<<i) != 0
+		}
+	}
+	return nil
+}
+
+func (l *fakeGPIOLines) Close() error {
+	l.closed = true
+	return nil
+}
+
+func (l *fakeGPIOInput) Value() (bool, error) {
+	return l.level, nil
+}
+
+func (l *fakeGPIOInput) WaitEdge() (bool, error) {
+	level, ok := <-l.edges
+	if !ok {
+		return false, fmt.Errorf("line released")
+	}
+	l.level = level
+	return level, nil
+}
+
+func (l *fakeGPIOInput) Close() error {
+	close(l.edges)
+	return nil
+}
+
+// Replaces the GPIO chips by a fake chip with 28 lines named GPIO0-GPIO27, for the duration of a test.
+func fakeGPIOChips(t *testing.T) *fakeGPIOChip {
+	chip := &fakeGPIOChip{levels: make(map[int]bool), inputs: make(map[int]*fakeGPIOInput)}
+	for i := 0; i < 28; i++ {
+		chip.names = append(chip.names, fmt.Sprintf("GPIO%d", i))
+	}
+	open := openGPIOChip
+	openGPIOChip = func(path string) (GPIOChip, error) {
+		if path != "/dev/gpiochip0" {
+			return nil, fmt.Errorf("no such chip %s", path)
+		}
+		return chip, nil
+	}
+	t.Cleanup(func() { openGPIOChip = open })
+	return chip
+}
+
+func TestCdevAdapter(t *testing.T) {
+	chip := fakeGPIOChips(t)
+	adapter, err := NewCdevAdapter("/dev/gpiochip0", map[string]config.OutputConfig{
+		"speed_1": {Pin: 17},
+		"speed_2": {Line: "GPIO27"},
+		"timer":   {Pin: 22, ActiveLow: true},
+	})
+	if err != nil {
+		t.Fatalf("Error creating cdev adapter: %v", err)
+	}
+	expected := map[int]bool{17: false, 27: false, 22: true}
+	if !reflect.DeepEqual(chip.levels, expected) || !chip.closed {
+		t.Fatalf("Expected inactive lines %v and a closed chip, got %v", expected, chip.levels)
+	}
+
+	adapter.WriteOutput(context.Background(), "speed_2", true)
+	adapter.WriteOutput(context.Background(), "timer", true)
+	expected = map[int]bool{17: false, 27: true, 22: false}
+	if !reflect.DeepEqual(chip.levels, expected) {
+		t.Fatalf("Expected lines %v, got %v", expected, chip.levels)
+	}
+	if err := adapter.WriteOutput(context.Background(), "bypass", true); err == nil {
+		t.Fatalf("Expected an error for an unknown output")
+	}
+	adapter.Close()
+	if err := adapter.WriteOutput(context.Background(), "speed_2", false); err == nil {
+		t.Fatalf("Expected an error after releasing the lines")
+	}
+}
+
+func TestCdevAdapterErrors(t *testing.T) {
+	fakeGPIOChips(t)
+	if _, err := NewCdevAdapter("/dev/gpiochip4", map[string]config.OutputConfig{"speed_1": {Pin: 17}}); err == nil {
+		t.Fatalf("Expected an error for a missing chip")
+	}
+	if _, err := NewCdevAdapter("/dev/gpiochip0", map[string]config.OutputConfig{"speed_1": {Line: "GPIO40"}}); err == nil {
+		t.Fatalf("Expected an error for an unknown line name")
+	}
+	_, err := NewCdevAdapter("/dev/gpiochip0", map[string]config.OutputConfig{
+		"speed_1": {Pin: 17},
+		"speed_2": {Line: "GPIO17"},
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for outputs on the same line")
+	}
+}
+
+func TestCdevInputAdapter(t *testing.T) {
+	chip := fakeGPIOChips(t)
+	adapter, err := NewCdevInputAdapter("/dev/gpiochip0", map[string]config.InputConfig{
+		"led_speed_3":  {Pin: 5, Pull: config.PullDown, Debounce: 50 * time.Millisecond},
+		"remote_timer": {Line: "GPIO6", ActiveLow: true, Pull: config.PullUp},
+	})
+	if err != nil {
+		t.Fatalf("Error creating cdev input adapter: %v", err)
+	}
+	defer adapter.Close()
+	if input := chip.inputs[5]; input.pull != config.PullDown || input.debounce != 50*time.Millisecond {
+		t.Fatalf("Expected a pull-down with 50ms debounce, got %s and %v", input.pull, input.debounce)
+	}
+
+	// The remote contact is pulled up, and active when pressed (low).
+	chip.inputs[6].edges <- false
+	chip.inputs[5].edges <- true
+	events := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-adapter.Events():
+			events[event.Name] = event.Active
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 2 events, got %v", events)
+		}
+	}
+	if expected := map[string]bool{"led_speed_3": true, "remote_timer": true}; !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+	if active, _ := adapter.ReadInput("remote_timer"); !active {
+		t.Fatalf("Expected the remote input to read active while its line is low")
+	}
+}
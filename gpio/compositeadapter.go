@@ -0,0 +1,84 @@
+package gpio
+
+import (
+	"context"
+	"errors"
+)
+
+// compositeAdapter routes each output to the adapter that drives it, so outputs of different types can
+// be mixed.
+type compositeAdapter struct {
+	adapters []GPIOAdapter
+	routes   map[string]GPIOAdapter
+}
+
+// Creates a compositeAdapter for the outputs of the given adapters.
+func newCompositeAdapter(adapters ...GPIOAdapter) *compositeAdapter {
+	composite := &compositeAdapter{adapters: adapters, routes: make(map[string]GPIOAdapter)}
+	for _, adapter := range adapters {
+		for _, name := range adapter.Outputs() {
+			composite.routes[name] = adapter
+		}
+	}
+	return composite
+}
+
+// WriteOutput writes a value to an output.
+func (c *compositeAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	adapter, ok := c.routes[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	return adapter.WriteOutput(ctx, name, value)
+}
+
+// WritePercent sets the level of an analog output.
+func (c *compositeAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	adapter, ok := c.routes[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	analog, ok := adapter.(AnalogAdapter)
+	if !ok {
+		return notAnalogError(name)
+	}
+	return analog.WritePercent(ctx, name, percent)
+}
+
+// Readings returns the readings of all adapters that support them.
+func (c *compositeAdapter) Readings() (map[string]float64, error) {
+	values := make(map[string]float64)
+	var err error
+	for _, adapter := range c.adapters {
+		readings, ok := adapter.(ReadingsAdapter)
+		if !ok {
+			continue
+		}
+		adapterValues, adapterErr := readings.Readings()
+		if adapterErr != nil {
+			err = adapterErr
+		}
+		for name, value := range adapterValues {
+			values[name] = value
+		}
+	}
+	return values, err
+}
+
+// Healthy checks the backends of all adapters that support it.
+func (c *compositeAdapter) Healthy() error {
+	var errs []error
+	for _, adapter := range c.adapters {
+		if health, ok := adapter.(HealthAdapter); ok {
+			if err := health.Healthy(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Outputs returns the names of all outputs.
+func (c *compositeAdapter) Outputs() []string {
+	return outputNames(c.routes)
+}
@@ -0,0 +1,112 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// GP8403 registers.
+const (
+	gp8403RangeRegister = 0x01 // Output range of both channels
+	gp8403Range10V      = 0x11 // 0-10V on both channels
+	gp8403Channel0      = 0x02 // Data register of channel 0, channel 1 follows at +2
+)
+
+// Output on an I2C DAC.
+type dacOutput struct {
+	config.OutputConfig
+	bus I2CBus
+}
+
+// DACAdapter is an adapter for outputs on I2C DACs (MCP4725 and GP8403), which drive the 0-10V input
+// of EC-fan units. Writing an output active sets it to full scale.
+type DACAdapter struct {
+	outputs map[string]dacOutput
+	buses   map[int]I2CBus
+}
+
+// NewDACAdapter creates a new DACAdapter for the given DAC outputs, opening the I2C buses they are on.
+func NewDACAdapter(outputs map[string]config.OutputConfig) (*DACAdapter, error) {
+	adapter := &DACAdapter{
+		outputs: make(map[string]dacOutput),
+		buses:   make(map[int]I2CBus),
+	}
+	for _, name := range outputNames(outputs) {
+		output := outputs[name]
+		bus, ok := adapter.buses[output.Bus]
+		if !ok {
+			var err error
+			if bus, err = openI2CBus(output.Bus); err != nil {
+				adapter.Close()
+				return nil, err
+			}
+			adapter.buses[output.Bus] = bus
+		}
+		adapter.outputs[name] = dacOutput{OutputConfig: output, bus: bus}
+		if output.Chip == config.ChipGP8403 {
+			if err := bus.Write(output.Address, []byte{gp8403RangeRegister, gp8403Range10V}); err != nil {
+				adapter.Close()
+				return nil, err
+			}
+		}
+	}
+	return adapter, nil
+}
+
+// WriteOutput sets an output to full scale (true) or zero (false).
+func (a *DACAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	percent := 0.0
+	if value {
+		percent = 100
+	}
+	return a.WritePercent(ctx, name, percent)
+}
+
+// WritePercent sets an output to a percentage of full scale, through its calibration.
+func (a *DACAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	code := output.Code(percent)
+	var data []byte
+	switch output.Chip {
+	case config.ChipMCP4725:
+		// Fast mode write, normal power mode.
+		data = []byte{byte(code>>8) & 0x0f, byte(code)}
+	case config.ChipGP8403:
+		// The 12-bit code is left aligned in a little endian 16-bit word.
+		word := code << 4
+		data = []byte{byte(gp8403Channel0 + 2*output.Channel), byte(word), byte(word >> 8)}
+	default:
+		return fmt.Errorf("gpio: unsupported DAC chip %s", output.Chip)
+	}
+	log.Debug().Msgf("DAC: writing code %d (%.1f%%) to %s", code, percent, name)
+	return output.bus.Write(output.Address, data)
+}
+
+// Healthy checks that all DACs respond on their bus.
+func (a *DACAdapter) Healthy() error {
+	for _, name := range outputNames(a.outputs) {
+		output := a.outputs[name]
+		if err := output.bus.Read(output.Address, make([]byte, 1)); err != nil {
+			return fmt.Errorf("gpio: DAC %s at 0x%02x: %v", name, output.Address, err)
+		}
+	}
+	return nil
+}
+
+// Outputs returns the names of all outputs.
+func (a *DACAdapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
+
+// Close releases the I2C buses.
+func (a *DACAdapter) Close() {
+	for _, bus := range a.buses {
+		bus.Close()
+	}
+}
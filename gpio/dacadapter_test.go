@@ -0,0 +1,153 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+// Error used to simulate failures.
+var errTest = fmt.Errorf("test failure")
+
+// I2C bus that records the writes, per address.
+type fakeI2CBus struct {
+	writes    map[int][][]byte
+	registers map[int]map[byte]byte // Registers of register based devices, by address
+	pointer   map[int]byte          // Register that is read next, by address
+	closed    bool
+	fail      bool
+}
+
+func (b *fakeI2CBus) Write(address int, data []byte) error {
+	if b.fail {
+		return fmt.Errorf("no acknowledge from 0x%02x", address)
+	}
+	b.writes[address] = append(b.writes[address], append([]byte{}, data...))
+	if len(data) > 0 {
+		b.pointer[address] = data[0]
+	}
+	if len(data) == 2 {
+		if b.registers[address] == nil {
+			b.registers[address] = make(map[byte]byte)
+		}
+		b.registers[address][data[0]] = data[1]
+	}
+	return nil
+}
+
+func (b *fakeI2CBus) Read(address int, data []byte) error {
+	if b.fail {
+		return fmt.Errorf("no acknowledge from 0x%02x", address)
+	}
+	for i := range data {
+		data[i] = b.registers[address][b.pointer[address]+byte(i)]
+	}
+	return nil
+}
+
+func (b *fakeI2CBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+// Replaces the I2C buses by fake buses for the duration of a test.
+func fakeI2CBuses(t *testing.T) map[int]*fakeI2CBus {
+	buses := make(map[int]*fakeI2CBus)
+	open := openI2CBus
+	openI2CBus = func(bus int) (I2CBus, error) {
+		fake := &fakeI2CBus{
+			writes:    make(map[int][][]byte),
+			registers: make(map[int]map[byte]byte),
+			pointer:   make(map[int]byte),
+		}
+		buses[bus] = fake
+		return fake, nil
+	}
+	t.Cleanup(func() { openI2CBus = open })
+	return buses
+}
+
+func TestMCP4725(t *testing.T) {
+	buses := fakeI2CBuses(t)
+	adapter, err := NewDACAdapter(map[string]config.OutputConfig{
+		"fan": {Type: config.OutputDAC, Bus: 1, Address: 0x60, Chip: config.ChipMCP4725},
+	})
+	if err != nil {
+		t.Fatalf("Error creating DAC adapter: %v", err)
+	}
+
+	adapter.WritePercent(context.Background(), "fan", 50)
+	adapter.WriteOutput(context.Background(), "fan", true)
+	expected := [][]byte{{0x08, 0x00}, {0x0f, 0xff}}
+	if writes := buses[1].writes[0x60]; !reflect.DeepEqual(writes, expected) {
+		t.Fatalf("Expected writes %v, got %v", expected, writes)
+	}
+	adapter.Close()
+	if !buses[1].closed {
+		t.Fatalf("Expected the bus to be closed")
+	}
+}
+
+func TestGP8403(t *testing.T) {
+	buses := fakeI2CBuses(t)
+	adapter, err := NewDACAdapter(map[string]config.OutputConfig{
+		"supply":  {Type: config.OutputDAC, Bus: 1, Address: 0x58, Chip: config.ChipGP8403, Channel: 0},
+		"exhaust": {Type: config.OutputDAC, Bus: 1, Address: 0x58, Chip: config.ChipGP8403, Channel: 1},
+	})
+	if err != nil {
+		t.Fatalf("Error creating DAC adapter: %v", err)
+	}
+	if len(buses) != 1 {
+		t.Fatalf("Expected the outputs to share the bus, got %d buses", len(buses))
+	}
+
+	adapter.WritePercent(context.Background(), "exhaust", 100)
+	adapter.WriteOutput(context.Background(), "supply", false)
+	expected := [][]byte{
+		{0x01, 0x11}, {0x01, 0x11}, // 0-10V range, set for each output
+		{0x04, 0xf0, 0xff},
+		{0x02, 0x00, 0x00},
+	}
+	if writes := buses[1].writes[0x58]; !reflect.DeepEqual(writes, expected) {
+		t.Fatalf("Expected writes %v, got %v", expected, writes)
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	output := config.OutputConfig{Calibration: []config.CalibrationPoint{
+		{Percent: 0, Code: 400},
+		{Percent: 50, Code: 2000},
+		{Percent: 100, Code: 3600},
+	}}
+	for percent, code := range map[float64]int{0: 400, 25: 1200, 50: 2000, 75: 2800, 100: 3600, 120: 3600} {
+		if actual := output.Code(percent); actual != code {
+			t.Fatalf("Expected code %d for %g%%, got %d", code, percent, actual)
+		}
+	}
+}
+
+func TestDACErrors(t *testing.T) {
+	buses := fakeI2CBuses(t)
+	adapter, err := NewDACAdapter(map[string]config.OutputConfig{
+		"fan": {Type: config.OutputDAC, Bus: 1, Address: 0x60, Chip: config.ChipMCP4725},
+	})
+	if err != nil {
+		t.Fatalf("Error creating DAC adapter: %v", err)
+	}
+	if err := adapter.Healthy(); err != nil {
+		t.Fatalf("Expected the DAC to be healthy, got %v", err)
+	}
+	buses[1].fail = true
+	if err := adapter.WritePercent(context.Background(), "fan", 10); err == nil {
+		t.Fatalf("Expected the bus error to be returned")
+	}
+	if err := adapter.Healthy(); err == nil {
+		t.Fatalf("Expected a DAC that does not respond to be unhealthy")
+	}
+	if err := adapter.WritePercent(context.Background(), "bypass", 10); err == nil {
+		t.Fatalf("Expected an error for an unknown output")
+	}
+}
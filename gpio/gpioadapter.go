@@ -1,25 +1,190 @@
 package gpio
 
-import "github.com/dlefevre/go.ventilation-service/config"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
 
-// GPIOAdapter specifies the interface for GPIO operations.
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// GPIOAdapter specifies the interface for GPIO operations. Outputs are identified by the logical
+// names configured in gpio.outputs, and are written as active (true) or inactive (false). Adapters that
+// talk to a device stop retrying or waiting for it when the context is done.
 type GPIOAdapter interface {
-	WriteSpeed1Pin(value bool)
-	WriteSpeed2Pin(value bool)
-	WriteSpeed3Pin(value bool)
-	WriteAwayPin(value bool)
-	WriteAutoPin(value bool)
-	WriteTimerPin(value bool)
+	WriteOutput(ctx context.Context, name string, value bool) error
+	Outputs() []string
+}
+
+// AnalogAdapter is implemented by adapters with outputs that support a continuous level, such as
+// PWM outputs. The percentage is the duty cycle (or voltage) relative to the full scale.
+type AnalogAdapter interface {
+	WritePercent(ctx context.Context, name string, percent float64) error
 }
 
-// GetGPIOAdapter returns the GPIO adapter based on the current mode.
+// HealthAdapter is implemented by adapters that can check their backend, such as a device that can
+// be unplugged. Healthy reopens or recreates the backend when it is not available.
+type HealthAdapter interface {
+	Healthy() error
+}
+
+// GetGPIOAdapter returns the GPIO adapter based on the configured driver. Unless the mock is used,
+// every type of output is driven by its own adapter, and pins by the rpio or cdev driver.
 func GetGPIOAdapter() GPIOAdapter {
-	switch config.GetMode() {
-	case "production":
-		return NewGPIORPiAdapter()
-	case "development":
+	driver := config.GetGPIODriver()
+	if driver == config.DriverMock {
 		return NewGPIOMockAdapter()
-	default:
-		panic("Unknown mode")
 	}
+	adapters := []GPIOAdapter{}
+	if outputs := outputsOfType(config.OutputGPIO); len(outputs) > 0 {
+		if driver == config.DriverCdev {
+			adapters = append(adapters, adapterOrFailed(func() (*CdevAdapter, error) {
+				return NewCdevAdapter(config.GetGPIOChip(), outputs)
+			}, outputs))
+		} else {
+			adapters = append(adapters, adapterOrFailed(NewGPIORPiAdapter, outputs))
+		}
+	}
+	if outputs := outputsOfType(config.OutputDAC); len(outputs) > 0 {
+		adapters = append(adapters, adapterOrFailed(func() (*DACAdapter, error) {
+			return NewDACAdapter(outputs)
+		}, outputs))
+	}
+	if outputs := outputsOfType(config.OutputMCP23017); len(outputs) > 0 {
+		adapters = append(adapters, adapterOrFailed(func() (*MCP23017Adapter, error) {
+			return NewMCP23017Adapter(outputs)
+		}, outputs))
+	}
+	if outputs := outputsOfType(config.OutputUSBRelay); len(outputs) > 0 {
+		adapters = append(adapters, NewUSBRelayAdapter(outputs))
+	}
+	network := outputsOfType(config.OutputShelly)
+	for name, output := range outputsOfType(config.OutputTasmota) {
+		network[name] = output
+	}
+	if len(network) > 0 {
+		adapters = append(adapters, NewHTTPRelayAdapter(network))
+	}
+	if connection, ok := config.GetModbus(); ok {
+		adapters = append(adapters, NewModbusAdapter(newModbusClient(connection), outputsOfType(config.OutputModbus), connection.Readings))
+	}
+	return newCompositeAdapter(adapters...)
+}
+
+// Creates an adapter, or when it cannot be created, an adapter that reports the error on every write
+// and retries the creation when its health is checked.
+func adapterOrFailed[T GPIOAdapter](create func() (T, error), outputs map[string]config.OutputConfig) GPIOAdapter {
+	adapter, err := create()
+	if err != nil {
+		log.Error().Msgf("%v", err)
+		return &failedAdapter{
+			err:     err,
+			outputs: outputNames(outputs),
+			create: func() (GPIOAdapter, error) {
+				return create()
+			},
+		}
+	}
+	return adapter
+}
+
+// Returns the configured outputs of a type.
+func outputsOfType(outputType string) map[string]config.OutputConfig {
+	outputs := make(map[string]config.OutputConfig)
+	for name, output := range config.GetGPIOOutputs() {
+		if output.Type == outputType {
+			outputs[name] = output
+		}
+	}
+	return outputs
+}
+
+// Returns the sorted names of a map of outputs.
+func outputNames[T any](outputs map[string]T) []string {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Error returned when writing a percentage to an output that is not analog.
+func notAnalogError(name string) error {
+	return fmt.Errorf("gpio: output %s does not support analog values", name)
+}
+
+// Error returned when writing to an output that is not configured.
+func unknownOutputError(name string) error {
+	return fmt.Errorf("gpio: unknown output %s", name)
+}
+
+// failedAdapter stands in for an adapter that could not be created, such as an expander that does not
+// respond. Writes to its outputs return the error instead of bringing down the service, until a health
+// check manages to create the adapter.
+type failedAdapter struct {
+	err     error
+	outputs []string
+	create  func() (GPIOAdapter, error)
+	adapter GPIOAdapter // The adapter, once created
+	lock    sync.Mutex
+}
+
+// Returns the adapter once it is created, or the error that prevents it.
+func (f *failedAdapter) created() (GPIOAdapter, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.adapter == nil {
+		return nil, f.err
+	}
+	return f.adapter, nil
+}
+
+func (f *failedAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	adapter, err := f.created()
+	if err != nil {
+		return err
+	}
+	return adapter.WriteOutput(ctx, name, value)
+}
+
+func (f *failedAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	adapter, err := f.created()
+	if err != nil {
+		return err
+	}
+	analog, ok := adapter.(AnalogAdapter)
+	if !ok {
+		return notAnalogError(name)
+	}
+	return analog.WritePercent(ctx, name, percent)
+}
+
+func (f *failedAdapter) Outputs() []string {
+	return f.outputs
+}
+
+// Healthy tries to create the adapter, if it was not created yet.
+func (f *failedAdapter) Healthy() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.adapter != nil {
+		if health, ok := f.adapter.(HealthAdapter); ok {
+			return health.Healthy()
+		}
+		return nil
+	}
+	if f.create == nil {
+		return f.err
+	}
+	adapter, err := f.create()
+	if err != nil {
+		f.err = err
+		return err
+	}
+	log.Info().Msgf("Recovered outputs %v", f.outputs)
+	f.adapter = adapter
+	return nil
 }
@@ -1,7 +1,9 @@
 package gpio
 
 import (
+	"context"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -12,10 +14,41 @@ func init() {
 
 func TestWritePins(t *testing.T) {
 	adapter := NewGPIOMockAdapter()
-	adapter.WriteSpeed1Pin(true)
-	adapter.WriteSpeed2Pin(true)
-	adapter.WriteSpeed3Pin(true)
-	adapter.WriteAwayPin(true)
-	adapter.WriteAutoPin(true)
-	adapter.WriteTimerPin(true)
+	for _, name := range adapter.Outputs() {
+		if err := adapter.WriteOutput(context.Background(), name, true); err != nil {
+			t.Fatalf("Error writing output %s: %v", name, err)
+		}
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	adapter := NewGPIOMockAdapter()
+	expected := []string{"auto", "away", "speed_1", "speed_2", "speed_3", "timer"}
+	if !reflect.DeepEqual(adapter.Outputs(), expected) {
+		t.Fatalf("Expected outputs %v, got %v", expected, adapter.Outputs())
+	}
+}
+
+func TestUnknownOutput(t *testing.T) {
+	adapter := NewGPIOMockAdapter()
+	if err := adapter.WriteOutput(context.Background(), "bypass", true); err == nil {
+		t.Fatalf("Expected an error writing to an unknown output")
+	}
+}
+
+func TestMockValues(t *testing.T) {
+	adapter := NewGPIOMockAdapter()
+	adapter.WriteOutput(context.Background(), "speed_2", true)
+	adapter.WriteOutput(context.Background(), "speed_1", false)
+	values := adapter.Values()
+	if !values["speed_2"] || values["speed_1"] || len(values) != 2 {
+		t.Fatalf("Expected speed_2 to be held active, got %v", values)
+	}
+}
+
+func TestNotAnalog(t *testing.T) {
+	adapter := NewGPIOMockAdapter()
+	if err := adapter.WritePercent(context.Background(), "speed_1", 50); err == nil {
+		t.Fatalf("Expected an error writing a percentage to a digital output")
+	}
 }
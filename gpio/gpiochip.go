@@ -0,0 +1,44 @@
+package gpio
+
+import "time"
+
+// GPIOChip is the access to a GPIO character device, as needed by the cdev adapter.
+type GPIOChip interface {
+	// LineOffset returns the offset of the line with the given name.
+	LineOffset(name string) (int, error)
+	// RequestOutputs requests lines as outputs, driven to their initial values.
+	RequestOutputs(offsets []int, values []bool) (GPIOLines, error)
+	// RequestInput requests a line as input with a pull resistor (one of the config.Pull* constants),
+	// reporting both edges once the line is stable for the debounce period.
+	RequestInput(offset int, pull string, debounce time.Duration) (GPIOInput, error)
+	// Close releases the chip. Requested lines stay requested until they are closed.
+	Close() error
+}
+
+// GPIOLines is a set of lines requested from a GPIO chip.
+type GPIOLines interface {
+	// SetValues sets the lines whose bit is set in mask, by their index in the request.
+	SetValues(bits uint64, mask uint64) error
+	// Close releases the lines.
+	Close() error
+}
+
+// GPIOInput is a single line requested from a GPIO chip as input.
+type GPIOInput interface {
+	// Value returns the level of the line.
+	Value() (bool, error)
+	// WaitEdge blocks until the level of the line changes, and returns the new level. It fails once
+	// the line is closed.
+	WaitEdge() (bool, error)
+	// Close releases the line.
+	Close() error
+}
+
+// Maximum number of lines in a single request.
+const maxLines = 64
+
+// Name under which the lines are requested, as shown by gpioinfo.
+const consumer = "ventilation-service"
+
+// Opens the GPIO character device at a path, e.g. /dev/gpiochip0. Replaced by a fake chip in tests.
+var openGPIOChip = openDevGPIOChip
@@ -0,0 +1,219 @@
+package gpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"golang.org/x/sys/unix"
+)
+
+// ioctl requests and flags of the GPIO character device uAPI v2 (linux/gpio.h).
+const (
+	gpioGetChipInfo     = 0x8044b401
+	gpioV2GetLineInfo   = 0xc100b405
+	gpioV2GetLine       = 0xc250b407
+	gpioV2GetLineValues = 0xc010b40e
+	gpioV2SetLineValues = 0xc010b40f
+
+	gpioV2LineFlagInput        = 1 << 2
+	gpioV2LineFlagOutput       = 1 << 3
+	gpioV2LineFlagEdgeRising   = 1 << 4
+	gpioV2LineFlagEdgeFalling  = 1 << 5
+	gpioV2LineFlagBiasPullUp   = 1 << 8
+	gpioV2LineFlagBiasPullDown = 1 << 9
+	gpioV2LineFlagBiasDisabled = 1 << 10
+	gpioV2LineAttrOutputValues = 2
+	gpioV2LineAttrDebounce     = 3
+	gpioV2LineEventRisingEdge  = 1
+	gpioV2LineEventSize        = 48
+)
+
+// Bias flags of the pull resistors.
+var gpioBias = map[string]uint64{
+	config.PullNone: gpioV2LineFlagBiasDisabled,
+	config.PullUp:   gpioV2LineFlagBiasPullUp,
+	config.PullDown: gpioV2LineFlagBiasPullDown,
+}
+
+type gpioChipInfo struct {
+	name  [32]byte
+	label [32]byte
+	lines uint32
+}
+
+type gpioV2LineAttribute struct {
+	id      uint32
+	padding uint32
+	value   uint64 // Flags, output values or debounce period, depending on id
+}
+
+type gpioV2LineConfigAttribute struct {
+	attr gpioV2LineAttribute
+	mask uint64
+}
+
+type gpioV2LineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [10]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	offsets         [maxLines]uint32
+	consumer        [32]byte
+	config          gpioV2LineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+type gpioV2LineValues struct {
+	bits uint64
+	mask uint64
+}
+
+type gpioV2LineInfo struct {
+	name     [32]byte
+	consumer [32]byte
+	offset   uint32
+	numAttrs uint32
+	flags    uint64
+	attrs    [10]gpioV2LineAttribute
+	padding  [4]uint32
+}
+
+func ioctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// GPIO chip exposed by the GPIO character device.
+type devGPIOChip struct {
+	file *os.File
+}
+
+func openDevGPIOChip(path string) (GPIOChip, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("gpio: failed to open %s: %v", path, err)
+	}
+	return &devGPIOChip{file: file}, nil
+}
+
+func (c *devGPIOChip) LineOffset(name string) (int, error) {
+	var chip gpioChipInfo
+	if err := ioctl(c.file, gpioGetChipInfo, unsafe.Pointer(&chip)); err != nil {
+		return 0, fmt.Errorf("gpio: failed to read chip info: %v", err)
+	}
+	for offset := uint32(0); offset < chip.lines; offset++ {
+		info := gpioV2LineInfo{offset: offset}
+		if err := ioctl(c.file, gpioV2GetLineInfo, unsafe.Pointer(&info)); err != nil {
+			return 0, fmt.Errorf("gpio: failed to read line info of offset %d: %v", offset, err)
+		}
+		if string(bytes.TrimRight(info.name[:], "\x00")) == name {
+			return int(offset), nil
+		}
+	}
+	return 0, fmt.Errorf("gpio: no line named %s on %s", name, c.file.Name())
+}
+
+func (c *devGPIOChip) RequestOutputs(offsets []int, values []bool) (GPIOLines, error) {
+	if len(offsets) > maxLines {
+		return nil, fmt.Errorf("gpio: at most %d lines can be requested", maxLines)
+	}
+	request := gpioV2LineRequest{numLines: uint32(len(offsets))}
+	copy(request.consumer[:], consumer)
+	request.config.flags = gpioV2LineFlagOutput
+	request.config.numAttrs = 1
+	request.config.attrs[0].attr.id = gpioV2LineAttrOutputValues
+	for i, offset := range offsets {
+		request.offsets[i] = uint32(offset)
+		request.config.attrs[0].mask |= 1 << i
+		if values[i] {
+			request.config.attrs[0].attr.value |= 1 << i
+		}
+	}
+	if err := ioctl(c.file, gpioV2GetLine, unsafe.Pointer(&request)); err != nil {
+		return nil, fmt.Errorf("gpio: failed to request lines %v of %s: %v", offsets, c.file.Name(), err)
+	}
+	return &devGPIOLines{file: os.NewFile(uintptr(request.fd), c.file.Name())}, nil
+}
+
+func (c *devGPIOChip) RequestInput(offset int, pull string, debounce time.Duration) (GPIOInput, error) {
+	request := gpioV2LineRequest{numLines: 1}
+	request.offsets[0] = uint32(offset)
+	copy(request.consumer[:], consumer)
+	request.config.flags = gpioV2LineFlagInput | gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling | gpioBias[pull]
+	if debounce > 0 {
+		request.config.numAttrs = 1
+		request.config.attrs[0].attr.id = gpioV2LineAttrDebounce
+		request.config.attrs[0].attr.value = uint64(debounce.Microseconds())
+		request.config.attrs[0].mask = 1
+	}
+	if err := ioctl(c.file, gpioV2GetLine, unsafe.Pointer(&request)); err != nil {
+		return nil, fmt.Errorf("gpio: failed to request input line %d of %s: %v", offset, c.file.Name(), err)
+	}
+	// Non-blocking, so that closing the line interrupts a pending read.
+	if err := unix.SetNonblock(int(request.fd), true); err != nil {
+		unix.Close(int(request.fd))
+		return nil, err
+	}
+	return &devGPIOInput{file: os.NewFile(uintptr(request.fd), c.file.Name())}, nil
+}
+
+func (c *devGPIOChip) Close() error {
+	return c.file.Close()
+}
+
+// Lines requested from the GPIO character device.
+type devGPIOLines struct {
+	file *os.File
+}
+
+func (l *devGPIOLines) SetValues(bits uint64, mask uint64) error {
+	values := gpioV2LineValues{bits: bits, mask: mask}
+	if err := ioctl(l.file, gpioV2SetLineValues, unsafe.Pointer(&values)); err != nil {
+		return fmt.Errorf("gpio: failed to set lines of %s: %v", l.file.Name(), err)
+	}
+	return nil
+}
+
+func (l *devGPIOLines) Close() error {
+	return l.file.Close()
+}
+
+// Line requested from the GPIO character device as input.
+type devGPIOInput struct {
+	file *os.File
+}
+
+func (l *devGPIOInput) Value() (bool, error) {
+	values := gpioV2LineValues{mask: 1}
+	if err := ioctl(l.file, gpioV2GetLineValues, unsafe.Pointer(&values)); err != nil {
+		return false, fmt.Errorf("gpio: failed to read line of %s: %v", l.file.Name(), err)
+	}
+	return values.bits&1 != 0, nil
+}
+
+func (l *devGPIOInput) WaitEdge() (bool, error) {
+	event := make([]byte, gpioV2LineEventSize)
+	if _, err := io.ReadFull(l.file, event); err != nil {
+		return false, err
+	}
+	// The event id follows the 64-bit timestamp.
+	return binary.NativeEndian.Uint32(event[8:]) == gpioV2LineEventRisingEdge, nil
+}
+
+func (l *devGPIOInput) Close() error {
+	return l.file.Close()
+}
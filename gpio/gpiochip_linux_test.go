@@ -0,0 +1,21 @@
+package gpio
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// The structs must match the kernel's, whose sizes are part of the ioctl requests.
+func TestGPIOChipStructSizes(t *testing.T) {
+	sizes := map[string][2]uintptr{
+		"gpiochip_info":        {unsafe.Sizeof(gpioChipInfo{}), gpioGetChipInfo >> 16 & 0x3fff},
+		"gpio_v2_line_info":    {unsafe.Sizeof(gpioV2LineInfo{}), gpioV2GetLineInfo >> 16 & 0x3fff},
+		"gpio_v2_line_request": {unsafe.Sizeof(gpioV2LineRequest{}), gpioV2GetLine >> 16 & 0x3fff},
+		"gpio_v2_line_values":  {unsafe.Sizeof(gpioV2LineValues{}), gpioV2SetLineValues >> 16 & 0x3fff},
+	}
+	for name, size := range sizes {
+		if size[0] != size[1] {
+			t.Errorf("Expected struct %s to be %d bytes, got %d", name, size[1], size[0])
+		}
+	}
+}
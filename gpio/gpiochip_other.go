@@ -0,0 +1,9 @@
+//go:build !linux
+
+package gpio
+
+import "fmt"
+
+func openDevGPIOChip(path string) (GPIOChip, error) {
+	return nil, fmt.Errorf("gpio: GPIO character devices are only supported on Linux")
+}
@@ -1,61 +1,116 @@
 package gpio
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/dlefevre/go.ventilation-service/config"
 	"github.com/rs/zerolog/log"
 )
 
 // GPIOMockAdapter is a mock GPIO adapter, which:
-// - mimicks the behavior of the garage door, without the delays of a physical door and motor.
+// - mimicks the behavior of the ventilation unit's remote, without any hardware.
 // - reports all actions to the log.
 type GPIOMockAdapter struct {
-	speed1Pin int
-	speed2Pin int
-	speed3Pin int
-	awayPin   int
-	autoPin   int
-	timerPin  int
+	outputs map[string]config.OutputConfig
+	values  map[string]bool
+	percent map[string]float64
+	lock    sync.Mutex
 }
 
 // NewGPIOMockAdapter creates a new GPIOMockAdapter.
 func NewGPIOMockAdapter() *GPIOMockAdapter {
 	log.Info().Msg("Mock GPIO: Creating mock GPIO adapter")
 	return &GPIOMockAdapter{
-		speed1Pin: config.GetGPIOSpeed1Pin(),
-		speed2Pin: config.GetGPIOSpeed2Pin(),
-		speed3Pin: config.GetGPIOSpeed3Pin(),
-		awayPin:   config.GetGPIOAwayPin(),
-		autoPin:   config.GetGPIOAutoPin(),
-		timerPin:  config.GetGPIOTimerPin(),
+		outputs: config.GetGPIOOutputs(),
+		values:  make(map[string]bool),
+		percent: make(map[string]float64),
 	}
 }
 
-// WriteSpeed1Pin writes a value to the first speed pin.
-func (g *GPIOMockAdapter) WriteSpeed1Pin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to speed 1 pin: %d", g.speed1Pin)
+// WriteOutput writes a value to an output.
+func (g *GPIOMockAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := g.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	if output.Analog() {
+		percent := 0.0
+		if value {
+			percent = 100
+		}
+		return g.WritePercent(ctx, name, percent)
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.values[name] = value
+	event := log.Info().
+		Bool("value", value).
+		Bool("pin_level", value != output.ActiveLow).
+		Str("active", g.active())
+	if output.Type == config.OutputGPIO {
+		event.Msgf("Mock GPIO: Writing to %s pin: %d", name, output.Pin)
+	} else {
+		event.Msgf("Mock GPIO: Writing to %s %s output", name, output.Type)
+	}
+	return nil
 }
 
-// WriteSpeed2Pin writes a value to the second speed pin.
-func (g *GPIOMockAdapter) WriteSpeed2Pin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to speed 2 pin: %d", g.speed2Pin)
+// WritePercent sets the level of an analog (PWM or DAC) output.
+func (g *GPIOMockAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	output, ok := g.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	if !output.Analog() {
+		return notAnalogError(name)
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.percent[name] = percent
+	g.values[name] = percent > 0
+	log.Info().
+		Float64("percent", percent).
+		Msgf("Mock GPIO: Writing to analog %s output", name)
+	return nil
 }
 
-// WriteSpeed3Pin writes a value to the third speed pin.
-func (g *GPIOMockAdapter) WriteSpeed3Pin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to speed 3 pin: %d", g.speed3Pin)
+// Percent returns the last level written to an analog output.
+func (g *GPIOMockAdapter) Percent(name string) float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.percent[name]
 }
 
-// WriteAwayPin writes a value to the away pin.
-func (g *GPIOMockAdapter) WriteAwayPin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to away pin: %d", g.awayPin)
+// Returns the outputs that are currently active, e.g. the held switch position.
+func (g *GPIOMockAdapter) active() string {
+	active := []string{}
+	for _, name := range outputNames(g.outputs) {
+		switch {
+		case !g.values[name]:
+		case g.outputs[name].Type == config.OutputGPIO:
+			active = append(active, fmt.Sprintf("%s(%d)", name, g.outputs[name].Pin))
+		default:
+			active = append(active, name)
+		}
+	}
+	return strings.Join(active, ",")
 }
 
-// WriteAutoPin writes a value to the auto pin.
-func (g *GPIOMockAdapter) WriteAutoPin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to auto pin: %d", g.autoPin)
+// Values returns the current value of every output that was written.
+func (g *GPIOMockAdapter) Values() map[string]bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	values := make(map[string]bool, len(g.values))
+	for name, value := range g.values {
+		values[name] = value
+	}
+	return values
 }
 
-// WriteTimerPin writes a value to the timer pin.
-func (g *GPIOMockAdapter) WriteTimerPin(value bool) {
-	log.Info().Bool("value", value).Msgf("Mock GPIO: Writing to timer pin: %d", g.timerPin)
+// Outputs returns the names of all outputs.
+func (g *GPIOMockAdapter) Outputs() []string {
+	return outputNames(g.outputs)
 }
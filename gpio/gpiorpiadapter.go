@@ -1,46 +1,79 @@
 package gpio
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/dlefevre/go.ventilation-service/config"
 	"github.com/stianeikeland/go-rpio/v4"
 )
 
-var once sync.Once
+// Number of PWM clock ticks in a PWM cycle, i.e. the resolution of the duty cycle.
+const pwmCycle = 1000
+
+var (
+	rpioOpened bool
+	rpioLock   sync.Mutex
+)
+
+// Maps the GPIO registers, unless they are mapped already.
+func openRPIO() error {
+	rpioLock.Lock()
+	defer rpioLock.Unlock()
+	if rpioOpened {
+		return nil
+	}
+	if err := rpio.Open(); err != nil {
+		return fmt.Errorf("gpio: failed to map the GPIO registers (is /dev/gpiomem accessible?): %v", err)
+	}
+	rpioOpened = true
+	return nil
+}
+
+// Output pin of the Raspberry Pi.
+type rpiOutput struct {
+	pin       rpio.Pin
+	activeLow bool
+	pwm       bool
+}
 
 // GPIORPiAdapter is an adapter for the Raspberry Pi GPIO pins.
 type GPIORPiAdapter struct {
-	speed1Pin rpio.Pin
-	speed2Pin rpio.Pin
-	speed3Pin rpio.Pin
-	awayPin   rpio.Pin
-	autoPin   rpio.Pin
-	timerPin  rpio.Pin
-}
-
-// NewGPIORPiAdapter creates a new GPIORPiAdapter.
-func NewGPIORPiAdapter() *GPIORPiAdapter {
-	once.Do(func() {
-		rpio.Open()
-	})
+	outputs map[string]rpiOutput
+}
+
+// NewGPIORPiAdapter creates a new GPIORPiAdapter, which fails when the GPIO registers cannot be mapped,
+// e.g. for lack of permissions or on a board that is not supported.
+func NewGPIORPiAdapter() (*GPIORPiAdapter, error) {
+	if err := openRPIO(); err != nil {
+		return nil, err
+	}
 
 	adapter := &GPIORPiAdapter{
-		speed1Pin: rpio.Pin(config.GetGPIOSpeed1Pin()),
-		speed2Pin: rpio.Pin(config.GetGPIOSpeed2Pin()),
-		speed3Pin: rpio.Pin(config.GetGPIOSpeed3Pin()),
-		awayPin:   rpio.Pin(config.GetGPIOAwayPin()),
-		autoPin:   rpio.Pin(config.GetGPIOAutoPin()),
-		timerPin:  rpio.Pin(config.GetGPIOTimerPin()),
+		outputs: make(map[string]rpiOutput),
+	}
+	analog, _ := config.GetAnalog()
+	for name, output := range outputsOfType(config.OutputGPIO) {
+		o := rpiOutput{
+			pin:       rpio.Pin(output.Pin),
+			activeLow: output.ActiveLow,
+			pwm:       output.PWM,
+		}
+		adapter.outputs[name] = o
+		// Outputs start inactive: the level is latched before the pin becomes an output.
+		if o.pwm {
+			o.pin.Pwm()
+			o.pin.Freq(analog.Frequency * pwmCycle)
+			adapter.WritePercent(context.Background(), name, 0)
+		} else {
+			adapter.writePin(o.pin, o.activeLow)
+			o.pin.Output()
+		}
 	}
-	adapter.speed1Pin.Output()
-	adapter.speed2Pin.Output()
-	adapter.speed3Pin.Output()
-	adapter.awayPin.Output()
-	adapter.autoPin.Output()
-	adapter.timerPin.Output()
 
-	return adapter
+	return adapter, nil
 }
 
 func (g *GPIORPiAdapter) writePin(pin rpio.Pin, value bool) {
@@ -51,32 +84,143 @@ func (g *GPIORPiAdapter) writePin(pin rpio.Pin, value bool) {
 	}
 }
 
-// WriteSpeed1Pin writes a value to the first speed pin.
-func (g *GPIORPiAdapter) WriteSpeed1Pin(value bool) {
-	g.writePin(g.speed1Pin, value)
+// WriteOutput writes a value to an output.
+func (g *GPIORPiAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := g.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	if output.pwm {
+		percent := 0.0
+		if value {
+			percent = 100
+		}
+		return g.WritePercent(ctx, name, percent)
+	}
+	g.writePin(output.pin, value != output.activeLow)
+	return nil
 }
 
-// WriteSpeed2Pin writes a value to the second speed pin.
-func (g *GPIORPiAdapter) WriteSpeed2Pin(value bool) {
-	g.writePin(g.speed2Pin, value)
+// WritePercent sets the duty cycle of a PWM output.
+func (g *GPIORPiAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	output, ok := g.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	if !output.pwm {
+		return notAnalogError(name)
+	}
+	if output.activeLow {
+		percent = 100 - percent
+	}
+	output.pin.DutyCycle(uint32(percent*pwmCycle/100+0.5), pwmCycle)
+	return nil
 }
 
-// WriteSpeed3Pin writes a value to the third speed pin.
-func (g *GPIORPiAdapter) WriteSpeed3Pin(value bool) {
-	g.writePin(g.speed3Pin, value)
+// Outputs returns the names of all outputs.
+func (g *GPIORPiAdapter) Outputs() []string {
+	return outputNames(g.outputs)
 }
 
-// WriteAwayPin writes a value to the away pin.
-func (g *GPIORPiAdapter) WriteAwayPin(value bool) {
-	g.writePin(g.awayPin, value)
+// Interval at which the input pins are polled.
+const inputPollInterval = 5 * time.Millisecond
+
+// Input pin of the Raspberry Pi.
+type rpiInput struct {
+	pin       rpio.Pin
+	activeLow bool
+	debouncer debouncer
 }
 
-// WriteAutoPin writes a value to the auto pin.
-func (g *GPIORPiAdapter) WriteAutoPin(value bool) {
-	g.writePin(g.autoPin, value)
+// GPIORPiInputAdapter is an adapter for input pins of the Raspberry Pi. The pins are polled, and
+// debounced in software.
+type GPIORPiInputAdapter struct {
+	eventQueue
+	inputs map[string]*rpiInput
+	lock   sync.Mutex
+	stop   chan struct{}
+	closed sync.Once
+	wg     sync.WaitGroup
 }
 
-// WriteTimerPin writes a value to the timer pin.
-func (g *GPIORPiAdapter) WriteTimerPin(value bool) {
-	g.writePin(g.timerPin, value)
+// NewRPiInputAdapter creates a new GPIORPiInputAdapter for the given inputs, and starts polling them.
+func NewRPiInputAdapter(inputs map[string]config.InputConfig) (*GPIORPiInputAdapter, error) {
+	if err := openRPIO(); err != nil {
+		return nil, err
+	}
+
+	adapter := &GPIORPiInputAdapter{
+		eventQueue: newEventQueue(),
+		inputs:     make(map[string]*rpiInput),
+		stop:       make(chan struct{}),
+	}
+	for name, input := range inputs {
+		i := &rpiInput{pin: rpio.Pin(input.Pin), activeLow: input.ActiveLow}
+		i.pin.Input()
+		switch input.Pull {
+		case config.PullUp:
+			i.pin.PullUp()
+		case config.PullDown:
+			i.pin.PullDown()
+		default:
+			i.pin.PullOff()
+		}
+		i.debouncer = newDebouncer(i.active(), input.Debounce)
+		adapter.inputs[name] = i
+	}
+	adapter.wg.Add(1)
+	go adapter.poll()
+	return adapter, nil
+}
+
+func (i *rpiInput) active() bool {
+	return (i.pin.Read() == rpio.High) != i.activeLow
+}
+
+// Polls the inputs, until the adapter is closed.
+func (g *GPIORPiInputAdapter) poll() {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(inputPollInterval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-g.stop:
+			return
+		case now = <-ticker.C:
+		}
+		g.lock.Lock()
+		for name, input := range g.inputs {
+			if input.debouncer.update(input.active(), now) {
+				g.emit(name, input.debouncer.value)
+			}
+		}
+		g.lock.Unlock()
+	}
+}
+
+// ReadInput returns whether an input is active, after debouncing.
+func (g *GPIORPiInputAdapter) ReadInput(name string) (bool, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	input, ok := g.inputs[name]
+	if !ok {
+		return false, unknownInputError(name)
+	}
+	return input.debouncer.value, nil
+}
+
+// Inputs returns the names of all inputs.
+func (g *GPIORPiInputAdapter) Inputs() []string {
+	return outputNames(g.inputs)
+}
+
+// Close stops polling the inputs, and waits for the poller to end.
+func (g *GPIORPiInputAdapter) Close() error {
+	g.closed.Do(func() {
+		close(g.stop)
+	})
+	g.wg.Wait()
+	return nil
 }
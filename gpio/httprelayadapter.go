@@ -0,0 +1,289 @@
+package gpio
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Time to wait before retrying a failed request.
+var retryDelay = 200 * time.Millisecond
+
+// Relay of a network device.
+type httpRelay struct {
+	config.OutputConfig
+	client *http.Client
+}
+
+// HTTPRelayAdapter is an adapter for relays of Wi-Fi devices: Shelly Gen2 devices through their RPC
+// api, and Tasmota devices through their command api.
+type HTTPRelayAdapter struct {
+	outputs map[string]httpRelay
+}
+
+// NewHTTPRelayAdapter creates a new HTTPRelayAdapter for the given Shelly and Tasmota outputs.
+func NewHTTPRelayAdapter(outputs map[string]config.OutputConfig) *HTTPRelayAdapter {
+	adapter := &HTTPRelayAdapter{outputs: make(map[string]httpRelay)}
+	for name, output := range outputs {
+		adapter.outputs[name] = httpRelay{
+			OutputConfig: output,
+			client:       &http.Client{Timeout: output.Timeout},
+		}
+	}
+	return adapter
+}
+
+// WriteOutput switches a relay, retrying failed requests until the context is done.
+func (a *HTTPRelayAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	relay, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	var err error
+	for attempt := 0; attempt <= relay.Retries; attempt++ {
+		if attempt > 0 {
+			log.Warn().Msgf("HTTP relay: retrying %s: %v", name, err)
+			if waitErr := wait(ctx, retryDelay); waitErr != nil {
+				return fmt.Errorf("gpio: %s at %s: %v, after %v", relay.Type, relay.URL, waitErr, err)
+			}
+		}
+		switch relay.Type {
+		case config.OutputShelly:
+			err = relay.shellySet(ctx, value)
+		case config.OutputTasmota:
+			err = relay.tasmotaPower(ctx, value)
+		default:
+			return fmt.Errorf("gpio: unsupported network relay %s", relay.Type)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("gpio: %s at %s: %v", relay.Type, relay.URL, err)
+}
+
+// Waits for a duration, or returns the error of the context when it is done first.
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Outputs returns the names of all outputs.
+func (a *HTTPRelayAdapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
+
+// Healthy checks that all devices respond: Shelly devices with Sys.GetStatus, Tasmota devices with
+// the Status command.
+func (a *HTTPRelayAdapter) Healthy() error {
+	checked := make(map[string]bool)
+	for _, name := range outputNames(a.outputs) {
+		relay := a.outputs[name]
+		if checked[relay.URL] {
+			continue
+		}
+		checked[relay.URL] = true
+		var err error
+		switch relay.Type {
+		case config.OutputShelly:
+			_, err = relay.shellyRPC(context.Background(), "Sys.GetStatus", map[string]interface{}{})
+		case config.OutputTasmota:
+			_, err = relay.tasmotaCommand(context.Background(), "Status")
+		}
+		if err != nil {
+			return fmt.Errorf("gpio: %s at %s: %v", relay.Type, relay.URL, err)
+		}
+	}
+	return nil
+}
+
+// Switch a Shelly Gen2 switch with Switch.Set. With a pulse, the device switches off by itself.
+func (r httpRelay) shellySet(ctx context.Context, on bool) error {
+	params := map[string]interface{}{"id": r.Channel, "on": on}
+	if on && r.Pulse > 0 {
+		params["toggle_after"] = r.Pulse.Seconds()
+	}
+	_, err := r.shellyRPC(ctx, "Switch.Set", params)
+	return err
+}
+
+// Calls a method of the RPC api of a Shelly Gen2 device, and returns its result.
+func (r httpRelay) shellyRPC(ctx context.Context, method string, params map[string]interface{}) (json.RawMessage, error) {
+	body, _ := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+
+	response, err := r.do(func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodPost, r.URL+"/rpc", bytes.NewReader(body))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rpc struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(response, &rpc); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if rpc.Error != nil {
+		return nil, fmt.Errorf("rpc error %d: %s", rpc.Error.Code, rpc.Error.Message)
+	}
+	return rpc.Result, nil
+}
+
+// Switch a Tasmota relay with the Power command, and check the reported state.
+func (r httpRelay) tasmotaPower(ctx context.Context, on bool) error {
+	command, state := "Power", "OFF"
+	if r.Channel > 0 {
+		command = fmt.Sprintf("Power%d", r.Channel)
+	}
+	if on {
+		state = "ON"
+	}
+	response, err := r.tasmotaCommand(ctx, command+" "+state)
+	if err != nil {
+		return err
+	}
+	var result map[string]string
+	if err := json.Unmarshal(response, &result); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	// Single relay devices report POWER, others POWER<n>.
+	reported, ok := result[strings.ToUpper(command)]
+	if !ok && r.Channel == 1 {
+		reported, ok = result["POWER"]
+	}
+	if !ok || reported != state {
+		return fmt.Errorf("relay did not switch %s: %v", state, result)
+	}
+	return nil
+}
+
+// Sends a command to a Tasmota device, and returns its JSON response.
+func (r httpRelay) tasmotaCommand(ctx context.Context, command string) ([]byte, error) {
+	query := url.Values{"cmnd": {command}}
+	if r.Username != "" {
+		query.Set("user", r.Username)
+		query.Set("password", r.Password)
+	}
+	response, err := r.do(func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, r.URL+"/cm?"+query.Encode(), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(response) {
+		return nil, fmt.Errorf("invalid response: %s", response)
+	}
+	return response, nil
+}
+
+// Sends a request, answering a digest authentication challenge when the device is protected.
+func (r httpRelay) do(newRequest func() (*http.Request, error)) ([]byte, error) {
+	request, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && r.Username != "" {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if request, err = newRequest(); err != nil {
+			return nil, err
+		}
+		authorization, err := digestAuthorization(challenge, request.Method, request.URL.RequestURI(), r.Username, r.Password)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", authorization)
+		if response, err = r.client.Do(request); err != nil {
+			return nil, err
+		}
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return body, nil
+}
+
+// Parses the parameters of a Digest challenge.
+func digestParams(challenge string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Digest "), ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+// Returns the Authorization header answering a Digest challenge (RFC 7616, qop=auth), as used by
+// Shelly Gen2 devices.
+func digestAuthorization(challenge string, method string, uri string, username string, password string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("unsupported authentication: %s", challenge)
+	}
+	params := digestParams(challenge)
+	var newHash func() hash.Hash
+	switch strings.ToUpper(params["algorithm"]) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %s", params["algorithm"])
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	cnonce := hex.EncodeToString(nonce)
+	const nc = "00000001"
+	ha1 := digest(username, params["realm"], password)
+	ha2 := digest(method, uri)
+	response := digest(ha1, params["nonce"], nc, cnonce, "auth", ha2)
+
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="%s", response="%s"`,
+		username, params["realm"], params["nonce"], uri, nc, cnonce, response)
+	if algorithm := params["algorithm"]; algorithm != "" {
+		authorization += fmt.Sprintf(", algorithm=%s", algorithm)
+	}
+	if opaque := params["opaque"]; opaque != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return authorization, nil
+}
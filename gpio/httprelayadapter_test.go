@@ -0,0 +1,193 @@
+package gpio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+// Stand-in for a Shelly Gen2 device, protected with digest authentication.
+type fakeShelly struct {
+	calls []map[string]interface{}
+	lock  sync.Mutex
+}
+
+func (s *fakeShelly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const realm, nonce = "shellyplus1-test", "5f3a"
+	params := digestParams(r.Header.Get("Authorization"))
+	sum := func(value string) string {
+		h := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(h[:])
+	}
+	ha1 := sum("admin:" + realm + ":secret")
+	ha2 := sum(r.Method + ":" + r.URL.RequestURI())
+	expected := sum(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if params["response"] != expected {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth", realm="%s", nonce="%s", algorithm=SHA-256`, realm, nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var call map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&call)
+	s.lock.Lock()
+	s.calls = append(s.calls, call)
+	s.lock.Unlock()
+	switch call["method"] {
+	case "Switch.Set":
+		fmt.Fprint(w, `{"id":1,"src":"shellyplus1-test","result":{"was_on":false}}`)
+	case "Sys.GetStatus":
+		fmt.Fprint(w, `{"id":1,"src":"shellyplus1-test","result":{"uptime":3600}}`)
+	default:
+		fmt.Fprint(w, `{"id":1,"error":{"code":404,"message":"No handler"}}`)
+	}
+}
+
+// Stand-in for a Tasmota device, failing the first requests.
+type fakeTasmota struct {
+	commands []string
+	failures int
+	lock     sync.Mutex
+}
+
+func (s *fakeTasmota) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	command := r.URL.Query().Get("cmnd")
+	s.commands = append(s.commands, command)
+	if r.URL.Path != "/cm" || r.URL.Query().Get("user") != "admin" || r.URL.Query().Get("password") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	power, state, _ := strings.Cut(command, " ")
+	fmt.Fprintf(w, `{"%s":"%s"}`, strings.ToUpper(power), state)
+}
+
+func init() {
+	retryDelay = 0
+}
+
+func TestShelly(t *testing.T) {
+	device := &fakeShelly{}
+	server := httptest.NewServer(device)
+	defer server.Close()
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"timer": {Type: config.OutputShelly, URL: server.URL, Channel: 1, Username: "admin", Password: "secret",
+			Timeout: time.Second, Pulse: 100 * time.Millisecond},
+	})
+
+	if err := adapter.WriteOutput(context.Background(), "timer", true); err != nil {
+		t.Fatalf("Error switching on: %v", err)
+	}
+	if err := adapter.WriteOutput(context.Background(), "timer", false); err != nil {
+		t.Fatalf("Error switching off: %v", err)
+	}
+	on, off := fmt.Sprint(device.calls[0]["params"]), fmt.Sprint(device.calls[1]["params"])
+	if on != "map[id:1 on:true toggle_after:0.1]" || off != "map[id:1 on:false]" {
+		t.Fatalf("Unexpected Switch.Set calls: %s, %s", on, off)
+	}
+}
+
+func TestShellyWrongPassword(t *testing.T) {
+	server := httptest.NewServer(&fakeShelly{})
+	defer server.Close()
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"timer": {Type: config.OutputShelly, URL: server.URL, Username: "admin", Password: "wrong", Timeout: time.Second},
+	})
+	if err := adapter.WriteOutput(context.Background(), "timer", true); err == nil {
+		t.Fatalf("Expected an error for a wrong password")
+	}
+}
+
+func TestTasmota(t *testing.T) {
+	device := &fakeTasmota{failures: 2}
+	server := httptest.NewServer(device)
+	defer server.Close()
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"away": {Type: config.OutputTasmota, URL: server.URL, Channel: 2, Username: "admin", Password: "secret",
+			Timeout: time.Second, Retries: 2},
+	})
+
+	if err := adapter.WriteOutput(context.Background(), "away", true); err != nil {
+		t.Fatalf("Expected the request to succeed after retries, got %v", err)
+	}
+	device.failures = 3
+	if err := adapter.WriteOutput(context.Background(), "away", false); err == nil {
+		t.Fatalf("Expected an error after exhausting the retries")
+	}
+	if fmt.Sprint(device.commands) != "[Power2 ON]" {
+		t.Fatalf("Unexpected commands: %v", device.commands)
+	}
+}
+
+func TestRelayHealthy(t *testing.T) {
+	shelly := httptest.NewServer(&fakeShelly{})
+	defer shelly.Close()
+	tasmota := &fakeTasmota{}
+	server := httptest.NewServer(tasmota)
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"timer": {Type: config.OutputShelly, URL: shelly.URL, Username: "admin", Password: "secret", Timeout: time.Second},
+		"away":  {Type: config.OutputTasmota, URL: server.URL, Channel: 1, Username: "admin", Password: "secret", Timeout: time.Second},
+		"auto":  {Type: config.OutputTasmota, URL: server.URL, Channel: 2, Username: "admin", Password: "secret", Timeout: time.Second},
+	})
+
+	if err := adapter.Healthy(); err != nil {
+		t.Fatalf("Expected the devices to be healthy, got %v", err)
+	}
+	if fmt.Sprint(tasmota.commands) != "[Status]" {
+		t.Fatalf("Expected a single Status command per device, got %v", tasmota.commands)
+	}
+	server.Close()
+	if err := adapter.Healthy(); err == nil {
+		t.Fatalf("Expected a device that does not respond to be unhealthy")
+	}
+}
+
+func TestNetworkTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"auto": {Type: config.OutputTasmota, URL: server.URL, Timeout: 50 * time.Millisecond},
+	})
+	if err := adapter.WriteOutput(context.Background(), "auto", true); err == nil {
+		t.Fatalf("Expected a timeout")
+	}
+}
+
+func TestRelayCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	adapter := NewHTTPRelayAdapter(map[string]config.OutputConfig{
+		"auto": {Type: config.OutputTasmota, URL: server.URL, Timeout: 10 * time.Second, Retries: 5},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := adapter.WriteOutput(ctx, "auto", true)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("Expected the write to fail with the context, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("Expected the context to cut the retries short, took %v", elapsed)
+	}
+}
@@ -0,0 +1,14 @@
+package gpio
+
+// I2CBus is the access to an I2C bus, as needed by the I2C adapters.
+type I2CBus interface {
+	// Write sends data to the device at a 7-bit address.
+	Write(address int, data []byte) error
+	// Read receives data from the device at a 7-bit address.
+	Read(address int, data []byte) error
+	// Close releases the bus.
+	Close() error
+}
+
+// Opens the I2C bus with the given number, e.g. /dev/i2c-1. Replaced by a fake bus in tests.
+var openI2CBus = openDevI2CBus
@@ -0,0 +1,69 @@
+package gpio
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctl request that selects the address of the device to talk to.
+const i2cSlave = 0x0703
+
+// I2C bus exposed by the i2c-dev kernel driver.
+type devI2CBus struct {
+	file    *os.File
+	address int
+	lock    sync.Mutex
+}
+
+func openDevI2CBus(bus int) (I2CBus, error) {
+	path := fmt.Sprintf("/dev/i2c-%d", bus)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("gpio: failed to open %s: %v", path, err)
+	}
+	return &devI2CBus{file: file, address: -1}, nil
+}
+
+// Selects the device to talk to. Called with the lock held.
+func (b *devI2CBus) selectAddress(address int) error {
+	if address != b.address {
+		if err := unix.IoctlSetInt(int(b.file.Fd()), i2cSlave, address); err != nil {
+			return fmt.Errorf("gpio: failed to select I2C address 0x%02x: %v", address, err)
+		}
+		b.address = address
+	}
+	return nil
+}
+
+func (b *devI2CBus) Write(address int, data []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.selectAddress(address); err != nil {
+		return err
+	}
+	if _, err := b.file.Write(data); err != nil {
+		return fmt.Errorf("gpio: failed to write to I2C address 0x%02x: %v", address, err)
+	}
+	return nil
+}
+
+func (b *devI2CBus) Read(address int, data []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.selectAddress(address); err != nil {
+		return err
+	}
+	if _, err := b.file.Read(data); err != nil {
+		return fmt.Errorf("gpio: failed to read from I2C address 0x%02x: %v", address, err)
+	}
+	return nil
+}
+
+func (b *devI2CBus) Close() error {
+	return b.file.Close()
+}
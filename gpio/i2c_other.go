@@ -0,0 +1,9 @@
+//go:build !linux
+
+package gpio
+
+import "fmt"
+
+func openDevI2CBus(bus int) (I2CBus, error) {
+	return nil, fmt.Errorf("gpio: I2C is only supported on Linux")
+}
@@ -0,0 +1,153 @@
+package gpio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Size of the buffer of the events channel.
+const eventBuffer = 16
+
+// InputEvent is a debounced change of an input.
+type InputEvent struct {
+	Name   string
+	Active bool
+	Time   time.Time
+}
+
+// InputAdapter specifies the interface for inputs, identified by the logical names configured in
+// gpio.inputs. Changes are delivered on the events channel, after the debounce period.
+type InputAdapter interface {
+	ReadInput(name string) (bool, error)
+	Inputs() []string
+	Events() <-chan InputEvent
+}
+
+// GetInputAdapter returns the input adapter for the configured driver, or nil when there are no
+// inputs or they could not be requested.
+func GetInputAdapter() InputAdapter {
+	inputs := config.GetGPIOInputs()
+	if len(inputs) == 0 {
+		return nil
+	}
+	var adapter InputAdapter
+	var err error
+	switch config.GetGPIODriver() {
+	case config.DriverCdev:
+		adapter, err = NewCdevInputAdapter(config.GetGPIOChip(), inputs)
+	case config.DriverRPIO:
+		adapter, err = NewRPiInputAdapter(inputs)
+	default:
+		adapter = NewGPIOMockInputAdapter(inputs)
+	}
+	if err != nil {
+		log.Error().Msgf("%v", err)
+		return nil
+	}
+	return adapter
+}
+
+// Error returned when reading an input that is not configured.
+func unknownInputError(name string) error {
+	return fmt.Errorf("gpio: unknown input %s", name)
+}
+
+// eventQueue delivers the events of an input adapter. Events are dropped rather than blocking the
+// inputs when nobody reads them.
+type eventQueue struct {
+	events chan InputEvent
+}
+
+func newEventQueue() eventQueue {
+	return eventQueue{events: make(chan InputEvent, eventBuffer)}
+}
+
+func (q eventQueue) emit(name string, active bool) {
+	select {
+	case q.events <- InputEvent{Name: name, Active: active, Time: time.Now()}:
+	default:
+		log.Warn().Msgf("Dropped event of input %s", name)
+	}
+}
+
+// Events returns the channel on which the changes of the inputs are delivered.
+func (q eventQueue) Events() <-chan InputEvent {
+	return q.events
+}
+
+// GPIOMockInputAdapter is a mock input adapter, whose inputs are changed by SetInput.
+type GPIOMockInputAdapter struct {
+	eventQueue
+	values map[string]bool
+	lock   sync.Mutex
+}
+
+// NewGPIOMockInputAdapter creates a new GPIOMockInputAdapter for the given inputs, all inactive.
+func NewGPIOMockInputAdapter(inputs map[string]config.InputConfig) *GPIOMockInputAdapter {
+	adapter := &GPIOMockInputAdapter{eventQueue: newEventQueue(), values: make(map[string]bool)}
+	for name := range inputs {
+		adapter.values[name] = false
+	}
+	return adapter
+}
+
+// SetInput changes an input, as if its pin changed.
+func (g *GPIOMockInputAdapter) SetInput(name string, active bool) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	value, ok := g.values[name]
+	if !ok {
+		return unknownInputError(name)
+	}
+	if value != active {
+		g.values[name] = active
+		log.Info().Bool("active", active).Str("input", name).Msg("Mock GPIO: input changed")
+		g.emit(name, active)
+	}
+	return nil
+}
+
+// ReadInput returns whether an input is active.
+func (g *GPIOMockInputAdapter) ReadInput(name string) (bool, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	value, ok := g.values[name]
+	if !ok {
+		return false, unknownInputError(name)
+	}
+	return value, nil
+}
+
+// Inputs returns the names of all inputs.
+func (g *GPIOMockInputAdapter) Inputs() []string {
+	return outputNames(g.values)
+}
+
+// debouncer tracks a polled value, which only changes once the samples are stable for a period.
+type debouncer struct {
+	value     bool
+	candidate bool
+	since     time.Time
+	period    time.Duration
+}
+
+func newDebouncer(value bool, period time.Duration) debouncer {
+	return debouncer{value: value, candidate: value, period: period}
+}
+
+// Adds a sample, and returns whether the value changed.
+func (d *debouncer) update(sample bool, now time.Time) bool {
+	if sample != d.candidate {
+		d.candidate = sample
+		d.since = now
+	}
+	if d.candidate != d.value && now.Sub(d.since) >= d.period {
+		d.value = d.candidate
+		return true
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package gpio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+func TestDebouncer(t *testing.T) {
+	start := time.Now()
+	d := newDebouncer(false, 50*time.Millisecond)
+	samples := []struct {
+		at      time.Duration
+		sample  bool
+		changed bool
+	}{
+		{0, true, false},
+		{20 * time.Millisecond, false, false}, // Bounce
+		{30 * time.Millisecond, true, false},
+		{70 * time.Millisecond, true, false},
+		{80 * time.Millisecond, true, true}, // Stable for 50ms
+		{90 * time.Millisecond, true, false},
+	}
+	for _, s := range samples {
+		if changed := d.update(s.sample, start.Add(s.at)); changed != s.changed {
+			t.Fatalf("Expected change %v at %v, got %v", s.changed, s.at, changed)
+		}
+	}
+	if !d.value {
+		t.Fatalf("Expected the debounced value to be active")
+	}
+}
+
+func TestMockInputs(t *testing.T) {
+	adapter := NewGPIOMockInputAdapter(map[string]config.InputConfig{"remote_speed_3": {}})
+	adapter.SetInput("remote_speed_3", true)
+	adapter.SetInput("remote_speed_3", true)
+	if event := <-adapter.Events(); event.Name != "remote_speed_3" || !event.Active {
+		t.Fatalf("Expected remote_speed_3 to become active, got %v", event)
+	}
+	if len(adapter.Events()) != 0 {
+		t.Fatalf("Expected no event without a change")
+	}
+	if err := adapter.SetInput("remote_bypass", true); err == nil {
+		t.Fatalf("Expected an error for an unknown input")
+	}
+}
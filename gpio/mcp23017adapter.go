@@ -0,0 +1,169 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// MCP23017 registers, in the default (IOCON.BANK = 0) layout. Port B follows port A.
+const (
+	mcp23017IODIR = 0x00 // I/O direction: 1 for input, 0 for output
+	mcp23017OLAT  = 0x14 // Output latch
+)
+
+// Port of an MCP23017, with a shadow copy of its output latch.
+type expanderPort struct {
+	bus     I2CBus
+	address int
+	port    int // 0 for port A, 1 for port B
+	olat    byte
+	outputs byte // Bits configured as outputs
+}
+
+// Output on an MCP23017 port.
+type expanderOutput struct {
+	port      *expanderPort
+	bit       int
+	activeLow bool
+}
+
+// MCP23017Adapter is an adapter for outputs on MCP23017 I2C GPIO expanders.
+type MCP23017Adapter struct {
+	outputs map[string]expanderOutput
+	ports   map[string]*expanderPort
+	buses   map[int]I2CBus
+	lock    sync.Mutex
+}
+
+// NewMCP23017Adapter creates a new MCP23017Adapter for the given expander outputs. The outputs are made
+// inactive before they are switched to output mode; the other pins of the expanders remain inputs.
+func NewMCP23017Adapter(outputs map[string]config.OutputConfig) (*MCP23017Adapter, error) {
+	adapter := &MCP23017Adapter{
+		outputs: make(map[string]expanderOutput),
+		ports:   make(map[string]*expanderPort),
+		buses:   make(map[int]I2CBus),
+	}
+	ports := adapter.ports
+	for _, name := range outputNames(outputs) {
+		output := outputs[name]
+		bus, ok := adapter.buses[output.Bus]
+		if !ok {
+			var err error
+			if bus, err = openI2CBus(output.Bus); err != nil {
+				adapter.Close()
+				return nil, err
+			}
+			adapter.buses[output.Bus] = bus
+		}
+		key := fmt.Sprintf("%d/%d/%s", output.Bus, output.Address, output.Port)
+		port, ok := ports[key]
+		if !ok {
+			port = &expanderPort{bus: bus, address: output.Address}
+			if output.Port == "b" {
+				port.port = 1
+			}
+			ports[key] = port
+		}
+		port.outputs |= 1 << output.Bit
+		if output.ActiveLow {
+			port.olat |= 1 << output.Bit
+		}
+		adapter.outputs[name] = expanderOutput{port: port, bit: output.Bit, activeLow: output.ActiveLow}
+	}
+
+	for _, key := range outputNames(ports) {
+		if err := ports[key].init(); err != nil {
+			adapter.Close()
+			return nil, err
+		}
+	}
+	return adapter, nil
+}
+
+// Writes the output latch, then switches the outputs of the port to output mode.
+func (p *expanderPort) init() error {
+	if err := p.write(mcp23017OLAT, p.olat); err != nil {
+		return err
+	}
+	return p.write(mcp23017IODIR, ^p.outputs)
+}
+
+// Read a register of the port.
+func (p *expanderPort) read(register int) (byte, error) {
+	if err := p.bus.Write(p.address, []byte{byte(register + p.port)}); err != nil {
+		return 0, fmt.Errorf("gpio: MCP23017 at 0x%02x: %v", p.address, err)
+	}
+	data := make([]byte, 1)
+	if err := p.bus.Read(p.address, data); err != nil {
+		return 0, fmt.Errorf("gpio: MCP23017 at 0x%02x: %v", p.address, err)
+	}
+	return data[0], nil
+}
+
+// Write a register of the port.
+func (p *expanderPort) write(register int, value byte) error {
+	if err := p.bus.Write(p.address, []byte{byte(register + p.port), value}); err != nil {
+		return fmt.Errorf("gpio: MCP23017 at 0x%02x: %v", p.address, err)
+	}
+	return nil
+}
+
+// WriteOutput writes a value to an output.
+func (a *MCP23017Adapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	olat := output.port.olat
+	if value != output.activeLow {
+		olat |= 1 << output.bit
+	} else {
+		olat &^= 1 << output.bit
+	}
+	if err := output.port.write(mcp23017OLAT, olat); err != nil {
+		return err
+	}
+	// Only update the shadow copy when the expander has the new value.
+	output.port.olat = olat
+	return nil
+}
+
+// Healthy checks that all expanders respond. An expander that lost its power comes back with all pins
+// as inputs; it is initialized again.
+func (a *MCP23017Adapter) Healthy() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, key := range outputNames(a.ports) {
+		port := a.ports[key]
+		iodir, err := port.read(mcp23017IODIR)
+		if err != nil {
+			return err
+		}
+		if iodir != ^port.outputs {
+			log.Warn().Msgf("MCP23017 at 0x%02x port %c was reset, initializing it again", port.address, 'A'+port.port)
+			if err := port.init(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Outputs returns the names of all outputs.
+func (a *MCP23017Adapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
+
+// Close releases the I2C buses.
+func (a *MCP23017Adapter) Close() {
+	for _, bus := range a.buses {
+		bus.Close()
+	}
+}
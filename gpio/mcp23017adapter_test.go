@@ -0,0 +1,110 @@
+package gpio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+func newTestExpander(t *testing.T) (*MCP23017Adapter, *fakeI2CBus) {
+	buses := fakeI2CBuses(t)
+	adapter, err := NewMCP23017Adapter(map[string]config.OutputConfig{
+		"speed_1": {Type: config.OutputMCP23017, Bus: 1, Address: 0x20, Port: "a", Bit: 0},
+		"speed_2": {Type: config.OutputMCP23017, Bus: 1, Address: 0x20, Port: "a", Bit: 3, ActiveLow: true},
+		"timer":   {Type: config.OutputMCP23017, Bus: 1, Address: 0x20, Port: "b", Bit: 7},
+	})
+	if err != nil {
+		t.Fatalf("Error creating expander adapter: %v", err)
+	}
+	return adapter, buses[1]
+}
+
+func TestMCP23017Init(t *testing.T) {
+	_, bus := newTestExpander(t)
+	// Latches first (outputs inactive), then the direction of the output bits.
+	expected := [][]byte{
+		{0x14, 0x08}, {0x00, 0xf6}, // Port A: bit 3 is active low
+		{0x15, 0x00}, {0x01, 0x7f}, // Port B
+	}
+	if writes := bus.writes[0x20]; !reflect.DeepEqual(writes, expected) {
+		t.Fatalf("Expected writes %x, got %x", expected, writes)
+	}
+}
+
+func TestMCP23017Write(t *testing.T) {
+	adapter, bus := newTestExpander(t)
+	bus.writes[0x20] = nil
+
+	adapter.WriteOutput(context.Background(), "speed_1", true)
+	adapter.WriteOutput(context.Background(), "speed_2", true)
+	adapter.WriteOutput(context.Background(), "timer", true)
+	adapter.WriteOutput(context.Background(), "speed_1", false)
+	expected := [][]byte{{0x14, 0x09}, {0x14, 0x01}, {0x15, 0x80}, {0x14, 0x00}}
+	if writes := bus.writes[0x20]; !reflect.DeepEqual(writes, expected) {
+		t.Fatalf("Expected writes %x, got %x", expected, writes)
+	}
+}
+
+func TestMCP23017Errors(t *testing.T) {
+	adapter, bus := newTestExpander(t)
+	bus.fail = true
+	if err := adapter.WriteOutput(context.Background(), "speed_1", true); err == nil {
+		t.Fatalf("Expected the bus error to be returned")
+	}
+	bus.fail = false
+	bus.writes[0x20] = nil
+	adapter.WriteOutput(context.Background(), "speed_2", true)
+	if writes := bus.writes[0x20]; !reflect.DeepEqual(writes, [][]byte{{0x14, 0x00}}) {
+		t.Fatalf("Expected the failed write not to change the latch, got %x", writes)
+	}
+
+	outputs := map[string]config.OutputConfig{"away": {Type: config.OutputMCP23017, Bus: 1, Address: 0x20}}
+	createErr := errTest
+	failed := adapterOrFailed(func() (*MCP23017Adapter, error) {
+		if createErr != nil {
+			return nil, createErr
+		}
+		return NewMCP23017Adapter(outputs)
+	}, outputs)
+	if err := failed.WriteOutput(context.Background(), "away", true); err != errTest {
+		t.Fatalf("Expected the creation error on write, got %v", err)
+	}
+	health := failed.(HealthAdapter)
+	if err := health.Healthy(); err != errTest {
+		t.Fatalf("Expected the creation error on the health check, got %v", err)
+	}
+
+	// The expander responds again.
+	createErr = nil
+	if err := health.Healthy(); err != nil {
+		t.Fatalf("Expected the adapter to be created, got %v", err)
+	}
+	if err := failed.WriteOutput(context.Background(), "away", true); err != nil {
+		t.Fatalf("Expected writes to succeed after recovery, got %v", err)
+	}
+}
+
+func TestMCP23017Healthy(t *testing.T) {
+	adapter, bus := newTestExpander(t)
+	if err := adapter.Healthy(); err != nil {
+		t.Fatalf("Expected the expander to be healthy, got %v", err)
+	}
+
+	// After a power loss, all pins are inputs and the latches are cleared.
+	adapter.WriteOutput(context.Background(), "timer", true)
+	bus.registers[0x20] = map[byte]byte{0x00: 0xff, 0x01: 0xff}
+	if err := adapter.Healthy(); err != nil {
+		t.Fatalf("Expected the expander to be initialized again, got %v", err)
+	}
+	expected := map[byte]byte{0x00: 0xf6, 0x01: 0x7f, 0x14: 0x08, 0x15: 0x80}
+	if registers := bus.registers[0x20]; !reflect.DeepEqual(registers, expected) {
+		t.Fatalf("Expected registers %x, got %x", expected, registers)
+	}
+
+	bus.fail = true
+	if err := adapter.Healthy(); err == nil {
+		t.Fatalf("Expected an expander that does not respond to be unhealthy")
+	}
+}
@@ -0,0 +1,117 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/modbus"
+)
+
+// ReadingsAdapter is implemented by adapters that can read values back from the unit, such as its fan
+// speed or temperatures.
+type ReadingsAdapter interface {
+	Readings() (map[string]float64, error)
+}
+
+// ModbusAdapter is an adapter for units with a Modbus interface. Each output is a holding register,
+// written with a fixed value when it becomes active, or with a percentage for analog outputs.
+type ModbusAdapter struct {
+	client   *modbus.Client
+	outputs  map[string]config.OutputConfig
+	readings map[string]config.ReadingConfig
+}
+
+// NewModbusAdapter creates a new ModbusAdapter for the given outputs and readings.
+func NewModbusAdapter(client *modbus.Client, outputs map[string]config.OutputConfig, readings map[string]config.ReadingConfig) *ModbusAdapter {
+	return &ModbusAdapter{client: client, outputs: outputs, readings: readings}
+}
+
+// Creates the Modbus client for the configured connection.
+func newModbusClient(connection config.ModbusConfig) *modbus.Client {
+	if connection.Transport == config.ModbusRTU {
+		return modbus.NewRTUClient(func() (modbus.Conn, error) {
+			return openSerial(connection.Device, connection.Baud)
+		}, connection.Unit, connection.Timeout)
+	}
+	return modbus.NewTCPClient(connection.Address, connection.Unit, connection.Timeout)
+}
+
+// WriteOutput writes the value of an output to its register. Outputs without an off value are left
+// as they are when they become inactive, as the unit only has a single mode register.
+func (a *ModbusAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	switch {
+	case value:
+		return a.write(ctx, output, output.Value)
+	case output.HasOffValue:
+		return a.write(ctx, output, output.OffValue)
+	default:
+		return nil
+	}
+}
+
+// WritePercent writes a percentage to the register of an analog output.
+func (a *ModbusAdapter) WritePercent(ctx context.Context, name string, percent float64) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	if !output.Analog() {
+		return notAnalogError(name)
+	}
+	return a.write(ctx, output, int(math.Round(percent*output.Scale)))
+}
+
+func (a *ModbusAdapter) write(ctx context.Context, output config.OutputConfig, value int) error {
+	if err := a.client.WriteRegister(ctx, output.Register, uint16(value)); err != nil {
+		return fmt.Errorf("gpio: failed to write register %d: %v", output.Register, err)
+	}
+	return nil
+}
+
+// Readings reads the configured readings. Readings that fail are left out, and the last error is returned.
+func (a *ModbusAdapter) Readings() (map[string]float64, error) {
+	values := make(map[string]float64)
+	var err error
+	for _, name := range outputNames(a.readings) {
+		reading := a.readings[name]
+		registers, readErr := a.client.ReadRegisters(reading.Register, 1, reading.Input)
+		if readErr != nil {
+			err = fmt.Errorf("gpio: failed to read %s: %v", name, readErr)
+			continue
+		}
+		value := float64(registers[0])
+		if reading.Signed {
+			value = float64(int16(registers[0]))
+		}
+		values[name] = value * reading.Scale
+	}
+	return values, err
+}
+
+// Healthy checks that the unit responds, by reading the register of the first output, or else of the
+// first reading.
+func (a *ModbusAdapter) Healthy() error {
+	register, input := 0, false
+	if names := outputNames(a.outputs); len(names) > 0 {
+		register = a.outputs[names[0]].Register
+	} else if names := outputNames(a.readings); len(names) > 0 {
+		register, input = a.readings[names[0]].Register, a.readings[names[0]].Input
+	} else {
+		return nil
+	}
+	if _, err := a.client.ReadRegisters(register, 1, input); err != nil {
+		return fmt.Errorf("gpio: failed to read register %d: %v", register, err)
+	}
+	return nil
+}
+
+// Outputs returns the names of all outputs.
+func (a *ModbusAdapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
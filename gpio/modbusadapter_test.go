@@ -0,0 +1,102 @@
+package gpio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/modbus"
+	"github.com/dlefevre/go.ventilation-service/modbus/modbustest"
+)
+
+// Starts a stand-in unit with a mode register at 10, a speed register at 11 and some readings, and
+// returns an adapter for it.
+func modbusTestAdapter(t *testing.T) (*ModbusAdapter, *modbustest.Server) {
+	server := modbustest.NewServer(1)
+	server.SetHolding(10, 0)
+	server.SetHolding(11, 0)
+	server.SetInput(20, 1380)
+	server.SetInput(21, 0xffec) // -20
+	address, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	client := modbus.NewTCPClient(address, 1, time.Second)
+	t.Cleanup(client.Close)
+	outputs := map[string]config.OutputConfig{
+		"speed_1": {Type: config.OutputModbus, Register: 10, Value: 1},
+		"speed_2": {Type: config.OutputModbus, Register: 10, Value: 2},
+		"away":    {Type: config.OutputModbus, Register: 10, Value: 0, OffValue: 1, HasOffValue: true},
+		"fan":     {Type: config.OutputModbus, Register: 11, Scale: 10},
+	}
+	readings := map[string]config.ReadingConfig{
+		"fan_rpm":     {Register: 20, Input: true, Scale: 1, Kind: "rpm"},
+		"outdoor":     {Register: 21, Input: true, Signed: true, Scale: 0.1, Kind: "temperature"},
+		"unavailable": {Register: 30, Scale: 1, Kind: "percent"},
+	}
+	return NewModbusAdapter(client, outputs, readings), server
+}
+
+func TestModbusOutputs(t *testing.T) {
+	adapter, server := modbusTestAdapter(t)
+	if err := adapter.WriteOutput(context.Background(), "speed_2", true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.Holding(10) != 2 {
+		t.Errorf("Expected mode 2, got %d", server.Holding(10))
+	}
+	// Releasing an output without an off value leaves the mode register alone.
+	adapter.WriteOutput(context.Background(), "speed_2", false)
+	if server.Holding(10) != 2 || len(server.Writes()) != 1 {
+		t.Errorf("Expected no write on release, got mode %d and writes %v", server.Holding(10), server.Writes())
+	}
+	adapter.WriteOutput(context.Background(), "away", true)
+	adapter.WriteOutput(context.Background(), "away", false)
+	if server.Holding(10) != 1 {
+		t.Errorf("Expected the off value 1, got %d", server.Holding(10))
+	}
+
+	if err := adapter.WritePercent(context.Background(), "fan", 42.5); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.Holding(11) != 425 {
+		t.Errorf("Expected 425, got %d", server.Holding(11))
+	}
+	if err := adapter.WritePercent(context.Background(), "speed_1", 50); err == nil {
+		t.Errorf("Expected an error for a register that is not analog")
+	}
+	if err := adapter.WriteOutput(context.Background(), "bypass", true); err == nil {
+		t.Errorf("Expected an error for an unknown output")
+	}
+}
+
+func TestModbusReadings(t *testing.T) {
+	adapter, _ := modbusTestAdapter(t)
+	composite := newCompositeAdapter(NewGPIOMockAdapter(), adapter)
+	values, err := composite.Readings()
+	if err == nil {
+		t.Errorf("Expected an error for the unavailable register")
+	}
+	expected := map[string]float64{"fan_rpm": 1380, "outdoor": -2}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestModbusHealthy(t *testing.T) {
+	adapter, server := modbusTestAdapter(t)
+	if err := adapter.Healthy(); err != nil {
+		t.Fatalf("Expected the unit to be healthy, got %v", err)
+	}
+	if len(server.Writes()) != 0 {
+		t.Errorf("Expected the health check not to write, got %v", server.Writes())
+	}
+	server.Close()
+	if err := adapter.Healthy(); err == nil {
+		t.Fatalf("Expected a unit that does not respond to be unhealthy")
+	}
+}
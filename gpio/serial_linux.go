@@ -0,0 +1,58 @@
+package gpio
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Termios speeds of the supported baud rates.
+var baudRates = map[int]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+}
+
+// Opens a serial device in raw mode, 8N1, at the given baud rate.
+func openSerial(path string, baud int) (*os.File, error) {
+	rate, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("gpio: unsupported baud rate %d", baud)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("gpio: failed to open %s: %v", path, err)
+	}
+	raw, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var termiosErr error
+	err = raw.Control(func(fd uintptr) {
+		var t *unix.Termios
+		if t, termiosErr = unix.IoctlGetTermios(int(fd), unix.TCGETS); termiosErr != nil {
+			return
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CBAUD
+		t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | rate
+		t.Ispeed, t.Ospeed = rate, rate
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		termiosErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err == nil {
+		err = termiosErr
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("gpio: failed to configure %s: %v", path, err)
+	}
+	return file, nil
+}
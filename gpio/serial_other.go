@@ -0,0 +1,12 @@
+//go:build !linux
+
+package gpio
+
+import (
+	"fmt"
+	"os"
+)
+
+func openSerial(path string, baud int) (*os.File, error) {
+	return nil, fmt.Errorf("gpio: serial devices are only supported on Linux")
+}
@@ -0,0 +1,234 @@
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/rs/zerolog/log"
+)
+
+// LCUS protocol.
+const (
+	lcusHeader      = 0xa0 // First byte of a relay frame: header, relay, state, checksum
+	lcusStatusQuery = 0xff // Asks the board for the status of all relays
+)
+
+// Time to wait for the status of the relays.
+const statusTimeout = 500 * time.Millisecond
+
+// Lines in the status response, e.g. "CH1:ON".
+var statusLine = regexp.MustCompile(`CH(\d+):\s*(ON|OFF)`)
+
+// Relay board on a serial device. The device is (re)opened on demand, so the board can be unplugged.
+type relayBoard struct {
+	path string
+	baud int
+	file *os.File
+	lock sync.Mutex
+}
+
+// Relay on a board.
+type relayOutput struct {
+	board  *relayBoard
+	relay  int
+	status bool
+}
+
+// USBRelayAdapter is an adapter for USB serial relay boards with LCUS (CH340) command frames.
+type USBRelayAdapter struct {
+	outputs map[string]relayOutput
+	boards  map[string]*relayBoard
+}
+
+// NewUSBRelayAdapter creates a new USBRelayAdapter for the given relay outputs. The devices are opened
+// on the first write, so a board that is not plugged in yet does not prevent startup.
+func NewUSBRelayAdapter(outputs map[string]config.OutputConfig) *USBRelayAdapter {
+	adapter := &USBRelayAdapter{
+		outputs: make(map[string]relayOutput),
+		boards:  make(map[string]*relayBoard),
+	}
+	for name, output := range outputs {
+		board, ok := adapter.boards[output.Device]
+		if !ok {
+			board = &relayBoard{path: output.Device, baud: output.Baud}
+			adapter.boards[output.Device] = board
+		}
+		adapter.outputs[name] = relayOutput{board: board, relay: output.Relay, status: output.Status}
+	}
+	return adapter
+}
+
+// Returns the frame that switches a relay.
+func relayFrame(relay int, on bool) []byte {
+	state := byte(0x00)
+	if on {
+		state = 0x01
+	}
+	return []byte{lcusHeader, byte(relay), state, lcusHeader + byte(relay) + state}
+}
+
+// Parses the status response of a board.
+func parseRelayStatus(response string) map[int]bool {
+	status := make(map[int]bool)
+	for _, match := range statusLine.FindAllStringSubmatch(response, -1) {
+		relay, _ := strconv.Atoi(match[1])
+		status[relay] = match[2] == "ON"
+	}
+	return status
+}
+
+// Opens the device if it is not open.
+func (b *relayBoard) open() error {
+	if b.file != nil {
+		return nil
+	}
+	file, err := openSerial(b.path, b.baud)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("USB relay: opened %s", b.path)
+	b.file = file
+	return nil
+}
+
+// Closes the device, after an error.
+func (b *relayBoard) reset() {
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+}
+
+// Writes a frame, reopening the device once when the write fails, e.g. because the board was unplugged
+// and plugged back in.
+func (b *relayBoard) write(frame []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = b.open(); err != nil {
+			continue
+		}
+		if _, err = b.file.Write(frame); err == nil {
+			return nil
+		}
+		log.Warn().Msgf("USB relay: write to %s failed, reconnecting: %v", b.path, err)
+		b.reset()
+	}
+	return fmt.Errorf("gpio: relay board %s: %v", b.path, err)
+}
+
+// Cuts the reads of a file short when the context is done, by moving its deadline. The returned function
+// stops watching the context, and must be called before the deadline is set again.
+func interruptReads(ctx context.Context, file *os.File) func() {
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		file.SetReadDeadline(time.Now())
+		close(interrupted)
+	})
+	return func() {
+		if !stop() {
+			<-interrupted
+		}
+	}
+}
+
+// Checks that the board is plugged in, and opens the device if it is not open.
+func (b *relayBoard) healthy() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, err := os.Stat(b.path); err != nil {
+		b.reset()
+		return fmt.Errorf("gpio: relay board %s: %v", b.path, err)
+	}
+	if err := b.open(); err != nil {
+		return fmt.Errorf("gpio: relay board %s: %v", b.path, err)
+	}
+	return nil
+}
+
+// Asks the board for the status of its relays. Waiting for the response is cut short when the context
+// is done.
+func (b *relayBoard) status(ctx context.Context, relay int) (bool, error) {
+	if err := b.write([]byte{lcusStatusQuery}); err != nil {
+		return false, err
+	}
+	file := b.file
+	file.SetReadDeadline(time.Now().Add(statusTimeout))
+	defer file.SetReadDeadline(time.Time{})
+	defer interruptReads(ctx, file)()
+
+	response := []byte{}
+	buf := make([]byte, 64)
+	for {
+		n, err := b.file.Read(buf)
+		response = append(response, buf[:n]...)
+		if on, ok := parseRelayStatus(string(response))[relay]; ok {
+			return on, nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return false, fmt.Errorf("gpio: relay board %s did not report the status of relay %d: %v", b.path, relay, err)
+		}
+	}
+}
+
+// WriteOutput switches a relay. For boards that report their status, the state of the relay is read back,
+// unless the context is done first.
+func (a *USBRelayAdapter) WriteOutput(ctx context.Context, name string, value bool) error {
+	output, ok := a.outputs[name]
+	if !ok {
+		return unknownOutputError(name)
+	}
+	board := output.board
+	board.lock.Lock()
+	defer board.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("gpio: relay board %s: %v", board.path, err)
+	}
+	if err := board.write(relayFrame(output.relay, value)); err != nil {
+		return err
+	}
+	if !output.status {
+		return nil
+	}
+	on, err := board.status(ctx, output.relay)
+	if err != nil {
+		return err
+	}
+	if on != value {
+		return fmt.Errorf("gpio: relay %d on %s did not switch", output.relay, board.path)
+	}
+	return nil
+}
+
+// Healthy checks that all boards are plugged in.
+func (a *USBRelayAdapter) Healthy() error {
+	for _, path := range outputNames(a.boards) {
+		if err := a.boards[path].healthy(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Outputs returns the names of all outputs.
+func (a *USBRelayAdapter) Outputs() []string {
+	return outputNames(a.outputs)
+}
+
+// Close closes the serial devices.
+func (a *USBRelayAdapter) Close() {
+	for _, board := range a.boards {
+		board.lock.Lock()
+		board.reset()
+		board.lock.Unlock()
+	}
+}
@@ -0,0 +1,199 @@
+//go:build linux
+
+package gpio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"golang.org/x/sys/unix"
+)
+
+// Relay board simulated on the master side of a pseudo-terminal.
+type fakeRelayBoard struct {
+	master *os.File
+	frames [][]byte
+	relays map[int]bool
+	stuck  bool // Relays do not switch
+	lock   sync.Mutex
+	done   chan bool
+}
+
+// Opens a pseudo-terminal pair, and returns the simulated board and the path of the slave device.
+func newFakeRelayBoard(t *testing.T) (*fakeRelayBoard, string) {
+	// Non-blocking, so the file is served by the poller and closing it ends a pending read.
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Skipf("No pseudo-terminals available: %v", err)
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("Error unlocking pseudo-terminal: %v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("Error getting pseudo-terminal number: %v", err)
+	}
+	board := &fakeRelayBoard{master: master, relays: make(map[int]bool), done: make(chan bool)}
+	go board.serve()
+	t.Cleanup(board.close)
+	return board, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func (b *fakeRelayBoard) serve() {
+	defer close(b.done)
+	buf := make([]byte, 64)
+	pending := []byte{}
+	for {
+		n, err := b.master.Read(buf)
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for len(pending) > 0 {
+			if pending[0] == lcusStatusQuery {
+				pending = pending[1:]
+				b.lock.Lock()
+				response := ""
+				for relay := 1; relay <= 4; relay++ {
+					state := "OFF"
+					if b.relays[relay] {
+						state = "ON"
+					}
+					response += fmt.Sprintf("CH%d:%s\r\n", relay, state)
+				}
+				b.lock.Unlock()
+				b.master.Write([]byte(response))
+				continue
+			}
+			if len(pending) < 4 {
+				break
+			}
+			b.lock.Lock()
+			b.frames = append(b.frames, append([]byte{}, pending[:4]...))
+			if !b.stuck {
+				b.relays[int(pending[1])] = pending[2] == 0x01
+			}
+			b.lock.Unlock()
+			pending = pending[4:]
+		}
+	}
+}
+
+func (b *fakeRelayBoard) close() {
+	b.master.Close()
+	<-b.done
+}
+
+// Returns the frames received, waiting a while for the expected number of frames to arrive.
+func (b *fakeRelayBoard) received(count int) [][]byte {
+	for i := 0; i < 100; i++ {
+		b.lock.Lock()
+		frames := b.frames
+		b.lock.Unlock()
+		if len(frames) >= count {
+			return frames
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestRelayFrames(t *testing.T) {
+	board, path := newFakeRelayBoard(t)
+	adapter := NewUSBRelayAdapter(map[string]config.OutputConfig{
+		"speed_1": {Type: config.OutputUSBRelay, Device: path, Baud: 9600, Relay: 1, Status: true},
+		"timer":   {Type: config.OutputUSBRelay, Device: path, Baud: 9600, Relay: 4, Status: true},
+	})
+	defer adapter.Close()
+
+	for _, write := range []struct {
+		name  string
+		value bool
+	}{{"speed_1", true}, {"timer", true}, {"speed_1", false}} {
+		if err := adapter.WriteOutput(context.Background(), write.name, write.value); err != nil {
+			t.Fatalf("Error writing %s: %v", write.name, err)
+		}
+	}
+	expected := [][]byte{{0xa0, 0x01, 0x01, 0xa2}, {0xa0, 0x04, 0x01, 0xa5}, {0xa0, 0x01, 0x00, 0xa1}}
+	if frames := board.received(len(expected)); !reflect.DeepEqual(frames, expected) {
+		t.Fatalf("Expected frames %x, got %x", expected, frames)
+	}
+}
+
+func TestRelayStatusMismatch(t *testing.T) {
+	board, path := newFakeRelayBoard(t)
+	board.stuck = true
+	adapter := NewUSBRelayAdapter(map[string]config.OutputConfig{
+		"away": {Type: config.OutputUSBRelay, Device: path, Baud: 9600, Relay: 2, Status: true},
+	})
+	defer adapter.Close()
+
+	if err := adapter.WriteOutput(context.Background(), "away", true); err == nil {
+		t.Fatalf("Expected an error when the relay does not switch")
+	}
+}
+
+func TestRelayReconnect(t *testing.T) {
+	// The adapter opens the board through a link, which is pointed at a new pseudo-terminal when the
+	// first one disappears.
+	link := filepath.Join(t.TempDir(), "ttyUSB0")
+	first, path := newFakeRelayBoard(t)
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatalf("Error creating link: %v", err)
+	}
+	adapter := NewUSBRelayAdapter(map[string]config.OutputConfig{
+		"auto": {Type: config.OutputUSBRelay, Device: link, Baud: 9600, Relay: 3},
+	})
+	defer adapter.Close()
+	if err := adapter.WriteOutput(context.Background(), "auto", true); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+
+	first.close()
+	second, path := newFakeRelayBoard(t)
+	os.Remove(link)
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatalf("Error creating link: %v", err)
+	}
+	if err := adapter.WriteOutput(context.Background(), "auto", false); err != nil {
+		t.Fatalf("Expected the adapter to reconnect, got %v", err)
+	}
+	if frames := second.received(1); !reflect.DeepEqual(frames, [][]byte{{0xa0, 0x03, 0x00, 0xa3}}) {
+		t.Fatalf("Expected the frame on the new board, got %x", frames)
+	}
+}
+
+func TestRelayUnplugged(t *testing.T) {
+	adapter := NewUSBRelayAdapter(map[string]config.OutputConfig{
+		"auto": {Type: config.OutputUSBRelay, Device: filepath.Join(t.TempDir(), "missing"), Baud: 9600, Relay: 1},
+	})
+	if err := adapter.WriteOutput(context.Background(), "auto", true); err == nil {
+		t.Fatalf("Expected an error for a missing device")
+	}
+}
+
+func TestRelayCanceledWrite(t *testing.T) {
+	board, path := newFakeRelayBoard(t)
+	adapter := NewUSBRelayAdapter(map[string]config.OutputConfig{
+		"auto": {Type: config.OutputUSBRelay, Device: path, Baud: 9600, Relay: 3, Status: true},
+	})
+	defer adapter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := adapter.WriteOutput(ctx, "auto", true); err == nil {
+		t.Fatalf("Expected an error for a canceled context")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if frames := board.received(0); len(frames) != 0 {
+		t.Fatalf("Expected no frame to be sent, got %x", frames)
+	}
+}
@@ -0,0 +1,222 @@
+package history
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Marker that separates the hashed part of a stored record from its hash.
+var hashMarker = []byte(`,"hash":"`)
+
+// Signature is the Ed25519 signature of the last record (the tip) of a day.
+type Signature struct {
+	Date      string `json:"date"`
+	Seq       uint64 `json:"seq"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// Report is the result of verifying the history log.
+type Report struct {
+	Valid      bool     `json:"valid"`
+	Records    int      `json:"records"`
+	FirstSeq   uint64   `json:"first_seq"`
+	LastSeq    uint64   `json:"last_seq"`
+	TipHash    string   `json:"tip_hash"`
+	Truncated  bool     `json:"truncated"`  // Older records were removed by log rotation
+	Signatures int      `json:"signatures"` // Number of valid day signatures
+	Problems   []string `json:"problems"`
+}
+
+func hashBytes(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Encodes a record as a single line. The hash covers the JSON encoding of the record without its hash,
+// which includes the hash of the previous record.
+func encodeRecord(record *Record) ([]byte, error) {
+	record.Hash = ""
+	body, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("history: unable to marshal record: %v", err)
+	}
+	record.Hash = hashBytes(body)
+	return json.Marshal(record)
+}
+
+// Splits a stored line in the hashed body and the stored hash. The body is taken from the raw line, so
+// records written by older versions keep verifying when fields are added.
+func splitHash(line []byte) ([]byte, string, bool) {
+	idx := bytes.LastIndex(line, hashMarker)
+	if idx < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+	body := append(append([]byte{}, line[:idx]...), '}')
+	return body, string(line[idx+len(hashMarker) : len(line)-2]), true
+}
+
+// Returns the payload that is signed for a day.
+func signaturePayload(date string, seq uint64, hash string) []byte {
+	return []byte(fmt.Sprintf("%s|%d|%s", date, seq, hash))
+}
+
+func signDay(key ed25519.PrivateKey, date string, seq uint64, hash string) Signature {
+	return Signature{
+		Date:      date,
+		Seq:       seq,
+		Hash:      hash,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, signaturePayload(date, seq, hash))),
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded (PKCS #8) Ed25519 private key, as generated by
+// `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("history: unable to parse private key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("history: %s is not an Ed25519 private key", path)
+	}
+	return edKey, nil
+}
+
+// LoadPublicKey reads a PEM encoded (PKIX) Ed25519 public key, as generated by `openssl pkey -pubout`.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("history: unable to parse public key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("history: %s is not an Ed25519 public key", path)
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("history: unable to read %s: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("history: %s does not contain a PEM block", path)
+	}
+	return block, nil
+}
+
+// Verify checks the hash chain of the history log at path, and the day signatures stored next to it.
+// Signatures are only checked when a public key is given.
+func Verify(path string, publicKey ed25519.PublicKey) (Report, error) {
+	report := Report{Problems: []string{}}
+	type tip struct {
+		hash string
+		date string
+	}
+	records := map[uint64]tip{}
+
+	err := readLines(path, func(line []byte) error {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("unreadable record after seq %d", report.LastSeq))
+			return nil
+		}
+		body, hash, ok := splitHash(line)
+		if !ok || hashBytes(body) != hash {
+			report.Problems = append(report.Problems, fmt.Sprintf("record %d was modified", record.Seq))
+		}
+		if report.Records == 0 {
+			report.FirstSeq = record.Seq
+			report.Truncated = record.Seq > 1
+			if record.Seq == 1 && record.PrevHash != "" {
+				report.Problems = append(report.Problems, "first record does not start the chain")
+			}
+		} else {
+			if record.Seq != report.LastSeq+1 {
+				report.Problems = append(report.Problems,
+					fmt.Sprintf("gap between record %d and record %d", report.LastSeq, record.Seq))
+			}
+			if record.PrevHash != report.TipHash {
+				report.Problems = append(report.Problems, fmt.Sprintf("record %d does not link to its predecessor", record.Seq))
+			}
+		}
+		report.Records++
+		report.LastSeq = record.Seq
+		report.TipHash = hash
+		records[record.Seq] = tip{hash: hash, date: dayOf(record.Time)}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if publicKey != nil {
+		err = readSignatures(signaturesPath(path), func(sig Signature) {
+			raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+			if err != nil || !ed25519.Verify(publicKey, signaturePayload(sig.Date, sig.Seq, sig.Hash), raw) {
+				report.Problems = append(report.Problems, fmt.Sprintf("invalid signature for %s", sig.Date))
+				return
+			}
+			if sig.Seq < report.FirstSeq && report.Truncated {
+				// The signed record was rotated out, nothing left to compare with.
+				report.Signatures++
+				return
+			}
+			record, ok := records[sig.Seq]
+			switch {
+			case !ok:
+				report.Problems = append(report.Problems, fmt.Sprintf("signed record %d (%s) is missing", sig.Seq, sig.Date))
+			case record.hash != sig.Hash || record.date != sig.Date:
+				report.Problems = append(report.Problems, fmt.Sprintf("signed record %d (%s) does not match", sig.Seq, sig.Date))
+			default:
+				report.Signatures++
+			}
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	report.Valid = len(report.Problems) == 0
+	return report, nil
+}
+
+// Returns the path of the signature file that belongs to a history log.
+func signaturesPath(path string) string {
+	return strings.TrimSuffix(path, ".log") + ".sig"
+}
+
+func readSignatures(path string, fn func(Signature)) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return readFile(path, func(line []byte) error {
+		var sig Signature
+		if err := json.Unmarshal(line, &sig); err != nil {
+			return fmt.Errorf("history: corrupt signature: %v", err)
+		}
+		fn(sig)
+		return nil
+	})
+}
@@ -0,0 +1,197 @@
+package history
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendRecords(t *testing.T, s *HistoryService, times ...time.Time) {
+	for _, tm := range times {
+		if err := s.Append(Record{Time: tm, Command: "speed2", Source: "web", Outcome: "executed"}); err != nil {
+			t.Fatalf("Error appending record: %v", err)
+		}
+	}
+}
+
+func rewriteLog(t *testing.T, path string, edit func(lines [][]byte) [][]byte) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading log: %v", err)
+	}
+	lines := edit(bytes.Split(bytes.TrimSpace(data), []byte("\n")))
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0o640); err != nil {
+		t.Fatalf("Error writing log: %v", err)
+	}
+}
+
+func TestChainIntact(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	now := time.Now()
+	appendRecords(t, s, now, now, now)
+
+	report, err := s.Verify()
+	if err != nil {
+		t.Fatalf("Error verifying history: %v", err)
+	}
+	if !report.Valid || report.Records != 3 || report.LastSeq != 3 {
+		t.Fatalf("Expected an intact chain of 3 records, got %+v", report)
+	}
+}
+
+func TestChainSurvivesRestart(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	appendRecords(t, s, time.Now())
+	s.Stop()
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error restarting history service: %v", err)
+	}
+	appendRecords(t, s, time.Now())
+
+	report, _ := s.Verify()
+	if !report.Valid || report.LastSeq != 2 {
+		t.Fatalf("Expected an intact chain of 2 records, got %+v", report)
+	}
+}
+
+func TestChainSurvivesPartialRecord(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	appendRecords(t, s, time.Now(), time.Now())
+	s.Stop()
+	partial := []byte(`{"seq":3,"time":"2025-03-01T`)
+	if err := appendFile(s.path, partial); err != nil {
+		t.Fatalf("Error writing partial record: %v", err)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Expected the history to start despite a partial record, got %v", err)
+	}
+	if moved, _ := os.ReadFile(s.path + ".corrupt"); !bytes.Equal(moved, partial) {
+		t.Fatalf("Expected the partial record to be moved aside, got %q", moved)
+	}
+	appendRecords(t, s, time.Now())
+	report, _ := s.Verify()
+	if !report.Valid || report.LastSeq != 3 {
+		t.Fatalf("Expected the chain to continue after the last intact record, got %+v", report)
+	}
+}
+
+func TestQuerySkipsCorruptRecord(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	now := time.Now()
+	appendRecords(t, s, now, now, now)
+	rewriteLog(t, s.path, func(lines [][]byte) [][]byte {
+		lines[1] = lines[1][:20]
+		return lines
+	})
+
+	records, err := s.Query(Filter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected the 2 intact records, got %d records and %v", len(records), err)
+	}
+	if report, _ := s.Verify(); report.Valid {
+		t.Fatalf("Expected the corrupt record to be reported, got %+v", report)
+	}
+}
+
+func TestChainDetectsEdit(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	now := time.Now()
+	appendRecords(t, s, now, now, now)
+	rewriteLog(t, s.path, func(lines [][]byte) [][]byte {
+		lines[1] = bytes.Replace(lines[1], []byte("speed2"), []byte("speed1"), 1)
+		return lines
+	})
+
+	report, _ := s.Verify()
+	if report.Valid || len(report.Problems) != 1 {
+		t.Fatalf("Expected the modified record to be reported, got %+v", report)
+	}
+}
+
+func TestChainDetectsGap(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	now := time.Now()
+	appendRecords(t, s, now, now, now)
+	rewriteLog(t, s.path, func(lines [][]byte) [][]byte {
+		return [][]byte{lines[0], lines[2]}
+	})
+
+	report, _ := s.Verify()
+	if report.Valid {
+		t.Fatalf("Expected the removed record to be reported, got %+v", report)
+	}
+}
+
+func TestChainToleratesRotation(t *testing.T) {
+	s := newTestService(t, 512, 1)
+	for i := 0; i < 20; i++ {
+		appendRecords(t, s, time.Now())
+	}
+
+	report, _ := s.Verify()
+	if !report.Valid || !report.Truncated || report.LastSeq != 20 {
+		t.Fatalf("Expected a valid, truncated chain, got %+v", report)
+	}
+}
+
+func TestDaySignatures(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	s := newTestService(t, 1024*1024, 3)
+	s.signingKey = privateKey
+	s.verifyKey = publicKey
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	appendRecords(t, s, day, day, day.Add(24*time.Hour), day.Add(48*time.Hour))
+	s.Stop()
+
+	report, err := Verify(s.path, publicKey)
+	if err != nil {
+		t.Fatalf("Error verifying history: %v", err)
+	}
+	if !report.Valid || report.Signatures != 3 {
+		t.Fatalf("Expected 3 valid day signatures, got %+v", report)
+	}
+
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if report, _ := Verify(s.path, otherKey); report.Valid {
+		t.Fatalf("Expected signatures to fail with another key")
+	}
+
+	// Rewriting the whole chain from the second day on is detected through the signature.
+	rewriteLog(t, s.path, func(lines [][]byte) [][]byte {
+		return lines[:3]
+	})
+	if report, _ := Verify(s.path, publicKey); report.Valid {
+		t.Fatalf("Expected the missing signed record to be reported")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	os.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	der, _ = x509.MarshalPKIXPublicKey(publicKey)
+	os.WriteFile(filepath.Join(dir, "pub.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	loadedPrivate, err := LoadPrivateKey(filepath.Join(dir, "key.pem"))
+	if err != nil || !loadedPrivate.Equal(privateKey) {
+		t.Fatalf("Error loading private key: %v", err)
+	}
+	loadedPublic, err := LoadPublicKey(filepath.Join(dir, "pub.pem"))
+	if err != nil || !loadedPublic.Equal(publicKey) {
+		t.Fatalf("Error loading public key: %v", err)
+	}
+	if _, err := LoadPublicKey(filepath.Join(dir, "key.pem")); err == nil {
+		t.Fatalf("Expected an error loading a private key as public key")
+	}
+}
@@ -0,0 +1,299 @@
+package history
+
+import (
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	instance *HistoryService
+	once     sync.Once
+)
+
+// Record is a single entry in the command history. Records form a chain: each record contains the hash
+// of its predecessor, and its own hash is computed over all other fields.
+type Record struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Command    string    `json:"command"`
+	Source     string    `json:"source"`
+	Identity   string    `json:"identity,omitempty"`
+	Priority   string    `json:"priority,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Enqueued   time.Time `json:"enqueued"`
+	DurationMs int64     `json:"duration_ms"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash,omitempty"` // Must remain the last field, see encodeRecord
+}
+
+// Filter selects records from the history. Zero values match everything.
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	Source string
+}
+
+// HistoryService records every command handled by the controller in a rotating append-only log.
+// When a signing key is configured, the tip of every day is signed.
+type HistoryService struct {
+	path       string
+	maxSize    int64
+	maxFiles   int
+	signingKey ed25519.PrivateKey
+	verifyKey  ed25519.PublicKey
+	log        *logFile
+	lock       sync.Mutex
+	lastSeq    uint64
+	lastHash   string
+	lastDay    string
+	signedSeq  uint64
+}
+
+// GetHistoryService returns the one and only HistoryService instance.
+func GetHistoryService() *HistoryService {
+	once.Do(func() {
+		instance = newHistoryService(
+			LogPath(),
+			int64(config.GetHistoryMaxSize())*1024,
+			config.GetHistoryMaxFiles(),
+		)
+		if path := config.GetHistorySigningKey(); path != "" {
+			key, err := LoadPrivateKey(path)
+			if err != nil {
+				panic(err)
+			}
+			instance.signingKey = key
+			instance.verifyKey = key.Public().(ed25519.PublicKey)
+		}
+		if path := config.GetHistoryVerifyKey(); path != "" {
+			key, err := LoadPublicKey(path)
+			if err != nil {
+				panic(err)
+			}
+			instance.verifyKey = key
+		}
+		controller.GetVentilationControllerService().AddCommandListener(instance.commandListener)
+	})
+	return instance
+}
+
+// LogPath returns the configured path of the (current) history log.
+func LogPath() string {
+	return filepath.Join(config.GetDataDir(), "history", "history.log")
+}
+
+// Creates a new HistoryService object.
+func newHistoryService(path string, maxSize int64, maxFiles int) *HistoryService {
+	return &HistoryService{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+}
+
+// Start opens the history log, and restores the tip of the chain. A partial last record, left by a
+// crash, is moved aside; other corrupt records are skipped, and reported by Verify.
+func (s *HistoryService) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dropped, err := repairTail(s.path, func(line []byte) bool {
+		return json.Unmarshal(line, &Record{}) == nil
+	})
+	if err != nil {
+		return err
+	}
+	if dropped != nil {
+		log.Warn().Msgf("History: moved the corrupt last record to %s.corrupt: %q", s.path, dropped)
+	}
+
+	s.lastSeq, s.lastHash, s.lastDay = 0, "", ""
+	err = readLines(s.path, func(line []byte) error {
+		record, ok := parseRecord(line)
+		if ok {
+			s.lastSeq, s.lastHash, s.lastDay = record.Seq, record.Hash, dayOf(record.Time)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.signedSeq = s.lastSeq
+
+	l, err := openLogFile(s.path, s.maxSize, s.maxFiles)
+	if err != nil {
+		return err
+	}
+	s.log = l
+	return nil
+}
+
+// Stop signs the current tip of the chain and closes the history log.
+func (s *HistoryService) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.lastSeq != s.signedSeq {
+		if err := s.sign(s.lastDay); err != nil {
+			log.Error().Msgf("%v", err)
+		}
+	}
+	if s.log != nil {
+		if err := s.log.close(); err != nil {
+			log.Error().Msgf("history: error closing log: %v", err)
+		}
+		s.log = nil
+	}
+}
+
+// Converts a controller command record to a history record.
+func (s *HistoryService) commandListener(cr controller.CommandRecord) {
+	record := Record{
+		Time:       time.Now().UTC(),
+		Command:    cr.Command,
+		Source:     cr.Origin.Source,
+		Identity:   cr.Origin.Identity,
+		Priority:   cr.Priority,
+		Outcome:    cr.Outcome,
+		Enqueued:   cr.Enqueued,
+		DurationMs: cr.Duration.Milliseconds(),
+	}
+	if err := s.Append(record); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+}
+
+// Append links a record to the chain and writes it to the history log.
+func (s *HistoryService) Append(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.log == nil {
+		return fmt.Errorf("history: log is not open")
+	}
+	day := dayOf(record.Time)
+	if s.lastDay != "" && day != s.lastDay && s.lastSeq != s.signedSeq {
+		if err := s.sign(s.lastDay); err != nil {
+			log.Error().Msgf("%v", err)
+		}
+	}
+
+	record.Seq = s.lastSeq + 1
+	record.PrevHash = s.lastHash
+	line, err := encodeRecord(&record)
+	if err != nil {
+		return err
+	}
+	if err := s.log.append(line); err != nil {
+		return err
+	}
+	s.lastSeq, s.lastHash, s.lastDay = record.Seq, record.Hash, day
+	return nil
+}
+
+// Sign the current tip of the chain as the tip of the given day, when a signing key is configured.
+func (s *HistoryService) sign(day string) error {
+	if s.signingKey == nil {
+		return nil
+	}
+	line, err := json.Marshal(signDay(s.signingKey, day, s.lastSeq, s.lastHash))
+	if err != nil {
+		return fmt.Errorf("history: unable to marshal signature: %v", err)
+	}
+	file, err := os.OpenFile(signaturesPath(s.path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("history: unable to open signature file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("history: unable to write signature: %v", err)
+	}
+	s.signedSeq = s.lastSeq
+	return nil
+}
+
+// Verify checks the integrity of the history log, and the day signatures when a key is configured.
+func (s *HistoryService) Verify() (Report, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return Verify(s.path, s.verifyKey)
+}
+
+// Query returns all records matching the filter, from oldest to newest.
+func (s *HistoryService) Query(filter Filter) ([]Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := []Record{}
+	err := readLines(s.path, func(line []byte) error {
+		if record, ok := parseRecord(line); ok && filter.matches(record) {
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Parses a record, logging and skipping one that is corrupt.
+func parseRecord(line []byte) (Record, bool) {
+	var record Record
+	if err := json.Unmarshal(line, &record); err != nil {
+		log.Warn().Msgf("History: skipping corrupt record: %v", err)
+		return record, false
+	}
+	return record, true
+}
+
+// Returns the (UTC) day of a timestamp, as used for signing.
+func dayOf(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func (f Filter) matches(record Record) bool {
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && record.Time.After(f.To) {
+		return false
+	}
+	if f.Source != "" && record.Source != f.Source {
+		return false
+	}
+	return true
+}
+
+// WriteCSV writes the records as CSV, including a header line.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seq", "time", "command", "source", "identity", "priority", "outcome", "enqueued", "duration_ms", "prev_hash", "hash"})
+	for _, r := range records {
+		cw.Write([]string{
+			strconv.FormatUint(r.Seq, 10),
+			r.Time.Format(time.RFC3339Nano),
+			r.Command,
+			r.Source,
+			r.Identity,
+			r.Priority,
+			r.Outcome,
+			r.Enqueued.Format(time.RFC3339Nano),
+			strconv.FormatInt(r.DurationMs, 10),
+			r.PrevHash,
+			r.Hash,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
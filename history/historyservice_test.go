@@ -0,0 +1,113 @@
+package history
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+)
+
+func init() {
+	// Set the environment variable for the configuration path
+	os.Setenv("VENTILATIONSERVICE_CONFIG_PATH", "..")
+}
+
+func newTestService(t *testing.T, maxSize int64, maxFiles int) *HistoryService {
+	s := newHistoryService(filepath.Join(t.TempDir(), "history.log"), maxSize, maxFiles)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting history service: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	base := time.Date(2025, 3, 1, 3, 0, 0, 0, time.UTC)
+	for i, source := range []string{controller.SourceWeb, controller.SourceMQTT, controller.SourceWeb} {
+		if err := s.Append(Record{
+			Time:    base.Add(time.Duration(i) * time.Hour),
+			Command: "speed3",
+			Source:  source,
+			Outcome: controller.OutcomeExecuted,
+		}); err != nil {
+			t.Fatalf("Error appending record: %v", err)
+		}
+	}
+
+	records, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Error querying history: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+
+	records, _ = s.Query(Filter{Source: controller.SourceWeb})
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 web records, got %d", len(records))
+	}
+
+	records, _ = s.Query(Filter{From: base.Add(30 * time.Minute), To: base.Add(90 * time.Minute)})
+	if len(records) != 1 || records[0].Source != controller.SourceMQTT {
+		t.Fatalf("Expected the mqtt record only, got %+v", records)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	s := newTestService(t, 512, 2)
+	for i := 0; i < 50; i++ {
+		if err := s.Append(Record{Time: time.Now(), Command: fmt.Sprintf("cmd%d", i), Source: controller.SourceWeb}); err != nil {
+			t.Fatalf("Error appending record: %v", err)
+		}
+	}
+
+	if files := logFiles(s.path); len(files) != 3 {
+		t.Fatalf("Expected 3 log files, got %v", files)
+	}
+	records, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Error querying history: %v", err)
+	}
+	if len(records) == 0 || len(records) == 50 {
+		t.Fatalf("Expected the oldest records to be rotated out, got %d records", len(records))
+	}
+	if records[len(records)-1].Command != "cmd49" {
+		t.Fatalf("Expected the newest record last, got %s", records[len(records)-1].Command)
+	}
+}
+
+func TestCommandListener(t *testing.T) {
+	s := newTestService(t, 1024*1024, 3)
+	s.commandListener(controller.CommandRecord{
+		Command:  controller.CmdTimer60,
+		Origin:   controller.Origin{Source: controller.SourceMQTT, Identity: "ha"},
+		Duration: 700 * time.Millisecond,
+		Outcome:  controller.OutcomeExecuted,
+	})
+
+	records, _ := s.Query(Filter{})
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	if r := records[0]; r.Command != "timer60" || r.Identity != "ha" || r.DurationMs != 700 {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	records := []Record{{Time: time.Now(), Command: "away", Source: controller.SourceWeb, Identity: "api_keys[0]"}}
+	if err := WriteCSV(&buf, records); err != nil {
+		t.Fatalf("Error writing CSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "seq,time,command,source") {
+		t.Fatalf("Unexpected CSV output: %s", buf.String())
+	}
+}
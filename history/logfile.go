@@ -0,0 +1,181 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// logFile is an append-only file that is rotated once it grows beyond maxSize bytes.
+// Rotated files are named <name>.1 (most recent) up to <name>.<maxFiles>.
+type logFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Opens (or creates) the log file at the given path.
+func openLogFile(path string, maxSize int64, maxFiles int) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("history: unable to create directory: %v", err)
+	}
+	l := &logFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("history: unable to open %s: %v", l.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("history: unable to stat %s: %v", l.path, err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Append a single line to the log, rotating first if the line would exceed the maximum size.
+func (l *logFile) append(line []byte) error {
+	if l.size > 0 && l.size+int64(len(line))+1 > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(append(line, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("history: unable to write to %s: %v", l.path, err)
+	}
+	return l.file.Sync()
+}
+
+// Shift all rotated files one position, dropping the oldest, and start a new file.
+func (l *logFile) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("history: unable to close %s: %v", l.path, err)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("history: unable to rotate %s: %v", l.path, err)
+	}
+	return l.open()
+}
+
+func (l *logFile) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Repairs the last line of the log file, which is partial when the service stopped while appending.
+// A last line that is not valid is moved to <name>.corrupt, and a valid one without newline is
+// completed. Returns the line that was moved aside, if any.
+func repairTail(path string, valid func(line []byte) bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || len(data) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: unable to read %s: %v", path, err)
+	}
+	complete := data[len(data)-1] == '\n'
+	start := bytes.LastIndexByte(bytes.TrimSuffix(data, []byte{'\n'}), '\n') + 1
+	last := bytes.TrimSuffix(data[start:], []byte{'\n'})
+	if valid(last) {
+		if complete {
+			return nil, nil
+		}
+		return nil, appendFile(path, []byte{'\n'})
+	}
+	if err := appendFile(path+".corrupt", data[start:]); err != nil {
+		return nil, err
+	}
+	if err := os.Truncate(path, int64(start)); err != nil {
+		return nil, fmt.Errorf("history: unable to truncate %s: %v", path, err)
+	}
+	return last, nil
+}
+
+// Appends data to a file, creating it if needed.
+func appendFile(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("history: unable to open %s: %v", name, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("history: unable to write to %s: %v", name, err)
+	}
+	return nil
+}
+
+// Returns the names of all log files, from oldest to newest. Rotated files are probed until the first
+// missing one, so the list does not depend on the configured number of files.
+func logFiles(path string) []string {
+	files := []string{}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append([]string{name}, files...)
+	}
+	return files
+}
+
+// Calls fn for every line in the log files, from oldest to newest.
+func readLines(path string, fn func(line []byte) error) error {
+	for _, name := range logFiles(path) {
+		if err := readFile(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, fn func(line []byte) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("history: unable to open %s: %v", name, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("history: unable to read %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// Package testenv prepares the environment for tests of packages that create the service singletons.
+package testenv
+
+import (
+	"os"
+	"testing"
+)
+
+// Run runs the tests with the persistent data in a temporary directory, so they leave nothing behind.
+// The directory is set before the singletons are created, and removed afterwards. Returns the exit code.
+func Run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "ventilation-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("VENTILATIONSERVICE_DATA_DIR", dir)
+	return m.Run()
+}
@@ -5,26 +5,84 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/dlefevre/go.ventilation-service/config"
 	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/energy"
+	"github.com/dlefevre/go.ventilation-service/history"
+	"github.com/dlefevre/go.ventilation-service/maintenance"
 	"github.com/dlefevre/go.ventilation-service/mqtt"
+	"github.com/dlefevre/go.ventilation-service/stats"
 	"github.com/dlefevre/go.ventilation-service/web"
 
 	"github.com/rs/zerolog/log"
 )
 
+// Time the command in progress may take to finish when shutting down.
+const stopTimeout = 5 * time.Second
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(verifyCommand(os.Args[2:]))
+		case "service":
+			os.Exit(serviceCommand(os.Args[2:]))
+		}
+	}
+	os.Exit(run())
+}
+
+// Runs the service until it is interrupted, and returns the exit code. Once the controller is started,
+// errors return instead of exiting, so the deferred Stop makes all outputs inactive.
+func run() int {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	log.Info().Msg("Verifying configuration")
-	config.Verify()
+	if err := config.Verify(); err != nil {
+		log.Fatal().Msgf("Invalid configuration: %v", err)
+	}
+
+	log.Info().Msg("Opening command history")
+	hs := history.GetHistoryService()
+	if err := hs.Start(); err != nil {
+		log.Fatal().Msgf("Error opening command history: %v", err)
+	}
+	defer hs.Stop()
+
+	log.Info().Msg("Loading runtime statistics")
+	ss := stats.GetStatsService()
+	if err := ss.Start(); err != nil {
+		log.Fatal().Msgf("Error loading runtime statistics: %v", err)
+	}
+	defer ss.Stop()
+
+	log.Info().Msg("Loading maintenance state")
+	mt := maintenance.GetMaintenanceService()
+	if err := mt.Start(); err != nil {
+		log.Fatal().Msgf("Error loading maintenance state: %v", err)
+	}
+	defer mt.Stop()
+
+	log.Info().Msg("Loading energy counters")
+	es := energy.GetEnergyService()
+	if err := es.Start(); err != nil {
+		log.Fatal().Msgf("Error loading energy counters: %v", err)
+	}
+	defer es.Stop()
 
 	log.Info().Msg("Starting Door Controller Service")
 	dc := controller.GetVentilationControllerService()
 	dc.Start()
-	defer dc.Stop()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		if err := dc.Stop(ctx); err != nil {
+			log.Warn().Msgf("%v", err)
+		}
+	}()
 
 	log.Info().Msg("Starting Web Service")
 	ws := web.GetWebService()
@@ -35,10 +93,12 @@ func main() {
 		log.Info().Msg("Setting connection to MQTT Broker")
 		ms := mqtt.GetMQTTService()
 		if err := ms.Connect(ctx); err != nil {
-			log.Fatal().Msgf("Error connecting to MQTT broker: %v", err)
+			log.Error().Msgf("Error connecting to MQTT broker: %v", err)
+			return 1
 		}
 	}
 
 	<-ctx.Done()
 	log.Info().Msg("Shutting down")
+	return 0
 }
@@ -0,0 +1,193 @@
+package maintenance
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/persist"
+	"github.com/rs/zerolog/log"
+)
+
+// Interval at which the runtime is saved.
+const saveInterval = time.Minute
+
+var (
+	instance *MaintenanceService
+	once     sync.Once
+)
+
+// FilterStatus describes the state of the filters.
+type FilterStatus struct {
+	WeightedHours float64   `json:"weighted_hours"`
+	IntervalHours float64   `json:"interval_hours"`
+	PercentUsed   float64   `json:"percent_used"`
+	Due           bool      `json:"due"`
+	ReplacedAt    time.Time `json:"replaced_at,omitempty"`
+}
+
+// Persisted form of the filter state.
+type snapshot struct {
+	WeightedHours float64   `json:"weighted_hours"`
+	ReplacedAt    time.Time `json:"replaced_at,omitempty"`
+}
+
+// MaintenanceService accumulates the weighted runtime of the unit, to tell when the filters are due.
+type MaintenanceService struct {
+	path      string
+	interval  float64
+	weights   map[string]float64
+	filter    snapshot
+	mode      string
+	since     time.Time
+	due       bool
+	listeners []func(FilterStatus)
+	lock      sync.Mutex
+	stop      chan bool
+	wg        sync.WaitGroup
+}
+
+// GetMaintenanceService returns the one and only MaintenanceService instance.
+func GetMaintenanceService() *MaintenanceService {
+	once.Do(func() {
+		instance = newMaintenanceService(
+			filepath.Join(config.GetDataDir(), "maintenance.json"),
+			config.GetMaintenanceFilterInterval(),
+			config.GetMaintenanceFilterWeights(),
+		)
+		controller.GetVentilationControllerService().AddStateListener(instance.stateListener)
+	})
+	return instance
+}
+
+// Creates a new MaintenanceService object.
+func newMaintenanceService(path string, interval float64, weights map[string]float64) *MaintenanceService {
+	return &MaintenanceService{
+		path:     path,
+		interval: interval,
+		weights:  weights,
+		mode:     controller.ModeUnknown,
+		since:    time.Now(),
+	}
+}
+
+// Start loads the persisted runtime, and starts saving it periodically.
+func (s *MaintenanceService) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := persist.Load(s.path, &s.filter); err != nil {
+		return err
+	}
+	s.since = time.Now()
+	s.due = s.status().Due
+	s.stop = make(chan bool)
+	s.wg.Add(1)
+	go s.saveLoop(s.stop)
+	return nil
+}
+
+// Stop saves the runtime and stops the periodic saving. Stopping a service that did not
+// start has no effect.
+func (s *MaintenanceService) Stop() {
+	s.lock.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.lock.Unlock()
+	if stop == nil {
+		return // Not started, so there is nothing to save
+	}
+	close(stop)
+	s.wg.Wait()
+	s.update(time.Now())
+}
+
+func (s *MaintenanceService) saveLoop(stop chan bool) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.update(time.Now())
+		}
+	}
+}
+
+// Accumulate the runtime up to now, save it, and notify the listeners when the filters became due.
+func (s *MaintenanceService) update(now time.Time) {
+	s.lock.Lock()
+	s.accumulate(now)
+	if err := persist.Save(s.path, s.filter); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+	status := s.status()
+	becameDue := status.Due && !s.due
+	s.due = status.Due
+	listeners := s.listeners
+	s.lock.Unlock()
+
+	if becameDue {
+		log.Warn().Msgf("maintenance: filters are due (%.0f weighted hours)", status.WeightedHours)
+		for _, listener := range listeners {
+			listener(status)
+		}
+	}
+}
+
+// Add the weighted time spent in the current mode up to now.
+func (s *MaintenanceService) accumulate(now time.Time) {
+	if now.After(s.since) {
+		s.filter.WeightedHours += now.Sub(s.since).Hours() * s.weights[s.mode]
+	}
+	s.since = now
+}
+
+func (s *MaintenanceService) status() FilterStatus {
+	return FilterStatus{
+		WeightedHours: s.filter.WeightedHours,
+		IntervalHours: s.interval,
+		PercentUsed:   100 * s.filter.WeightedHours / s.interval,
+		Due:           s.filter.WeightedHours >= s.interval,
+		ReplacedAt:    s.filter.ReplacedAt,
+	}
+}
+
+func (s *MaintenanceService) stateListener(state controller.State) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	s.mode = state.Mode
+}
+
+// FilterStatus returns the current state of the filters.
+func (s *MaintenanceService) FilterStatus() FilterStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	return s.status()
+}
+
+// ResetFilter records the replacement of the filters, and restarts the runtime count.
+func (s *MaintenanceService) ResetFilter() (FilterStatus, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.accumulate(time.Now())
+	s.filter = snapshot{ReplacedAt: time.Now()}
+	s.due = false
+	log.Info().Msg("maintenance: filter replacement recorded")
+	return s.status(), persist.Save(s.path, s.filter)
+}
+
+// AddDueListener registers a function that is called when the filters become due.
+func (s *MaintenanceService) AddDueListener(listener func(FilterStatus)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
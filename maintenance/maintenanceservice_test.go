@@ -0,0 +1,96 @@
+package maintenance
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+)
+
+func init() {
+	// Set the environment variable for the configuration path
+	os.Setenv("VENTILATIONSERVICE_CONFIG_PATH", "..")
+}
+
+func newTestService(t *testing.T) *MaintenanceService {
+	return newMaintenanceService(filepath.Join(t.TempDir(), "maintenance.json"), 10, map[string]float64{
+		controller.ModeSpeed1: 0.5,
+		controller.ModeSpeed3: 2,
+	})
+}
+
+func TestWeightedRuntime(t *testing.T) {
+	s := newTestService(t)
+	start := time.Now()
+	s.since = start
+	s.mode = controller.ModeSpeed1
+	s.accumulate(start.Add(4 * time.Hour))
+	s.mode = controller.ModeSpeed3
+	s.accumulate(start.Add(6 * time.Hour))
+	s.mode = controller.ModeUnknown
+	s.accumulate(start.Add(10 * time.Hour))
+
+	status := s.status()
+	if math.Abs(status.WeightedHours-6) > 1e-9 || math.Abs(status.PercentUsed-60) > 1e-9 || status.Due {
+		t.Fatalf("Expected 6 weighted hours (60%%), got %+v", status)
+	}
+}
+
+func TestDueAndReset(t *testing.T) {
+	s := newTestService(t)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting maintenance service: %v", err)
+	}
+	defer s.Stop()
+
+	notified := 0
+	s.AddDueListener(func(FilterStatus) { notified++ })
+	s.lock.Lock()
+	s.mode = controller.ModeSpeed3
+	s.since = time.Now().Add(-6 * time.Hour)
+	s.lock.Unlock()
+	s.update(time.Now())
+	s.update(time.Now())
+	if notified != 1 || !s.FilterStatus().Due {
+		t.Fatalf("Expected a single due notification, got %d", notified)
+	}
+
+	status, err := s.ResetFilter()
+	if err != nil {
+		t.Fatalf("Error resetting filter: %v", err)
+	}
+	if status.Due || status.WeightedHours != 0 || status.ReplacedAt.IsZero() {
+		t.Fatalf("Expected a fresh filter, got %+v", status)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	s := newTestService(t)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting maintenance service: %v", err)
+	}
+	s.lock.Lock()
+	s.filter.WeightedHours = 3
+	s.lock.Unlock()
+	s.Stop()
+
+	restored := newMaintenanceService(s.path, 10, nil)
+	if err := restored.Start(); err != nil {
+		t.Fatalf("Error restarting maintenance service: %v", err)
+	}
+	defer restored.Stop()
+	if restored.FilterStatus().WeightedHours != 3 {
+		t.Fatalf("Expected the runtime to survive a restart, got %+v", restored.FilterStatus())
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	s := newTestService(t)
+	s.Stop()
+	if _, err := os.Stat(s.path); !os.IsNotExist(err) {
+		t.Fatalf("Expected a service that did not start not to save, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Function codes.
+const (
+	FuncReadHolding   = 0x03 // FuncReadHolding reads holding registers
+	FuncReadInput     = 0x04 // FuncReadInput reads input registers
+	FuncWriteRegister = 0x06 // FuncWriteRegister writes a single holding register
+)
+
+// Exception codes.
+const (
+	ExceptionIllegalFunction = 0x01 // ExceptionIllegalFunction is returned for unsupported functions
+	ExceptionIllegalAddress  = 0x02 // ExceptionIllegalAddress is returned for unknown registers
+)
+
+// Conn is the connection a client talks over: a TCP connection or a serial line.
+type Conn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// Error is an exception reported by the unit.
+type Error struct {
+	Function  byte
+	Exception byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("modbus: function 0x%02x failed with exception %d", e.Function, e.Exception)
+}
+
+// Client is a Modbus TCP or RTU client. The connection is (re)established on demand.
+type Client struct {
+	rtu     bool
+	dial    func(ctx context.Context) (Conn, error)
+	conn    Conn
+	unit    byte
+	timeout time.Duration
+	txID    uint16
+	lock    sync.Mutex
+}
+
+// NewTCPClient creates a client for a unit at a TCP address (host:port).
+func NewTCPClient(address string, unit int, timeout time.Duration) *Client {
+	return &Client{
+		dial: func(ctx context.Context) (Conn, error) {
+			dialer := net.Dialer{Timeout: timeout}
+			return dialer.DialContext(ctx, "tcp", address)
+		},
+		unit:    byte(unit),
+		timeout: timeout,
+	}
+}
+
+// NewRTUClient creates a client for a unit on a serial line, opened by the given function.
+func NewRTUClient(open func() (Conn, error), unit int, timeout time.Duration) *Client {
+	dial := func(context.Context) (Conn, error) {
+		return open()
+	}
+	return &Client{rtu: true, dial: dial, unit: byte(unit), timeout: timeout}
+}
+
+// ReadRegisters reads consecutive holding (or input) registers.
+func (c *Client) ReadRegisters(address int, count int, input bool) ([]uint16, error) {
+	function := byte(FuncReadHolding)
+	if input {
+		function = FuncReadInput
+	}
+	request := []byte{function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(request[1:], uint16(address))
+	binary.BigEndian.PutUint16(request[3:], uint16(count))
+	response, err := c.transact(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 2+2*count || int(response[1]) != 2*count {
+		return nil, fmt.Errorf("modbus: unexpected response length %d", len(response))
+	}
+	values := make([]uint16, count)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(response[2+2*i:])
+	}
+	return values, nil
+}
+
+// WriteRegister writes a single holding register. Waiting for the response is cut short when the
+// context is done.
+func (c *Client) WriteRegister(ctx context.Context, address int, value uint16) error {
+	request := []byte{FuncWriteRegister, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(request[1:], uint16(address))
+	binary.BigEndian.PutUint16(request[3:], value)
+	response, err := c.transact(ctx, request)
+	if err != nil {
+		return err
+	}
+	if string(response) != string(request) {
+		return fmt.Errorf("modbus: unexpected response to write")
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset()
+}
+
+func (c *Client) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Sends a request PDU and returns the response PDU. The connection is dropped after an error, so the
+// next request reconnects. When the context is done, the request is not sent or the wait for the
+// response is cut short.
+func (c *Client) transact(ctx context.Context, request []byte) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("modbus: %w", err)
+	}
+	if c.conn == nil {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: failed to connect: %v", err)
+		}
+		c.conn = conn
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	stop := interruptReads(ctx, c.conn)
+	var response []byte
+	var err error
+	if c.rtu {
+		response, err = c.transactRTU(request)
+	} else {
+		response, err = c.transactTCP(request)
+	}
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("modbus: %w", ctx.Err())
+	}
+	if err != nil {
+		c.reset()
+		return nil, err
+	}
+	if response[0] == request[0]|0x80 {
+		return nil, &Error{Function: request[0], Exception: response[1]}
+	}
+	if response[0] != request[0] {
+		c.reset()
+		return nil, fmt.Errorf("modbus: response to function 0x%02x for 0x%02x", response[0], request[0])
+	}
+	return response, nil
+}
+
+func (c *Client) transactTCP(request []byte) ([]byte, error) {
+	c.txID++
+	frame := make([]byte, 7, 7+len(request))
+	binary.BigEndian.PutUint16(frame[0:], c.txID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(request)+1))
+	frame[6] = c.unit
+	frame = append(frame, request...)
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header[0:]) != c.txID || length < 3 || length > 254 {
+		return nil, fmt.Errorf("modbus: invalid response header")
+	}
+	response := make([]byte, length-1)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	return response, nil
+}
+
+func (c *Client) transactRTU(request []byte) ([]byte, error) {
+	frame := append([]byte{c.unit}, request...)
+	frame = binary.LittleEndian.AppendUint16(frame, CRC(frame))
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+
+	// The length of the response follows from the function code: exceptions have one byte of data,
+	// reads a byte count, and writes echo the request.
+	response := make([]byte, 3)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	remaining := 2
+	switch {
+	case response[1]&0x80 != 0:
+	case response[1] == FuncReadHolding || response[1] == FuncReadInput:
+		remaining += int(response[2])
+	default:
+		remaining += 3
+	}
+	rest := make([]byte, remaining)
+	if _, err := io.ReadFull(c.conn, rest); err != nil {
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	response = append(response, rest...)
+	body := response[:len(response)-2]
+	if CRC(body) != binary.LittleEndian.Uint16(response[len(response)-2:]) {
+		return nil, fmt.Errorf("modbus: CRC error")
+	}
+	if body[0] != c.unit {
+		return nil, fmt.Errorf("modbus: response from unit %d", body[0])
+	}
+	return body[1:], nil
+}
+
+// Cuts the reads of a connection short when the context is done, by moving its deadline. The returned
+// function stops watching the context, and must be called before the deadline is set again.
+func interruptReads(ctx context.Context, conn Conn) func() {
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+		close(interrupted)
+	})
+	return func() {
+		if !stop() {
+			<-interrupted
+		}
+	}
+}
+
+// CRC returns the Modbus RTU CRC-16 of the data.
+func CRC(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,140 @@
+package modbus_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/modbus"
+	"github.com/dlefevre/go.ventilation-service/modbus/modbustest"
+)
+
+func newTestServer() *modbustest.Server {
+	server := modbustest.NewServer(1)
+	server.SetHolding(100, 0)
+	server.SetInput(200, 1450)
+	server.SetInput(201, 0xfff6) // -10
+	return server
+}
+
+func testClient(t *testing.T, client *modbus.Client, server *modbustest.Server) {
+	if err := client.WriteRegister(context.Background(), 100, 3); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.Holding(100) != 3 {
+		t.Errorf("Expected holding register 100 to be 3, got %d", server.Holding(100))
+	}
+	values, err := client.ReadRegisters(200, 2, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if values[0] != 1450 || int16(values[1]) != -10 {
+		t.Errorf("Unexpected input registers %v", values)
+	}
+
+	var modbusErr *modbus.Error
+	if _, err := client.ReadRegisters(300, 1, false); !errors.As(err, &modbusErr) || modbusErr.Exception != modbus.ExceptionIllegalAddress {
+		t.Errorf("Expected an illegal address exception, got %v", err)
+	}
+	if err := client.WriteRegister(context.Background(), 300, 1); !errors.As(err, &modbusErr) {
+		t.Errorf("Expected an exception, got %v", err)
+	}
+	// The connection survives exceptions.
+	if _, err := client.ReadRegisters(100, 1, false); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	server := newTestServer()
+	address, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer server.Close()
+
+	client := modbus.NewTCPClient(address, 1, time.Second)
+	defer client.Close()
+	testClient(t, client, server)
+}
+
+func TestTCPReconnect(t *testing.T) {
+	server := newTestServer()
+	address, err := server.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client := modbus.NewTCPClient(address, 1, 200*time.Millisecond)
+	defer client.Close()
+	if err := client.WriteRegister(context.Background(), 100, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Drop the connection from the client side, as after a failed request.
+	modbus.CloseConn(client)
+	if err := client.WriteRegister(context.Background(), 100, 2); err == nil {
+		t.Errorf("Expected an error on the closed connection")
+	}
+	if err := client.WriteRegister(context.Background(), 100, 2); err != nil {
+		t.Errorf("Expected the client to reconnect, got %v", err)
+	}
+	server.Close()
+}
+
+func TestRTU(t *testing.T) {
+	server := newTestServer()
+	client := modbus.NewRTUClient(func() (modbus.Conn, error) {
+		clientSide, serverSide := net.Pipe()
+		go server.ServeRTU(serverSide)
+		return clientSide, nil
+	}, 1, time.Second)
+	defer client.Close()
+	testClient(t, client, server)
+}
+
+func TestRTUTimeout(t *testing.T) {
+	// A server for another unit id ignores the requests.
+	server := modbustest.NewServer(2)
+	client := modbus.NewRTUClient(func() (modbus.Conn, error) {
+		clientSide, serverSide := net.Pipe()
+		go server.ServeRTU(serverSide)
+		return clientSide, nil
+	}, 1, 100*time.Millisecond)
+	defer client.Close()
+	if err := client.WriteRegister(context.Background(), 100, 1); err == nil {
+		t.Errorf("Expected a timeout")
+	}
+}
+
+func TestCRC(t *testing.T) {
+	// Read 2 holding registers at 0 from unit 1.
+	if crc := modbus.CRC([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x02}); crc != 0x0bc4 {
+		t.Errorf("Expected CRC 0x0bc4, got 0x%04x", crc)
+	}
+}
+
+func TestWriteCanceled(t *testing.T) {
+	// A server for another unit id ignores the requests, so only the context ends the wait.
+	server := modbustest.NewServer(2)
+	client := modbus.NewRTUClient(func() (modbus.Conn, error) {
+		clientSide, serverSide := net.Pipe()
+		go server.ServeRTU(serverSide)
+		return clientSide, nil
+	}, 1, 10*time.Second)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if err := client.WriteRegister(ctx, 100, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the write to be canceled, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the context to cut the wait short, took %v", elapsed)
+	}
+	if err := client.WriteRegister(ctx, 100, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected no write with a canceled context, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+package modbus
+
+// CloseConn drops the connection of a client, as after a failed request.
+func CloseConn(c *Client) {
+	c.conn.Close()
+}
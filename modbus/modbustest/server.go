@@ -0,0 +1,177 @@
+// Package modbustest provides an in-process Modbus unit for tests.
+package modbustest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/dlefevre/go.ventilation-service/modbus"
+)
+
+// Server is a minimal in-process Modbus unit, with holding and input registers in memory. It stands in
+// for a ventilation unit in tests.
+type Server struct {
+	unit     byte
+	holding  map[uint16]uint16
+	input    map[uint16]uint16
+	writes   []uint16 // Addresses of the written registers, in order
+	listener net.Listener
+	conns    map[net.Conn]bool
+	lock     sync.Mutex
+}
+
+// NewServer creates a server for a unit id.
+func NewServer(unit int) *Server {
+	return &Server{
+		unit:    byte(unit),
+		holding: make(map[uint16]uint16),
+		input:   make(map[uint16]uint16),
+		conns:   make(map[net.Conn]bool),
+	}
+}
+
+// SetHolding sets a holding register.
+func (s *Server) SetHolding(address int, value uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.holding[uint16(address)] = value
+}
+
+// SetInput sets an input register.
+func (s *Server) SetInput(address int, value uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.input[uint16(address)] = value
+}
+
+// Holding returns a holding register.
+func (s *Server) Holding(address int) uint16 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.holding[uint16(address)]
+}
+
+// Writes returns the addresses of the written registers, in order.
+func (s *Server) Writes() []uint16 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]uint16{}, s.writes...)
+}
+
+// ListenTCP serves Modbus TCP on an address, e.g. 127.0.0.1:0, and returns the address it listens on.
+func (s *Server) ListenTCP(address string) (string, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	s.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveTCP(conn)
+		}
+	}()
+	return listener.Addr().String(), nil
+}
+
+// Close stops listening, and closes the open connections.
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) serveTCP(conn net.Conn) {
+	s.lock.Lock()
+	s.conns[conn] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		if header[6] != s.unit {
+			continue
+		}
+		response := s.handle(request)
+		binary.BigEndian.PutUint16(header[4:], uint16(len(response)+1))
+		if _, err := conn.Write(append(header, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// ServeRTU serves Modbus RTU on a connection, e.g. one end of a pipe, until it is closed.
+func (s *Server) ServeRTU(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	for {
+		// Requests are 8 bytes: unit, function, 2 x 2 bytes of data and the CRC.
+		frame := make([]byte, 8)
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			return
+		}
+		if modbus.CRC(frame[:6]) != binary.LittleEndian.Uint16(frame[6:]) || frame[0] != s.unit {
+			continue
+		}
+		response := append([]byte{s.unit}, s.handle(frame[1:6])...)
+		response = binary.LittleEndian.AppendUint16(response, modbus.CRC(response))
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// Handles a request PDU, and returns the response PDU.
+func (s *Server) handle(request []byte) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	function := request[0]
+	address := binary.BigEndian.Uint16(request[1:])
+	value := binary.BigEndian.Uint16(request[3:])
+	switch function {
+	case modbus.FuncReadHolding, modbus.FuncReadInput:
+		registers := s.holding
+		if function == modbus.FuncReadInput {
+			registers = s.input
+		}
+		response := []byte{function, byte(2 * value)}
+		for i := uint16(0); i < value; i++ {
+			register, ok := registers[address+i]
+			if !ok {
+				return []byte{function | 0x80, modbus.ExceptionIllegalAddress}
+			}
+			response = binary.BigEndian.AppendUint16(response, register)
+		}
+		return response
+	case modbus.FuncWriteRegister:
+		if _, ok := s.holding[address]; !ok {
+			return []byte{function | 0x80, modbus.ExceptionIllegalAddress}
+		}
+		s.holding[address] = value
+		s.writes = append(s.writes, address)
+		return request
+	default:
+		return []byte{function | 0x80, modbus.ExceptionIllegalFunction}
+	}
+}
@@ -0,0 +1,30 @@
+package mqtt
+
+import (
+	"math"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/energy"
+	"github.com/dlefevre/go.ventilation-service/stats"
+	"github.com/rs/zerolog/log"
+)
+
+// Sensors for the estimated energy consumption. The total shows up in the Home Assistant Energy dashboard.
+func energySensors() []sensor {
+	return []sensor{
+		{component: "sensor", key: "energy_total", name: "Energy", unit: "kWh", deviceClass: "energy", stateClass: "total_increasing"},
+		{component: "sensor", key: "energy_cost_today", name: "Energy cost today", unit: config.GetEnergyCurrency(), deviceClass: "monetary", stateClass: "total"},
+	}
+}
+
+// Adds the estimated energy consumption to the state payload.
+func addEnergyState(payload map[string]interface{}) {
+	es := energy.GetEnergyService()
+	payload["energy_total"] = math.Round(es.TotalKWh()*1000) / 1000
+	today, err := es.Query(stats.PeriodDay)
+	if err != nil {
+		log.Error().Msgf("failed to query energy consumption: %v", err)
+		return
+	}
+	payload["energy_cost_today"] = math.Round(today.Cost*100) / 100
+}
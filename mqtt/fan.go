@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Returns the topics on which Home Assistant switches the fan on or off, and sets its percentage.
+func fanTopics() (string, string) {
+	base := fmt.Sprintf("%s/fan/%s", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID())
+	return base + "/set", base + "/percentage"
+}
+
+// Adds the level of the analog output to the state payload.
+func addFanState(payload map[string]interface{}) {
+	dc := controller.GetVentilationControllerService()
+	if dc.HasAnalog() {
+		payload["percent"] = dc.GetState().Percent
+	}
+}
+
+// Publish the discovery payload of the fan entity, for units with analog speed control.
+func (s *MQTTManager) publishFanDiscoveryPayload() {
+	if !controller.GetVentilationControllerService().HasAnalog() {
+		return
+	}
+	commandTopic, percentageTopic := fanTopics()
+	s.publishJSON(fmt.Sprintf("%s/fan/%sfan/config", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()), map[string]interface{}{
+		"unique_id":                 "fan",
+		"name":                      "Ventilation",
+		"command_topic":             commandTopic,
+		"state_topic":               s.stateTopic,
+		"state_value_template":      "{{ 'ON' if value_json.percent > 0 else 'OFF' }}",
+		"percentage_command_topic":  percentageTopic,
+		"percentage_state_topic":    s.stateTopic,
+		"percentage_value_template": "{{ value_json.percent | round(0) }}",
+		"speed_range_min":           1,
+		"speed_range_max":           100,
+		"payload_on":                "ON",
+		"payload_off":               "OFF",
+		"device":                    devicePayload(),
+	}, true)
+}
+
+// Handles the fan command and percentage topics. Switching the fan on selects medium ventilation.
+func handleFan(packet *paho.Publish) error {
+	dc := controller.GetVentilationControllerService()
+	commandTopic, _ := fanTopics()
+	payload := strings.TrimSpace(string(packet.Payload))
+	if packet.Topic == commandTopic {
+		switch payload {
+		case "ON":
+			return dc.SendCommand(controller.CmdSpeed2, origin(packet))
+		case "OFF":
+			return dc.SetPercent(0, origin(packet))
+		default:
+			return fmt.Errorf("invalid fan command: %s", payload)
+		}
+	}
+	percent, err := strconv.ParseFloat(payload, 64)
+	if err != nil {
+		return fmt.Errorf("invalid fan percentage: %s", payload)
+	}
+	return dc.SetPercent(percent, origin(packet))
+}
@@ -0,0 +1,69 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/gpio"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/rs/zerolog/log"
+)
+
+// Returns the topic on which the changes of an input are published.
+func inputTopic(name string) string {
+	return fmt.Sprintf("%s/device_automation/%s/%s", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID(), name)
+}
+
+// Sensors for units with inputs.
+func inputSensors() []sensor {
+	if len(controller.GetVentilationControllerService().Inputs()) == 0 {
+		return nil
+	}
+	return []sensor{{component: "binary_sensor", key: "externally_changed", name: "Changed outside of the service"}}
+}
+
+// Adds whether the mode was changed outside of the service to the state payload.
+func addInputState(payload map[string]interface{}) {
+	dc := controller.GetVentilationControllerService()
+	if len(dc.Inputs()) > 0 {
+		payload["externally_changed"] = onOff(dc.GetState().External)
+	}
+}
+
+// Publish the discovery payloads of the device triggers of the inputs: one for becoming active, and
+// one for becoming inactive.
+func (s *MQTTManager) publishInputDiscoveryPayloads() {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	for _, name := range controller.GetVentilationControllerService().Inputs() {
+		for triggerType, payload := range map[string]string{"turn_on": "ON", "turn_off": "OFF"} {
+			s.publishJSON(fmt.Sprintf("%s/device_automation/%s%s_%s/config", prefix, id, name, triggerType), map[string]interface{}{
+				"automation_type": "trigger",
+				"topic":           inputTopic(name),
+				"type":            triggerType,
+				"subtype":         name,
+				"payload":         payload,
+				"device":          devicePayload(),
+			}, true)
+		}
+	}
+}
+
+// Called by the controller whenever an input changes. Publishing happens in the background, as
+// listeners should not block.
+func (s *MQTTManager) inputListener(event gpio.InputEvent) {
+	if s.connectionManager == nil {
+		return
+	}
+	message := &paho.Publish{
+		Topic:   inputTopic(event.Name),
+		Payload: []byte(onOff(event.Active)),
+		QoS:     1,
+	}
+	go func() {
+		if _, err := s.connectionManager.Publish(context.Background(), message); err != nil {
+			log.Error().Msgf("failed to publish to MQTT topic %s: %v", message.Topic, err)
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Payloads on the lockout topic. A tripped lockout is only released by an explicit reset, so "OFF" from
+// e.g. a smoke detector that clears is ignored.
+const (
+	tripPayload  = "trip"
+	resetPayload = "reset"
+)
+
+// Reason of a lockout tripped on MQTT.
+const lockoutReason = "tripped on MQTT"
+
+// Returns the topic to trip and reset the safety lockout on.
+func lockoutTopic() string {
+	return fmt.Sprintf("%s/button/%s/lockout", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID())
+}
+
+// Sensors for the safety lockout.
+func lockoutSensors() []sensor {
+	return []sensor{
+		{component: "binary_sensor", key: "lockout", name: "Safety lockout", deviceClass: "problem"},
+		{component: "sensor", key: "lockout_reason", name: "Safety lockout reason"},
+	}
+}
+
+// Adds the safety lockout to the state payload.
+func addLockoutState(payload map[string]interface{}) {
+	status := controller.GetVentilationControllerService().LockoutStatus()
+	payload["lockout"] = onOff(status.Tripped)
+	payload["lockout_reason"] = status.Reason
+}
+
+// Publish the discovery payload of the button that resets the safety lockout.
+func (s *MQTTManager) publishLockoutDiscoveryPayload() {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	s.publishJSON(fmt.Sprintf("%s/button/%slockout_reset/config", prefix, id), map[string]interface{}{
+		"unique_id":     "lockout_reset",
+		"name":          "Reset safety lockout",
+		"command_topic": lockoutTopic(),
+		"payload_press": resetPayload,
+		"device":        devicePayload(),
+	}, true)
+}
+
+// Handles a message on the lockout topic: "trip" (or "ON") trips the lockout, "reset" releases it.
+func handleLockout(packet *paho.Publish) error {
+	dc := controller.GetVentilationControllerService()
+	payload := strings.TrimSpace(string(packet.Payload))
+	switch payload {
+	case tripPayload, "ON":
+		dc.Trip(lockoutReason, origin(packet))
+		return nil
+	case resetPayload:
+		return dc.Reset(origin(packet))
+	case "OFF":
+		return nil
+	}
+	return fmt.Errorf("invalid payload on lockout topic: %s", payload)
+}
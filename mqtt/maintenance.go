@@ -0,0 +1,72 @@
+package mqtt
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/maintenance"
+	"github.com/rs/zerolog/log"
+)
+
+// Payload on the action topic that records a filter replacement.
+const filterResetAction = "filter_reset"
+
+// Sensors for the filter state.
+func maintenanceSensors() []sensor {
+	return []sensor{
+		{component: "sensor", key: "filter_used", name: "Filter used", unit: "%", stateClass: "measurement"},
+		{component: "binary_sensor", key: "filter_due", name: "Filter replacement due", deviceClass: "problem"},
+	}
+}
+
+// Adds the filter state to the state payload.
+func addMaintenanceState(payload map[string]interface{}) {
+	status := maintenance.GetMaintenanceService().FilterStatus()
+	payload["filter_used"] = math.Round(status.PercentUsed*10) / 10
+	payload["filter_due"] = onOff(status.Due)
+}
+
+// Formats a boolean the way binary sensors expect it.
+func onOff(value bool) string {
+	if value {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// Publish the discovery payloads of the filter reset button and the maintenance event entity.
+func (s *MQTTManager) publishMaintenanceDiscoveryPayloads() {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	s.publishJSON(fmt.Sprintf("%s/button/%s%s/config", prefix, id, filterResetAction), map[string]interface{}{
+		"unique_id":        filterResetAction,
+		"command_topic":    s.actionTopic,
+		"command_template": filterResetAction,
+		"name":             "Filter replaced",
+		"device":           devicePayload(),
+	}, true)
+	s.publishJSON(fmt.Sprintf("%s/event/%smaintenance/config", prefix, id), map[string]interface{}{
+		"unique_id":   "maintenance",
+		"name":        "Maintenance",
+		"state_topic": s.maintenanceTopic,
+		"event_types": []string{"filter_due"},
+		"device":      devicePayload(),
+	}, true)
+}
+
+// Handles the filter reset button.
+func (s *MQTTManager) resetFilter() {
+	if _, err := maintenance.GetMaintenanceService().ResetFilter(); err != nil {
+		log.Error().Msgf("failed to record filter replacement: %v", err)
+	}
+	s.triggerStatePublish()
+}
+
+// Called by the maintenance service when the filters become due.
+func (s *MQTTManager) filterDueListener(status maintenance.FilterStatus) {
+	s.publishJSON(s.maintenanceTopic, map[string]interface{}{
+		"event_type":     "filter_due",
+		"weighted_hours": status.WeightedHours,
+	}, false)
+	s.triggerStatePublish()
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/dlefevre/go.ventilation-service/config"
 	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/maintenance"
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
 	"github.com/rs/zerolog/log"
@@ -19,42 +20,12 @@ var (
 	once     sync.Once
 )
 
-type Enum uint
-
-var (
-	entityIDs = map[controller.Enum]string{
-		controller.CmdSpeed1:  "speed1",
-		controller.CmdSpeed2:  "speed2",
-		controller.CmdSpeed3:  "speed3",
-		controller.CmdAway:    "away",
-		controller.CmdAuto:    "auto",
-		controller.CmdTimer15: "timer15",
-		controller.CmdTimer30: "timer30",
-		controller.CmdTimer60: "timer60",
-	}
-	prompts = map[controller.Enum]string{
-		controller.CmdSpeed1:  "Low ventilation",
-		controller.CmdSpeed2:  "Medium ventilation",
-		controller.CmdSpeed3:  "High ventilation",
-		controller.CmdAway:    "Away mode",
-		controller.CmdAuto:    "Automatic mode",
-		controller.CmdTimer15: "High ventilation (15 minutes)",
-		controller.CmdTimer30: "High ventilation (30 minutes)",
-		controller.CmdTimer60: "High ventilation (60 minutes)",
-	}
-	commands map[string]controller.Enum
-)
-
-func init() {
-	commands = make(map[string]controller.Enum)
-	for k, v := range entityIDs {
-		commands[v] = k
-	}
-}
-
 // MQTTManager is a singleton that encapsulates the MQTT client and .
 type MQTTManager struct {
 	actionTopic       string
+	stateTopic        string
+	maintenanceTopic  string
+	stateChanged      chan bool
 	mqttCfg           autopaho.ClientConfig
 	connectionManager *autopaho.ConnectionManager
 }
@@ -75,8 +46,11 @@ func newMQTTService() *MQTTManager {
 	}
 
 	mqttService := &MQTTManager{
-		actionTopic: fmt.Sprintf("%s/button/%s/action", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()),
+		actionTopic:  fmt.Sprintf("%s/button/%s/action", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()),
+		stateTopic:   fmt.Sprintf("%s/sensor/%s/state", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()),
+		stateChanged: make(chan bool, 1),
 	}
+	mqttService.maintenanceTopic = fmt.Sprintf("%s/event/%s/maintenance", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID())
 	mqttCfg := autopaho.ClientConfig{
 		ServerUrls:                    []*url.URL{u},
 		CleanStartOnInitialConnection: false,
@@ -96,6 +70,10 @@ func newMQTTService() *MQTTManager {
 		mqttCfg.ConnectPassword = []byte(config.GetMQTTPassword())
 	}
 	mqttService.mqttCfg = mqttCfg
+	controller.GetVentilationControllerService().AddStateListener(mqttService.stateListener)
+	controller.GetVentilationControllerService().AddInputListener(mqttService.inputListener)
+	controller.GetVentilationControllerService().AddQueueListener(mqttService.queueListener)
+	maintenance.GetMaintenanceService().AddDueListener(mqttService.filterDueListener)
 	return mqttService
 }
 
@@ -105,26 +83,34 @@ func (s *MQTTManager) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to create connection manager: %v", err)
 	}
 	s.connectionManager = cm
+	go s.stateLoop(ctx)
 	return nil
 }
 
 func (s *MQTTManager) connectHandler(cm *autopaho.ConnectionManager, connAck *paho.Connack) {
 	log.Info().Msgf("connected to MQTT broker: %s", connAck.String())
 
-	// Subscribe to the action topic.
+	// Subscribe to the action, cancel, lockout and service topics, and the fan topics for units with
+	// analog speed control.
+	_, cancelTopic := queueTopics()
+	topics := []string{s.actionTopic, cancelTopic, lockoutTopic(), serviceTopic()}
+	if controller.GetVentilationControllerService().HasAnalog() {
+		commandTopic, percentageTopic := fanTopics()
+		topics = append(topics, commandTopic, percentageTopic)
+	}
+	subscriptions := []paho.SubscribeOptions{}
+	for _, topic := range topics {
+		subscriptions = append(subscriptions, paho.SubscribeOptions{Topic: topic, QoS: 1})
+	}
 	if _, err := cm.Subscribe(context.Background(), &paho.Subscribe{
-		Subscriptions: []paho.SubscribeOptions{
-			{
-				Topic: s.actionTopic,
-				QoS:   1,
-			},
-		},
+		Subscriptions: subscriptions,
 	}); err != nil {
 		log.Error().Msgf("failed to subscribe (%s). This is likely to mean no messages will be received.", err)
 	}
-	log.Info().Msgf("subscribed to MQTT topic: %s", s.actionTopic)
+	log.Info().Msgf("subscribed to MQTT topics: %v", topics)
 
 	s.sendHomeAssistantAutodiscoveryPayload()
+	s.publishState()
 }
 
 func (s *MQTTManager) connectErrorHandler(err error) {
@@ -133,18 +119,59 @@ func (s *MQTTManager) connectErrorHandler(err error) {
 
 func (s *MQTTManager) publishHandler(pr paho.PublishReceived) (bool, error) {
 	dc := controller.GetVentilationControllerService()
+	if _, cancelTopic := queueTopics(); pr.Packet.Topic == cancelTopic {
+		if err := handleCancel(pr.Packet); err != nil {
+			log.Error().Msgf("rejected cancel on cancel topic: %v", err)
+			return false, err
+		}
+		return true, nil
+	}
+	if pr.Packet.Topic == lockoutTopic() {
+		if err := handleLockout(pr.Packet); err != nil {
+			log.Error().Msgf("rejected message on lockout topic: %v", err)
+			return false, err
+		}
+		return true, nil
+	}
+	if pr.Packet.Topic == serviceTopic() {
+		if err := handleService(pr.Packet); err != nil {
+			log.Error().Msgf("rejected message on service topic: %v", err)
+			return false, err
+		}
+		return true, nil
+	}
+	if pr.Packet.Topic != s.actionTopic {
+		if err := handleFan(pr.Packet); err != nil {
+			log.Error().Msgf("%v", err)
+			return false, err
+		}
+		return true, nil
+	}
 	command := string(pr.Packet.Payload)
-	if cmd, ok := commands[command]; ok {
-		dc.SendCommand(cmd)
-	} else {
-		log.Error().Msgf("received unknown command on action topic: %s", command)
-		return false, fmt.Errorf("unknown command: %s", command)
+	if command == filterResetAction {
+		s.resetFilter()
+	} else if err := dc.SendCommand(command, origin(pr.Packet)); err != nil {
+		log.Error().Msgf("rejected command %s on action topic: %v", command, err)
+		return false, err
 	}
 
 	log.Trace().Msgf("received command '%s' to VentilationControllerService", command)
 	return true, nil
 }
 
+// Returns the origin of a command received on the action topic. MQTT does not forward the client id of
+// the publisher, so publishers are expected to identify themselves with a "client_id" user property.
+func origin(packet *paho.Publish) controller.Origin {
+	identity := ""
+	if packet.Properties != nil {
+		identity = packet.Properties.User.Get("client_id")
+	}
+	return controller.Origin{
+		Source:   controller.SourceMQTT,
+		Identity: identity,
+	}
+}
+
 func (s *MQTTManager) clientErrorHandler(err error) {
 	log.Error().Msgf("mqtt client error: %v", err)
 }
@@ -157,33 +184,38 @@ func (s *MQTTManager) disconnectHandler(d *paho.Disconnect) {
 	}
 }
 
-func (s *MQTTManager) entityPayload(entity controller.Enum) map[string]interface{} {
+func (s *MQTTManager) entityPayload(command controller.Command) map[string]interface{} {
 	payload := map[string]interface{}{
-		"unique_id":        entityIDs[entity],
+		"unique_id":        command.Name,
 		"command_topic":    s.actionTopic,
-		"command_template": entityIDs[entity],
-		"name":             prompts[entity],
-		"device": map[string]interface{}{
-			"identifiers": []string{
-				config.GetMQTTID(),
-			},
-			"name":         config.GetMQTTID(),
-			"manufacturer": "n/a",
-			"model":        "Ventilation Controller",
-		},
+		"command_template": command.Name,
+		"name":             command.Label,
+		"device":           devicePayload(),
 	}
 	return payload
 }
 
-func (s *MQTTManager) publishEntityDiscoveryPayload(entity controller.Enum) {
-	payload := s.entityPayload(entity)
+// Device description shared by all entities.
+func devicePayload() map[string]interface{} {
+	return map[string]interface{}{
+		"identifiers": []string{
+			config.GetMQTTID(),
+		},
+		"name":         config.GetMQTTID(),
+		"manufacturer": "n/a",
+		"model":        "Ventilation Controller",
+	}
+}
+
+func (s *MQTTManager) publishEntityDiscoveryPayload(command controller.Command) {
+	payload := s.entityPayload(command)
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		log.Error().Msgf("failed to marshal discovery payload: %v", err)
 		return
 	}
 
-	topic := fmt.Sprintf("%s/button/%s%s/config", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID(), entityIDs[entity])
+	topic := fmt.Sprintf("%s/button/%s%s/config", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID(), command.Name)
 	message := &paho.Publish{
 		Topic:   topic,
 		Payload: payloadBytes,
@@ -198,7 +230,14 @@ func (s *MQTTManager) publishEntityDiscoveryPayload(entity controller.Enum) {
 }
 
 func (s *MQTTManager) sendHomeAssistantAutodiscoveryPayload() {
-	for entity := range entityIDs {
-		s.publishEntityDiscoveryPayload(entity)
-	}
+	for _, command := range controller.GetVentilationControllerService().Commands() {
+		s.publishEntityDiscoveryPayload(command)
+	}
+	s.publishSensorDiscoveryPayloads()
+	s.publishMaintenanceDiscoveryPayloads()
+	s.publishFanDiscoveryPayload()
+	s.publishInputDiscoveryPayloads()
+	s.publishQueueDiscoveryPayloads()
+	s.publishLockoutDiscoveryPayload()
+	s.publishServiceDiscoveryPayload()
 }
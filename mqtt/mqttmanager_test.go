@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/internal/testenv"
 	mochi_mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/hooks/auth"
 	"github.com/mochi-mqtt/server/v2/hooks/debug"
@@ -62,6 +63,10 @@ func init() {
 	}
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(testenv.Run(m))
+}
+
 func startBroker() {
 	go func() {
 		err := server.Serve()
@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Payload on the cancel topic that cancels all queued commands.
+const cancelAllPayload = "all"
+
+// Returns the topic the queue is published on, and the topic to cancel queued commands on.
+func queueTopics() (string, string) {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	return fmt.Sprintf("%s/sensor/%s/queue", prefix, id), fmt.Sprintf("%s/button/%s/queue/cancel", prefix, id)
+}
+
+// Builds the payload for the queue topic.
+func queuePayload() map[string]interface{} {
+	queued := controller.GetVentilationControllerService().Queue()
+	return map[string]interface{}{
+		"length": len(queued),
+		"queue":  queued,
+	}
+}
+
+// Publish the commands waiting in the queue.
+func (s *MQTTManager) publishQueue() {
+	queueTopic, _ := queueTopics()
+	s.publishJSON(queueTopic, queuePayload(), true)
+}
+
+// Publish the discovery payloads of the queue length sensor, with the queued commands as attributes, and
+// the button that clears the queue.
+func (s *MQTTManager) publishQueueDiscoveryPayloads() {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	queueTopic, cancelTopic := queueTopics()
+	s.publishJSON(fmt.Sprintf("%s/sensor/%squeue/config", prefix, id), map[string]interface{}{
+		"unique_id":             "queue",
+		"name":                  "Queued commands",
+		"state_topic":           queueTopic,
+		"value_template":        "{{ value_json.length }}",
+		"json_attributes_topic": queueTopic,
+		"state_class":           "measurement",
+		"device":                devicePayload(),
+	}, true)
+	s.publishJSON(fmt.Sprintf("%s/button/%squeue_flush/config", prefix, id), map[string]interface{}{
+		"unique_id":     "queue_flush",
+		"name":          "Clear command queue",
+		"command_topic": cancelTopic,
+		"payload_press": cancelAllPayload,
+		"device":        devicePayload(),
+	}, true)
+}
+
+// Handles a message on the cancel topic: the id of a queued command, or "all" to flush the queue.
+func handleCancel(packet *paho.Publish) error {
+	dc := controller.GetVentilationControllerService()
+	payload := strings.TrimSpace(string(packet.Payload))
+	if payload == cancelAllPayload {
+		dc.Flush(origin(packet))
+		return nil
+	}
+	id, err := strconv.ParseUint(payload, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid command id on cancel topic: %s", payload)
+	}
+	return dc.Cancel(id, origin(packet))
+}
+
+// Called by the controller whenever the queue changes.
+func (s *MQTTManager) queueListener([]controller.QueuedCommand) {
+	s.triggerStatePublish()
+}
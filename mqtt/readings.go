@@ -0,0 +1,43 @@
+package mqtt
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+)
+
+// Sensors for the values read back from a unit with a Modbus interface.
+func readingSensors() []sensor {
+	modbus, ok := config.GetModbus()
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(modbus.Readings))
+	for name := range modbus.Readings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sensors := []sensor{}
+	for _, name := range names {
+		reading := modbus.Readings[name]
+		sensors = append(sensors, sensor{
+			component:   "sensor",
+			key:         "reading_" + name,
+			name:        strings.ReplaceAll(name, "_", " "),
+			unit:        reading.Unit(),
+			deviceClass: reading.DeviceClass(),
+			stateClass:  "measurement",
+		})
+	}
+	return sensors
+}
+
+// Adds the values read back from the unit to the state payload.
+func addReadingsState(payload map[string]interface{}) {
+	for name, value := range controller.GetVentilationControllerService().GetState().Readings {
+		payload["reading_"+name] = value
+	}
+}
@@ -0,0 +1,200 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/stats"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/rs/zerolog/log"
+)
+
+// Interval at which the state topic is refreshed, in addition to every state change.
+const stateInterval = time.Minute
+
+// sensor describes a Home Assistant sensor whose value is read from the state topic.
+type sensor struct {
+	component   string // Home Assistant component: sensor or binary_sensor
+	key         string // Key in the state payload, also used as object id
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+}
+
+var (
+	modeNames = map[string]string{
+		controller.ModeSpeed1: "low ventilation",
+		controller.ModeSpeed2: "medium ventilation",
+		controller.ModeSpeed3: "high ventilation",
+		controller.ModeAway:   "away mode",
+		controller.ModeAuto:   "automatic mode",
+		controller.ModeBoost:  "boost",
+	}
+	sources = []string{controller.SourceWeb, controller.SourceMQTT, controller.SourceSchedule, controller.SourceRule}
+)
+
+// Returns all sensors whose value is published on the state topic.
+func sensorList() []sensor {
+	sensors := []sensor{
+		{component: "sensor", key: "mode", name: "Ventilation mode"},
+		{component: "binary_sensor", key: "fault", name: "Ventilation fault", deviceClass: "problem"},
+		{component: "sensor", key: "fault_reason", name: "Ventilation fault reason"},
+	}
+	sensors = append(sensors, statsSensors()...)
+	sensors = append(sensors, maintenanceSensors()...)
+	sensors = append(sensors, energySensors()...)
+	sensors = append(sensors, readingSensors()...)
+	sensors = append(sensors, inputSensors()...)
+	sensors = append(sensors, lockoutSensors()...)
+	return sensors
+}
+
+// Builds the payload for the state topic.
+func statePayload() map[string]interface{} {
+	state := controller.GetVentilationControllerService().GetState()
+	payload := map[string]interface{}{
+		"mode":         state.Mode,
+		"fault":        onOff(state.Fault != ""),
+		"fault_reason": state.Fault,
+	}
+	addStatsState(payload)
+	addMaintenanceState(payload)
+	addEnergyState(payload)
+	addFanState(payload)
+	addReadingsState(payload)
+	addInputState(payload)
+	addLockoutState(payload)
+	addServiceState(payload)
+	return payload
+}
+
+// Sensors for the runtime statistics of today.
+func statsSensors() []sensor {
+	sensors := []sensor{}
+	for _, mode := range controller.Modes {
+		if name, ok := modeNames[mode]; ok {
+			sensors = append(sensors, sensor{
+				component:   "sensor",
+				key:         fmt.Sprintf("hours_%s_today", mode),
+				name:        fmt.Sprintf("Hours in %s today", name),
+				unit:        "h",
+				deviceClass: "duration",
+				stateClass:  "measurement",
+			})
+		}
+	}
+	for _, source := range sources {
+		sensors = append(sensors, sensor{
+			component:  "sensor",
+			key:        fmt.Sprintf("commands_%s_today", source),
+			name:       fmt.Sprintf("Commands from %s today", source),
+			stateClass: "measurement",
+		})
+	}
+	return sensors
+}
+
+// Adds the runtime statistics of today to the state payload.
+func addStatsState(payload map[string]interface{}) {
+	today, err := stats.GetStatsService().Query(stats.PeriodDay)
+	if err != nil {
+		log.Error().Msgf("failed to query statistics: %v", err)
+		return
+	}
+	for mode := range modeNames {
+		payload[fmt.Sprintf("hours_%s_today", mode)] = today.Hours[mode]
+	}
+	for _, source := range sources {
+		payload[fmt.Sprintf("commands_%s_today", source)] = today.Commands[source]
+	}
+}
+
+// Discovery payload for a sensor.
+func (s *MQTTManager) sensorPayload(sensor sensor) map[string]interface{} {
+	payload := map[string]interface{}{
+		"unique_id":      sensor.key,
+		"name":           sensor.name,
+		"state_topic":    s.stateTopic,
+		"value_template": fmt.Sprintf("{{ value_json.%s }}", sensor.key),
+		"device":         devicePayload(),
+	}
+	if sensor.unit != "" {
+		payload["unit_of_measurement"] = sensor.unit
+	}
+	if sensor.deviceClass != "" {
+		payload["device_class"] = sensor.deviceClass
+	}
+	if sensor.stateClass != "" {
+		payload["state_class"] = sensor.stateClass
+	}
+	return payload
+}
+
+func (s *MQTTManager) publishSensorDiscoveryPayloads() {
+	for _, sensor := range sensorList() {
+		topic := fmt.Sprintf("%s/%s/%s%s/config", config.GetMQTTDiscoveryPrefix(), sensor.component, config.GetMQTTID(), sensor.key)
+		s.publishJSON(topic, s.sensorPayload(sensor), true)
+	}
+}
+
+// Publish the state payload, and the queue.
+func (s *MQTTManager) publishState() {
+	s.publishJSON(s.stateTopic, statePayload(), true)
+	s.publishQueue()
+}
+
+// Marshal and publish a payload.
+func (s *MQTTManager) publishJSON(topic string, payload interface{}, retain bool) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Msgf("failed to marshal payload for %s: %v", topic, err)
+		return
+	}
+	message := &paho.Publish{
+		Topic:   topic,
+		Payload: payloadBytes,
+		QoS:     1,
+		Retain:  retain,
+	}
+	if _, err := s.connectionManager.Publish(context.Background(), message); err != nil {
+		log.Error().Msgf("failed to publish to MQTT topic %s: %v", topic, err)
+	} else {
+		log.Debug().Msgf("published to MQTT topic: %s", topic)
+	}
+}
+
+// Publishes the state periodically and whenever it or the queue changes, until the context is done.
+func (s *MQTTManager) stateLoop(ctx context.Context) {
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.stateChanged:
+		}
+		if err := s.connectionManager.AwaitConnection(ctx); err != nil {
+			return
+		}
+		s.publishState()
+	}
+}
+
+// Called by the controller whenever the state of the unit changes.
+func (s *MQTTManager) stateListener(controller.State) {
+	s.triggerStatePublish()
+}
+
+// Have the state loop publish the state as soon as possible.
+func (s *MQTTManager) triggerStatePublish() {
+	select {
+	case s.stateChanged <- true:
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Returns the topic to turn the service mode on or off on.
+func serviceTopic() string {
+	return fmt.Sprintf("%s/switch/%s/service/set", config.GetMQTTDiscoveryPrefix(), config.GetMQTTID())
+}
+
+// Adds the service mode to the state payload.
+func addServiceState(payload map[string]interface{}) {
+	payload["service_mode"] = onOff(controller.GetVentilationControllerService().ServiceMode().Active)
+}
+
+// Publish the discovery payload of the service mode switch.
+func (s *MQTTManager) publishServiceDiscoveryPayload() {
+	prefix, id := config.GetMQTTDiscoveryPrefix(), config.GetMQTTID()
+	s.publishJSON(fmt.Sprintf("%s/switch/%sservice/config", prefix, id), map[string]interface{}{
+		"unique_id":      "service_mode",
+		"name":           "Service mode",
+		"command_topic":  serviceTopic(),
+		"state_topic":    s.stateTopic,
+		"value_template": "{{ value_json.service_mode }}",
+		"device":         devicePayload(),
+	}, true)
+}
+
+// Handles a message on the service topic: "ON" turns the service mode on, with the configured expiry,
+// and "OFF" turns it off. Raw outputs can only be allowed through the REST api.
+func handleService(packet *paho.Publish) error {
+	dc := controller.GetVentilationControllerService()
+	payload := strings.TrimSpace(string(packet.Payload))
+	switch payload {
+	case "ON":
+		dc.SetServiceMode(true, config.GetServiceExpiry(), false, origin(packet))
+	case "OFF":
+		dc.SetServiceMode(false, 0, false, origin(packet))
+	default:
+		return fmt.Errorf("invalid payload on service topic: %s", payload)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package persist stores small state objects as JSON files.
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Load reads the JSON file at path into v. A missing file is not an error, and leaves v untouched.
+func Load(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("persist: unable to read %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("persist: unable to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+// Save writes v as JSON to path. The data is written to a temporary file first, so a crash never
+// leaves a truncated file behind.
+func Save(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("persist: unable to marshal %s: %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("persist: unable to create directory: %v", err)
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o640); err != nil {
+		return fmt.Errorf("persist: unable to write %s: %v", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("persist: unable to write %s: %v", path, err)
+	}
+	return nil
+}
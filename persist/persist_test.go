@@ -0,0 +1,34 @@
+package persist
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type testState struct {
+	Counter int `json:"counter"`
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	if err := Save(path, testState{Counter: 42}); err != nil {
+		t.Fatalf("Error saving state: %v", err)
+	}
+	var state testState
+	if err := Load(path, &state); err != nil {
+		t.Fatalf("Error loading state: %v", err)
+	}
+	if state.Counter != 42 {
+		t.Fatalf("Expected counter to be 42, got %d", state.Counter)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	state := testState{Counter: 1}
+	if err := Load(filepath.Join(t.TempDir(), "missing.json"), &state); err != nil {
+		t.Fatalf("Expected no error for a missing file, got %v", err)
+	}
+	if state.Counter != 1 {
+		t.Fatalf("Expected state to be untouched")
+	}
+}
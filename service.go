@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+)
+
+// Timeout of the requests of the service subcommand.
+const serviceTimeout = 10 * time.Second
+
+// Implements the service subcommand, which turns the service mode of a running service on or off
+// through its REST api, or shows it. Returns the exit code: 0 on success, 1 when the request failed,
+// 2 on usage errors.
+func serviceCommand(args []string) int {
+	flags := flag.NewFlagSet("service", flag.ContinueOnError)
+	url := flags.String("url", "", "url of the service (default: localhost on the port from the configuration file)")
+	key := flags.String("key", "", "api key (default: $VENTILATIONSERVICE_API_KEY)")
+	duration := flags.Int("duration", -1, "minutes after which the service mode ends by itself, 0 for none, -1 for the expiry from the configuration file")
+	raw := flags.Bool("raw", false, "allow driving the outputs directly")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: service [flags] on|off|status")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *key == "" {
+		*key = os.Getenv("VENTILATIONSERVICE_API_KEY")
+	}
+	if *url == "" {
+		*url = fmt.Sprintf("http://localhost:%d", config.GetBindPort())
+	}
+
+	var req *http.Request
+	var err error
+	switch flags.Arg(0) {
+	case "on", "off":
+		message := map[string]interface{}{"active": flags.Arg(0) == "on", "raw_outputs": *raw}
+		if *duration >= 0 {
+			message["duration"] = *duration
+		}
+		body, _ := json.Marshal(message)
+		req, err = http.NewRequest(http.MethodPost, *url+"/service", strings.NewReader(string(body)))
+	case "status":
+		req, err = http.NewRequest(http.MethodGet, *url+"/service", nil)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	req.Header.Set("x-api-key", *key)
+
+	client := &http.Client{Timeout: serviceTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(strings.TrimSpace(string(body)))
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,286 @@
+package stats
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/persist"
+	"github.com/rs/zerolog/log"
+)
+
+// Periods for which aggregates can be requested.
+const (
+	PeriodDay   = "day"   // PeriodDay is the current day
+	PeriodWeek  = "week"  // PeriodWeek is the current week, starting on Monday
+	PeriodMonth = "month" // PeriodMonth is the current calendar month
+)
+
+const (
+	DateFormat    = "2006-01-02" // DateFormat is the format of the dates in the statistics
+	saveInterval  = time.Minute
+	retentionDays = 400
+)
+
+var (
+	instance *StatsService
+	once     sync.Once
+)
+
+// Day holds the counters of a single (local) day.
+type Day struct {
+	Date     string             `json:"date"`
+	Seconds  map[string]float64 `json:"seconds"`  // Seconds spent per mode
+	Commands map[string]int     `json:"commands"` // Executed commands per source
+}
+
+// Aggregate holds the counters for a period, in hours per mode and commands per source.
+type Aggregate struct {
+	Period   string             `json:"period"`
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	Hours    map[string]float64 `json:"hours"`
+	Commands map[string]int     `json:"commands"`
+	Days     []Day              `json:"days"`
+}
+
+// Persisted form of the statistics.
+type snapshot struct {
+	Days map[string]*Day `json:"days"`
+}
+
+// StatsService keeps runtime statistics per mode and command counts per source, per day.
+// The counters are persisted, so they survive restarts.
+type StatsService struct {
+	path  string
+	days  map[string]*Day
+	mode  string
+	since time.Time
+	lock  sync.Mutex
+	stop  chan bool
+	wg    sync.WaitGroup
+}
+
+// GetStatsService returns the one and only StatsService instance.
+func GetStatsService() *StatsService {
+	once.Do(func() {
+		instance = newStatsService(filepath.Join(config.GetDataDir(), "stats.json"))
+		dc := controller.GetVentilationControllerService()
+		dc.AddStateListener(instance.stateListener)
+		dc.AddCommandListener(instance.commandListener)
+	})
+	return instance
+}
+
+// Creates a new StatsService object.
+func newStatsService(path string) *StatsService {
+	return &StatsService{
+		path:  path,
+		days:  make(map[string]*Day),
+		mode:  controller.ModeUnknown,
+		since: time.Now(),
+	}
+}
+
+// Start loads the persisted counters, and starts saving them periodically.
+func (s *StatsService) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	// The time the service was down is not accounted for.
+	s.since = time.Now()
+	s.stop = make(chan bool)
+	s.wg.Add(1)
+	go s.saveLoop(s.stop)
+	return nil
+}
+
+// Stop saves the counters and stops the periodic saving. Stopping a service that did not
+// start has no effect.
+func (s *StatsService) Stop() {
+	s.lock.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.lock.Unlock()
+	if stop == nil {
+		return // Not started, so there is nothing to save
+	}
+	close(stop)
+	s.wg.Wait()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	if err := s.save(); err != nil {
+		log.Error().Msgf("%v", err)
+	}
+}
+
+func (s *StatsService) saveLoop(stop chan bool) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			s.accumulate(time.Now())
+			if err := s.save(); err != nil {
+				log.Error().Msgf("%v", err)
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *StatsService) load() error {
+	var snap snapshot
+	if err := persist.Load(s.path, &snap); err != nil {
+		return err
+	}
+	if snap.Days != nil {
+		s.days = snap.Days
+	}
+	return nil
+}
+
+func (s *StatsService) save() error {
+	s.prune()
+	return persist.Save(s.path, snapshot{Days: s.days})
+}
+
+// Remove days older than the retention period.
+func (s *StatsService) prune() {
+	limit := time.Now().AddDate(0, 0, -retentionDays).Format(DateFormat)
+	for date := range s.days {
+		if date < limit {
+			delete(s.days, date)
+		}
+	}
+}
+
+func (s *StatsService) day(date string) *Day {
+	day, ok := s.days[date]
+	if !ok {
+		day = &Day{
+			Date:     date,
+			Seconds:  make(map[string]float64),
+			Commands: make(map[string]int),
+		}
+		s.days[date] = day
+	}
+	return day
+}
+
+// Add the time spent in the current mode up to now, split over the days it spans.
+func (s *StatsService) accumulate(now time.Time) {
+	for s.since.Before(now) {
+		y, m, d := s.since.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, s.since.Location())
+		end := now
+		if midnight.Before(now) {
+			end = midnight
+		}
+		s.day(s.since.Format(DateFormat)).Seconds[s.mode] += end.Sub(s.since).Seconds()
+		s.since = end
+	}
+}
+
+func (s *StatsService) stateListener(state controller.State) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(time.Now())
+	s.mode = state.Mode
+}
+
+func (s *StatsService) commandListener(record controller.CommandRecord) {
+	if record.Outcome != controller.OutcomeExecuted {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.day(time.Now().Format(DateFormat)).Commands[record.Origin.Source]++
+}
+
+// PeriodBounds returns the first and last day of the period containing the given time.
+func PeriodBounds(period string, now time.Time) (time.Time, time.Time, error) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	switch period {
+	case PeriodDay:
+		return today, today, nil
+	case PeriodWeek:
+		offset := (int(today.Weekday()) + 6) % 7
+		from := today.AddDate(0, 0, -offset)
+		return from, from.AddDate(0, 0, 6), nil
+	case PeriodMonth:
+		from := time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+		return from, from.AddDate(0, 1, -1), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("stats: unknown period %s", period)
+	}
+}
+
+// Query returns the aggregated counters for the current day, week or month.
+func (s *StatsService) Query(period string) (Aggregate, error) {
+	now := time.Now()
+	from, to, err := PeriodBounds(period, now)
+	if err != nil {
+		return Aggregate{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accumulate(now)
+
+	aggregate := Aggregate{
+		Period:   period,
+		From:     from.Format(DateFormat),
+		To:       to.Format(DateFormat),
+		Hours:    make(map[string]float64),
+		Commands: make(map[string]int),
+		Days:     []Day{},
+	}
+	for _, mode := range controller.Modes {
+		aggregate.Hours[mode] = 0
+	}
+	for date, day := range s.days {
+		if date < aggregate.From || date > aggregate.To {
+			continue
+		}
+		for mode, seconds := range day.Seconds {
+			aggregate.Hours[mode] += seconds / 3600
+		}
+		for source, count := range day.Commands {
+			aggregate.Commands[source] += count
+		}
+		aggregate.Days = append(aggregate.Days, copyDay(day))
+	}
+	sort.Slice(aggregate.Days, func(i, j int) bool { return aggregate.Days[i].Date < aggregate.Days[j].Date })
+	return aggregate, nil
+}
+
+func copyDay(day *Day) Day {
+	c := Day{
+		Date:     day.Date,
+		Seconds:  make(map[string]float64, len(day.Seconds)),
+		Commands: make(map[string]int, len(day.Commands)),
+	}
+	for k, v := range day.Seconds {
+		c.Seconds[k] = v
+	}
+	for k, v := range day.Commands {
+		c.Commands[k] = v
+	}
+	return c
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+)
+
+func init() {
+	// Set the environment variable for the configuration path
+	os.Setenv("VENTILATIONSERVICE_CONFIG_PATH", "..")
+}
+
+func TestAccumulateAcrossMidnight(t *testing.T) {
+	s := newStatsService(filepath.Join(t.TempDir(), "stats.json"))
+	s.mode = controller.ModeSpeed2
+	s.since = time.Date(2025, 3, 1, 23, 0, 0, 0, time.Local)
+	s.accumulate(time.Date(2025, 3, 2, 1, 30, 0, 0, time.Local))
+
+	if got := s.days["2025-03-01"].Seconds[controller.ModeSpeed2]; got != 3600 {
+		t.Fatalf("Expected 3600 seconds on the first day, got %v", got)
+	}
+	if got := s.days["2025-03-02"].Seconds[controller.ModeSpeed2]; got != 5400 {
+		t.Fatalf("Expected 5400 seconds on the second day, got %v", got)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	s := newStatsService(filepath.Join(t.TempDir(), "stats.json"))
+	s.stateListener(controller.State{Mode: controller.ModeAway})
+	s.commandListener(controller.CommandRecord{Origin: controller.Origin{Source: controller.SourceMQTT}, Outcome: controller.OutcomeExecuted})
+	s.commandListener(controller.CommandRecord{Origin: controller.Origin{Source: controller.SourceMQTT}, Outcome: controller.OutcomeDropped})
+
+	aggregate, err := s.Query(PeriodDay)
+	if err != nil {
+		t.Fatalf("Error querying statistics: %v", err)
+	}
+	if aggregate.Commands[controller.SourceMQTT] != 1 {
+		t.Fatalf("Expected 1 executed mqtt command, got %d", aggregate.Commands[controller.SourceMQTT])
+	}
+	if s.mode != controller.ModeAway {
+		t.Fatalf("Expected mode to be away, got %s", s.mode)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	s := newStatsService(path)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting statistics: %v", err)
+	}
+	s.commandListener(controller.CommandRecord{Origin: controller.Origin{Source: controller.SourceWeb}, Outcome: controller.OutcomeExecuted})
+	s.Stop()
+
+	restored := newStatsService(path)
+	if err := restored.Start(); err != nil {
+		t.Fatalf("Error restarting statistics: %v", err)
+	}
+	defer restored.Stop()
+	aggregate, _ := restored.Query(PeriodMonth)
+	if aggregate.Commands[controller.SourceWeb] != 1 {
+		t.Fatalf("Expected the command counter to survive a restart, got %+v", aggregate.Commands)
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	now := time.Date(2025, 3, 13, 15, 0, 0, 0, time.UTC) // Thursday
+	for period, expected := range map[string][2]string{
+		PeriodDay:   {"2025-03-13", "2025-03-13"},
+		PeriodWeek:  {"2025-03-10", "2025-03-16"},
+		PeriodMonth: {"2025-03-01", "2025-03-31"},
+	} {
+		from, to, err := PeriodBounds(period, now)
+		if err != nil {
+			t.Fatalf("Error computing bounds for %s: %v", period, err)
+		}
+		if from.Format(DateFormat) != expected[0] || to.Format(DateFormat) != expected[1] {
+			t.Fatalf("Expected %s to span %v, got %s - %s", period, expected, from.Format(DateFormat), to.Format(DateFormat))
+		}
+	}
+	if _, _, err := PeriodBounds("year", now); err == nil {
+		t.Fatalf("Expected an error for an unknown period")
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	s := newStatsService(path)
+	s.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected a service that did not start not to save, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/history"
+)
+
+// Implements the verify subcommand, which checks the integrity of a (possibly exported) history log.
+// Returns the exit code: 0 when the log is intact, 1 when problems were found, 2 on usage errors.
+func verifyCommand(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	path := flags.String("log", "", "path of the history log (default: from the configuration file)")
+	pubKey := flags.String("pubkey", "", "PEM encoded Ed25519 public key to check the day signatures with")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *path == "" {
+		*path = history.LogPath()
+		if *pubKey == "" {
+			*pubKey = config.GetHistoryVerifyKey()
+		}
+	}
+	var publicKey ed25519.PublicKey
+	if *pubKey != "" {
+		key, err := history.LoadPublicKey(*pubKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		publicKey = key
+	}
+
+	report, err := history.Verify(*path, publicKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// CommandMessage is a message object for sending a command by name.
+type CommandMessage struct {
+	Command string `json:"command"`
+}
+
+// CommandsResponse is the response object for the list of commands.
+type CommandsResponse struct {
+	SimpleResponse
+	Commands []controller.Command `json:"commands"`
+}
+
+// Returns the status for a rejected command: locked while the safety lockout is tripped, conflicting when
+// suppressed in service mode, and unavailable while the unit is in fault state.
+func rejectedStatus(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrLockout):
+		return http.StatusLocked
+	case errors.Is(err, controller.ErrServiceMode):
+		return http.StatusConflict
+	case errors.Is(err, controller.ErrFault):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// Queue a command, and respond with the result.
+func sendCommand(c echo.Context, command string) error {
+	dc := controller.GetVentilationControllerService()
+	if err := dc.SendCommand(command, origin(c)); err != nil {
+		log.Error().Msgf("%v", err)
+		return c.JSON(rejectedStatus(err), ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, SimpleResponse{
+		Result: "ok",
+	})
+}
+
+// Queue a percentage for the analog output, and respond with the result.
+func setPercent(c echo.Context, percent float64) error {
+	dc := controller.GetVentilationControllerService()
+	if err := dc.SetPercent(percent, origin(c)); err != nil {
+		log.Error().Msgf("%v", err)
+		return c.JSON(rejectedStatus(err), ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, SimpleResponse{
+		Result: "ok",
+	})
+}
+
+// Handler for sending any configured command by name
+func commandHandler(c echo.Context) error {
+	var command CommandMessage
+	if err := bodyParser(c, &command); err != nil {
+		log.Error().Msgf("Error parsing request: %v", err)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error parsing request: %v", err),
+		})
+	}
+	return sendCommand(c, command.Command)
+}
+
+// Handler for listing the configured commands
+func commandsHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, CommandsResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Commands:       dc.Commands(),
+	})
+}
@@ -22,9 +22,11 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
-// SpeedMessage is a message object for speed commands.
+// SpeedMessage is a message object for speed commands: either a discrete speed, or a percentage for
+// units with analog speed control.
 type SpeedMessage struct {
-	Speed string `json:"speed"`
+	Speed   string   `json:"speed"`
+	Percent *float64 `json:"percent"`
 }
 
 // TimerMessage is a message object for timer commands (should be 15, 30 or 30 minutes).
@@ -39,6 +41,17 @@ func healthCheck(c echo.Context) error {
 	})
 }
 
+// Handler for the readiness check, which fails while the unit is in fault state.
+func readyCheck(c echo.Context) error {
+	if err := controller.GetVentilationControllerService().Fault(); err != nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return healthCheck(c)
+}
+
 func bodyParser(c echo.Context, dest interface{}) error {
 	body, err := io.ReadAll(c.Request().Body)
 	if err != nil {
@@ -50,10 +63,17 @@ func bodyParser(c echo.Context, dest interface{}) error {
 	return nil
 }
 
+// Returns the origin of a command received through the REST api.
+func origin(c echo.Context) controller.Origin {
+	identity, _ := c.Get(identityKey).(string)
+	return controller.Origin{
+		Source:   controller.SourceWeb,
+		Identity: identity,
+	}
+}
+
 // Handler for speed command
 func speedHandler(c echo.Context) error {
-	dc := controller.GetVentilationControllerService()
-
 	var speed SpeedMessage
 	if err := bodyParser(c, &speed); err != nil {
 		log.Error().Msgf("Error parsing request: %v", err)
@@ -63,13 +83,17 @@ func speedHandler(c echo.Context) error {
 		})
 	}
 
+	if speed.Percent != nil {
+		return setPercent(c, *speed.Percent)
+	}
+
 	switch speed.Speed {
 	case "1", "low":
-		dc.SendCommand(controller.CmdSpeed1)
+		return sendCommand(c, controller.CmdSpeed1)
 	case "2", "medium":
-		dc.SendCommand(controller.CmdSpeed2)
+		return sendCommand(c, controller.CmdSpeed2)
 	case "3", "high":
-		dc.SendCommand(controller.CmdSpeed3)
+		return sendCommand(c, controller.CmdSpeed3)
 	default:
 		log.Error().Msgf("Unknown speed: %s", speed.Speed)
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -77,16 +101,10 @@ func speedHandler(c echo.Context) error {
 			Message:        fmt.Sprintf("Invalid speed: %s", speed.Speed),
 		})
 	}
-
-	return c.JSON(http.StatusOK, SimpleResponse{
-		Result: "ok",
-	})
 }
 
 // Handler for timer command
 func timerHandler(c echo.Context) error {
-	dc := controller.GetVentilationControllerService()
-
 	var timer TimerMessage
 	if err := bodyParser(c, &timer); err != nil {
 		log.Error().Msgf("Error parsing request: %v", err)
@@ -98,11 +116,11 @@ func timerHandler(c echo.Context) error {
 
 	switch timer.Duration {
 	case 15:
-		dc.SendCommand(controller.CmdTimer15)
+		return sendCommand(c, controller.CmdTimer15)
 	case 30:
-		dc.SendCommand(controller.CmdTimer30)
+		return sendCommand(c, controller.CmdTimer30)
 	case 60:
-		dc.SendCommand(controller.CmdTimer60)
+		return sendCommand(c, controller.CmdTimer60)
 	default:
 		log.Error().Msgf("Invalid duration: %d", timer.Duration)
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -110,26 +128,29 @@ func timerHandler(c echo.Context) error {
 			Message:        fmt.Sprintf("Invalid duration: %d", timer.Duration),
 		})
 	}
-
-	return c.JSON(http.StatusOK, SimpleResponse{
-		Result: "ok",
-	})
 }
 
 // Handler for away command
 func awayHandler(c echo.Context) error {
-	dc := controller.GetVentilationControllerService()
-	dc.SendCommand(controller.CmdAway)
-	return c.JSON(http.StatusOK, SimpleResponse{
-		Result: "ok",
-	})
+	return sendCommand(c, controller.CmdAway)
 }
 
 // Handler for auto command
 func autoHandler(c echo.Context) error {
+	return sendCommand(c, controller.CmdAuto)
+}
+
+// StateResponse is the response object for the state of the unit.
+type StateResponse struct {
+	SimpleResponse
+	State controller.State `json:"state"`
+}
+
+// Handler for the state of the unit
+func stateHandler(c echo.Context) error {
 	dc := controller.GetVentilationControllerService()
-	dc.SendCommand(controller.CmdAuto)
-	return c.JSON(http.StatusOK, SimpleResponse{
-		Result: "ok",
+	return c.JSON(http.StatusOK, StateResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		State:          dc.GetState(),
 	})
 }
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/history"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryResponse is the response object for history queries.
+type HistoryResponse struct {
+	SimpleResponse
+	Records []history.Record `json:"records"`
+}
+
+// Parses an optional RFC 3339 timestamp from the query string.
+func parseTimeParam(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return t, nil
+}
+
+// Handler for history queries. Supports the from, to and source filters, and format=csv for CSV export.
+func historyHandler(c echo.Context) error {
+	filter := history.Filter{Source: c.QueryParam("source")}
+	var err error
+	if filter.From, err = parseTimeParam(c, "from"); err == nil {
+		filter.To, err = parseTimeParam(c, "to")
+	}
+	if err != nil {
+		log.Error().Msgf("Error parsing request: %v", err)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error parsing request: %v", err),
+		})
+	}
+
+	records, err := history.GetHistoryService().Query(filter)
+	if err != nil {
+		log.Error().Msgf("Error querying history: %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error querying history: %v", err),
+		})
+	}
+
+	if c.QueryParam("format") == "csv" {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="history.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+		return history.WriteCSV(c.Response(), records)
+	}
+	return c.JSON(http.StatusOK, HistoryResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Records:        records,
+	})
+}
+
+// VerifyResponse is the response object for history verification.
+type VerifyResponse struct {
+	SimpleResponse
+	Report history.Report `json:"report"`
+}
+
+// Handler for history verification. The result is nok when the chain is broken or a signature is invalid.
+func historyVerifyHandler(c echo.Context) error {
+	report, err := history.GetHistoryService().Verify()
+	if err != nil {
+		log.Error().Msgf("Error verifying history: %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error verifying history: %v", err),
+		})
+	}
+
+	result := "ok"
+	if !report.Valid {
+		result = "nok"
+	}
+	return c.JSON(http.StatusOK, VerifyResponse{
+		SimpleResponse: SimpleResponse{Result: result},
+		Report:         report,
+	})
+}
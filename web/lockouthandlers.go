@@ -0,0 +1,72 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Reason of a lockout tripped through the api, when none is given.
+const defaultLockoutReason = "tripped through the api"
+
+// LockoutMessage is a message object for tripping the safety lockout.
+type LockoutMessage struct {
+	Reason string `json:"reason"`
+}
+
+// LockoutResponse is the response object for the status of the safety lockout.
+type LockoutResponse struct {
+	SimpleResponse
+	Lockout controller.LockoutStatus `json:"lockout"`
+}
+
+// Handler for the status of the safety lockout
+func lockoutHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, LockoutResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Lockout:        dc.LockoutStatus(),
+	})
+}
+
+// Handler for tripping the safety lockout
+func tripHandler(c echo.Context) error {
+	var message LockoutMessage
+	if c.Request().ContentLength == 0 {
+		message.Reason = defaultLockoutReason
+	} else if err := bodyParser(c, &message); err != nil {
+		log.Error().Msgf("Error parsing request: %v", err)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error parsing request: %v", err),
+		})
+	}
+	if message.Reason == "" {
+		message.Reason = defaultLockoutReason
+	}
+	dc := controller.GetVentilationControllerService()
+	dc.Trip(message.Reason, origin(c))
+	return c.JSON(http.StatusOK, LockoutResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Lockout:        dc.LockoutStatus(),
+	})
+}
+
+// Handler for resetting the safety lockout
+func resetLockoutHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	if err := dc.Reset(origin(c)); err != nil {
+		log.Error().Msgf("%v", err)
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, LockoutResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Lockout:        dc.LockoutStatus(),
+	})
+}
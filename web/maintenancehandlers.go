@@ -0,0 +1,40 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dlefevre/go.ventilation-service/maintenance"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// FilterResponse is the response object for the filter status.
+type FilterResponse struct {
+	SimpleResponse
+	Filter maintenance.FilterStatus `json:"filter"`
+}
+
+// Handler for the filter status
+func filterHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, FilterResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Filter:         maintenance.GetMaintenanceService().FilterStatus(),
+	})
+}
+
+// Handler to record a filter replacement
+func filterResetHandler(c echo.Context) error {
+	status, err := maintenance.GetMaintenanceService().ResetFilter()
+	if err != nil {
+		log.Error().Msgf("Error recording filter replacement: %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error recording filter replacement: %v", err),
+		})
+	}
+	return c.JSON(http.StatusOK, FilterResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Filter:         status,
+	})
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// QueueResponse is the response object for the commands waiting in the queue.
+type QueueResponse struct {
+	SimpleResponse
+	Queue []controller.QueuedCommand `json:"queue"`
+}
+
+// FlushResponse is the response object for flushing the queue.
+type FlushResponse struct {
+	SimpleResponse
+	Canceled int `json:"canceled"`
+}
+
+// Handler for listing the commands waiting in the queue, in the order they would be served
+func queueHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, QueueResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Queue:          dc.Queue(),
+	})
+}
+
+// Handler for canceling a queued command by its id
+func cancelHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Invalid command id: %s", c.Param("id")),
+		})
+	}
+	dc := controller.GetVentilationControllerService()
+	if err := dc.Cancel(id, origin(c)); err != nil {
+		log.Error().Msgf("%v", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, controller.ErrNotQueued) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, SimpleResponse{
+		Result: "ok",
+	})
+}
+
+// Handler for canceling all queued commands
+func flushHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, FlushResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Canceled:       dc.Flush(origin(c)),
+	})
+}
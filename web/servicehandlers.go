@@ -0,0 +1,95 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dlefevre/go.ventilation-service/config"
+	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ServiceMessage is a message object for turning the service mode on or off.
+type ServiceMessage struct {
+	Active     bool `json:"active"`
+	Duration   *int `json:"duration"` // In minutes, 0 for no expiry (default: from the configuration file)
+	RawOutputs bool `json:"raw_outputs"`
+}
+
+// OutputMessage is a message object for driving an output directly.
+type OutputMessage struct {
+	Output string `json:"output"`
+	Active bool   `json:"active"`
+}
+
+// ServiceResponse is the response object for the service mode.
+type ServiceResponse struct {
+	SimpleResponse
+	Service controller.ServiceMode `json:"service"`
+}
+
+// Handler for the status of the service mode
+func serviceHandler(c echo.Context) error {
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, ServiceResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Service:        dc.ServiceMode(),
+	})
+}
+
+// Handler for turning the service mode on or off
+func setServiceHandler(c echo.Context) error {
+	var message ServiceMessage
+	if err := bodyParser(c, &message); err != nil {
+		log.Error().Msgf("Error parsing request: %v", err)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error parsing request: %v", err),
+		})
+	}
+	duration := config.GetServiceExpiry()
+	if message.Duration != nil {
+		if *message.Duration < 0 {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				SimpleResponse: SimpleResponse{Result: "nok"},
+				Message:        fmt.Sprintf("Invalid duration: %d", *message.Duration),
+			})
+		}
+		duration = time.Duration(*message.Duration) * time.Minute
+	}
+	dc := controller.GetVentilationControllerService()
+	return c.JSON(http.StatusOK, ServiceResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Service:        dc.SetServiceMode(message.Active, duration, message.RawOutputs, origin(c)),
+	})
+}
+
+// Handler for driving an output directly, in service mode
+func outputHandler(c echo.Context) error {
+	var message OutputMessage
+	if err := bodyParser(c, &message); err != nil {
+		log.Error().Msgf("Error parsing request: %v", err)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Error parsing request: %v", err),
+		})
+	}
+	dc := controller.GetVentilationControllerService()
+	if err := dc.SetOutput(message.Output, message.Active, origin(c)); err != nil {
+		log.Error().Msgf("%v", err)
+		status := rejectedStatus(err)
+		if errors.Is(err, controller.ErrRawOutputs) {
+			status = http.StatusForbidden
+		}
+		return c.JSON(status, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, SimpleResponse{
+		Result: "ok",
+	})
+}
@@ -0,0 +1,44 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dlefevre/go.ventilation-service/energy"
+	"github.com/dlefevre/go.ventilation-service/stats"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// StatsResponse is the response object for runtime statistics.
+type StatsResponse struct {
+	SimpleResponse
+	Stats  stats.Aggregate  `json:"stats"`
+	Energy energy.Aggregate `json:"energy"`
+}
+
+// Handler for runtime statistics and estimated energy cost. The period is day (default), week or month.
+func statsHandler(c echo.Context) error {
+	period := c.QueryParam("period")
+	if period == "" {
+		period = stats.PeriodDay
+	}
+
+	aggregate, err := stats.GetStatsService().Query(period)
+	var consumption energy.Aggregate
+	if err == nil {
+		consumption, err = energy.GetEnergyService().Query(period)
+	}
+	if err != nil {
+		log.Error().Msgf("Invalid period: %s", period)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			SimpleResponse: SimpleResponse{Result: "nok"},
+			Message:        fmt.Sprintf("Invalid period: %s", period),
+		})
+	}
+	return c.JSON(http.StatusOK, StatsResponse{
+		SimpleResponse: SimpleResponse{Result: "ok"},
+		Stats:          aggregate,
+		Energy:         consumption,
+	})
+}
@@ -14,6 +14,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Context key under which the identity of the API key is stored.
+const identityKey = "identity"
+
 var (
 	instance *WebService
 	once     sync.Once
@@ -22,7 +25,7 @@ var (
 // WebService is a singleton that encapsulates the web server, and retains a cache of valid API keys.
 type WebService struct {
 	echo    *echo.Echo
-	apiKeys map[string]bool
+	apiKeys map[string]string
 }
 
 // GetWebService returns the one and only WebServiceImpl instance.
@@ -37,7 +40,7 @@ func GetWebService() *WebService {
 func newWebService() *WebService {
 	return &WebService{
 		echo:    nil,
-		apiKeys: make(map[string]bool),
+		apiKeys: make(map[string]string),
 	}
 }
 
@@ -51,7 +54,7 @@ func (s *WebService) setUpEcho() {
 		LogValuesFunc: logger,
 	}))
 
-	s.echo.GET("/readyz", healthCheck)
+	s.echo.GET("/readyz", readyCheck)
 	s.echo.GET("/healthz", healthCheck)
 
 	protected := s.echo.Group("")
@@ -61,6 +64,23 @@ func (s *WebService) setUpEcho() {
 	protected.POST("/timer", timerHandler)
 	protected.POST("/away", awayHandler)
 	protected.POST("/auto", autoHandler)
+	protected.POST("/command", commandHandler)
+	protected.GET("/commands", commandsHandler)
+	protected.GET("/queue", queueHandler)
+	protected.DELETE("/queue", flushHandler)
+	protected.DELETE("/queue/:id", cancelHandler)
+	protected.GET("/lockout", lockoutHandler)
+	protected.POST("/lockout", tripHandler)
+	protected.POST("/lockout/reset", resetLockoutHandler)
+	protected.GET("/service", serviceHandler)
+	protected.POST("/service", setServiceHandler)
+	protected.POST("/service/output", outputHandler)
+	protected.GET("/history", historyHandler)
+	protected.GET("/history/verify", historyVerifyHandler)
+	protected.GET("/state", stateHandler)
+	protected.GET("/stats", statsHandler)
+	protected.GET("/maintenance/filter", filterHandler)
+	protected.POST("/maintenance/filter/reset", filterResetHandler)
 
 }
 
@@ -88,16 +108,20 @@ func (s *WebService) Stop() {
 
 // Middleware handler to validate the API key. The API key is first matched against an internal
 // cache of valid keys, then against the list of keys in the configuration file.
+// The identity of a key is its position in the configuration file (e.g. api_keys[0]).
 func (s *WebService) validateAPIKey(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		apiKey := c.Request().Header.Get("x-api-key")
-		if s.apiKeys[apiKey] {
+		if identity, ok := s.apiKeys[apiKey]; ok {
+			c.Set(identityKey, identity)
 			return next(c)
 		}
 
-		for _, digest := range config.GetAPIKeys() {
+		for i, digest := range config.GetAPIKeys() {
 			if err := bcrypt.CompareHashAndPassword([]byte(digest), []byte(apiKey)); err == nil {
-				s.apiKeys[apiKey] = true
+				identity := fmt.Sprintf("api_keys[%d]", i)
+				s.apiKeys[apiKey] = identity
+				c.Set(identityKey, identity)
 				return next(c)
 			}
 		}
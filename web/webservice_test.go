@@ -1,9 +1,11 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -11,6 +13,8 @@ import (
 	"time"
 
 	"github.com/dlefevre/go.ventilation-service/controller"
+	"github.com/dlefevre/go.ventilation-service/history"
+	"github.com/dlefevre/go.ventilation-service/internal/testenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -21,20 +25,37 @@ func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 }
 
+func TestMain(m *testing.M) {
+	os.Exit(testenv.Run(m))
+}
+
 func setup() {
+	if err := history.GetHistoryService().Start(); err != nil {
+		panic(err)
+	}
 	dc := controller.GetVentilationControllerService()
 	dc.Start()
 	ws := GetWebService()
 	ws.Start()
 
-	time.Sleep(500 * time.Microsecond)
+	// Wait for the server to accept connections.
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", "localhost:8000"); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 func teardown() {
 	dc := controller.GetVentilationControllerService()
-	dc.Stop()
+	dc.Stop(context.Background())
 	ws := GetWebService()
 	ws.Stop()
+	history.GetHistoryService().Stop()
+	// Connections to the stopped server must not be reused by the next test.
+	http.DefaultClient.CloseIdleConnections()
 }
 
 func reqHelper(t *testing.T, path string, body string) {
@@ -66,6 +87,27 @@ func reqHelper(t *testing.T, path string, body string) {
 	}
 }
 
+func getHelper(t *testing.T, path string) (string, []byte) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8000%s", path), nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.Header.Add("x-api-key", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	return resp.Header.Get("Content-Type"), respBody
+}
+
 func TestStartStop(t *testing.T) {
 	setup()
 	teardown()
@@ -99,3 +141,222 @@ func TestOther(t *testing.T) {
 	reqHelper(t, "/auto", `{"auto": "true"}`)
 	time.Sleep(8 * time.Second)
 }
+
+func TestHistory(t *testing.T) {
+	setup()
+	defer teardown()
+	from := time.Now().UTC().Add(-time.Second).Format(time.RFC3339)
+	reqHelper(t, "/away", `{"away": "true"}`)
+	time.Sleep(4 * time.Second)
+
+	_, body := getHelper(t, "/history?source=web&from="+from)
+	var response HistoryResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if len(response.Records) == 0 {
+		t.Fatalf("Expected at least one history record")
+	}
+	last := response.Records[len(response.Records)-1]
+	if last.Command != "away" || last.Identity != "api_keys[0]" {
+		t.Fatalf("Unexpected history record: %+v", last)
+	}
+
+	contentType, body := getHelper(t, "/history?format=csv&from="+from)
+	if contentType != "text/csv" || !strings.HasPrefix(string(body), "seq,time,command") {
+		t.Fatalf("Expected CSV export, got %s: %s", contentType, body)
+	}
+}
+
+func TestHistoryVerify(t *testing.T) {
+	setup()
+	defer teardown()
+
+	_, body := getHelper(t, "/history/verify")
+	var response VerifyResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if response.Result != "ok" || !response.Report.Valid {
+		t.Fatalf("Expected an intact history, got %+v", response.Report)
+	}
+}
+
+func TestStateAndStats(t *testing.T) {
+	setup()
+	defer teardown()
+
+	_, body := getHelper(t, "/state")
+	var state StateResponse
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if state.State.Mode == "" {
+		t.Fatalf("Expected the state to contain a mode")
+	}
+
+	_, body = getHelper(t, "/stats?period=week")
+	var stats StatsResponse
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if stats.Stats.Period != "week" || len(stats.Stats.Hours) == 0 {
+		t.Fatalf("Unexpected statistics: %+v", stats.Stats)
+	}
+	if stats.Energy.Currency != "EUR" {
+		t.Fatalf("Unexpected energy consumption: %+v", stats.Energy)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	setup()
+	defer teardown()
+
+	reqHelper(t, "/maintenance/filter/reset", "")
+	_, body := getHelper(t, "/maintenance/filter")
+	var response FilterResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if response.Filter.Due || response.Filter.ReplacedAt.IsZero() {
+		t.Fatalf("Expected a fresh filter, got %+v", response.Filter)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	setup()
+	defer teardown()
+	reqHelper(t, "/command", `{"command": "away"}`)
+
+	_, body := getHelper(t, "/commands")
+	var response CommandsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if len(response.Commands) != 8 {
+		t.Fatalf("Expected the 8 default commands, got %+v", response.Commands)
+	}
+	time.Sleep(4 * time.Second)
+}
+
+func deleteHelper(t *testing.T, path string) (int, []byte) {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://localhost:8000%s", path), nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.Header.Add("x-api-key", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	return resp.StatusCode, respBody
+}
+
+func TestQueue(t *testing.T) {
+	setup()
+	defer teardown()
+	reqHelper(t, "/speed", `{"speed": "1"}`)
+	reqHelper(t, "/speed", `{"speed": "2"}`)
+	reqHelper(t, "/speed", `{"speed": "3"}`)
+	reqHelper(t, "/away", `{"away": "true"}`)
+
+	_, body := getHelper(t, "/queue")
+	var response QueueResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if len(response.Queue) < 2 || response.Queue[0].Source != controller.SourceWeb || response.Queue[0].EstimatedStart.IsZero() {
+		t.Fatalf("Expected the queued commands, got %+v", response.Queue)
+	}
+
+	id := response.Queue[0].ID
+	if status, _ := deleteHelper(t, fmt.Sprintf("/queue/%d", id)); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	if status, _ := deleteHelper(t, fmt.Sprintf("/queue/%d", id)); status != http.StatusNotFound {
+		t.Fatalf("Expected status code 404 for a command that is no longer queued, got %d", status)
+	}
+	status, body := deleteHelper(t, "/queue")
+	var flushed FlushResponse
+	if err := json.Unmarshal(body, &flushed); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if status != http.StatusOK || flushed.Canceled != len(response.Queue)-1 {
+		t.Fatalf("Expected the remaining commands to be canceled, got %d %+v", status, flushed)
+	}
+	if queued := controller.GetVentilationControllerService().Queue(); len(queued) != 0 {
+		t.Fatalf("Expected an empty queue, got %+v", queued)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	setup()
+	defer teardown()
+	reqHelper(t, "/lockout", `{"reason": "smoke detector"}`)
+	defer reqHelper(t, "/lockout/reset", "")
+
+	_, body := getHelper(t, "/lockout")
+	var response LockoutResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if !response.Lockout.Tripped || response.Lockout.Reason != "smoke detector" || response.Lockout.Source != controller.SourceWeb {
+		t.Fatalf("Expected the lockout to be tripped, got %+v", response.Lockout)
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost:8000/command", strings.NewReader(`{"command": "speed1"}`))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.Header.Add("x-api-key", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusLocked {
+		t.Fatalf("Expected status code 423 while locked out, got %d", resp.StatusCode)
+	}
+}
+
+func postStatus(t *testing.T, path string, body string) int {
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:8000%s", path), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.Header.Add("x-api-key", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServiceMode(t *testing.T) {
+	setup()
+	defer teardown()
+	reqHelper(t, "/service", `{"active": true, "duration": 0, "raw_outputs": true}`)
+	defer reqHelper(t, "/service", `{"active": false}`)
+
+	_, body := getHelper(t, "/service")
+	var response ServiceResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if !response.Service.Active || !response.Service.RawOutputs || !response.Service.Until.IsZero() {
+		t.Fatalf("Expected the service mode without expiry, got %+v", response.Service)
+	}
+	reqHelper(t, "/service/output", `{"output": "timer", "active": true}`)
+	reqHelper(t, "/service/output", `{"output": "timer", "active": false}`)
+
+	reqHelper(t, "/service", `{"active": true}`)
+	if status := postStatus(t, "/service/output", `{"output": "timer", "active": true}`); status != http.StatusForbidden {
+		t.Fatalf("Expected status code 403 without raw outputs, got %d", status)
+	}
+}